@@ -1,15 +1,21 @@
 package gslang
 
 import (
+    "math"
+    "math/big"
+    "reflect"
     "sort"
 	"time"
 	"math/rand"
+
+	"github.com/gslang/gslang/parser"
 )
 
 var builtinFuncs = []*BuiltinFunction{
 	{
-		Name:  "len",
-		Value: builtinLen,
+		Name:      "len",
+		Value:     builtinLen,
+		Iterables: true,
 	},
 	{
 		Name:  "type",
@@ -27,6 +33,10 @@ var builtinFuncs = []*BuiltinFunction{
 		Name:  "copy",
 		Value: builtinCopy,
 	},
+	{
+		Name:  "freeze",
+		Value: builtinFreeze,
+	},
 	{
 		Name:  "map_keys",
 		Value: builtinMapKeys,
@@ -36,12 +46,14 @@ var builtinFuncs = []*BuiltinFunction{
 		Value: builtinMapValues,
 	},
 	{
-		Name:  "array_sort",
-		Value: builtinArraySort,
+		Name:      "array_sort", // Value is set by init
+		Iterables: true,
+		NeedVMObj: true,
 	},
 	{
-		Name:  "array_rand",
-		Value: builtinArrayRand,
+		Name:      "array_rand",
+		Value:     builtinArrayRand,
+		Iterables: true,
 	},
 	{
 		Name:  "array_push",
@@ -60,16 +72,19 @@ var builtinFuncs = []*BuiltinFunction{
 		Value: builtinArrayShift,
 	},
 	{
-		Name:  "array_reverse",
-		Value: builtinArrayReverse,
+		Name:      "array_reverse",
+		Value:     builtinArrayReverse,
+		Iterables: true,
 	},
 	{
-		Name:  "array_unique",
-		Value: builtinArrayUnique,
+		Name:      "array_unique",
+		Value:     builtinArrayUnique,
+		Iterables: true,
 	},
 	{
-		Name:  "array_column",
-		Value: builtinArrayColumn,
+		Name:      "array_column",
+		Value:     builtinArrayColumn,
+		Iterables: true,
 	},
 	{
 		Name:  "array_splice",
@@ -143,6 +158,10 @@ var builtinFuncs = []*BuiltinFunction{
 		Name:  "is_map",
 		Value: builtinIsMap,
 	},
+	{
+		Name:  "is_immutable",
+		Value: builtinIsImmutable,
+	},
 	{
 		Name:  "is_function",
 		Value: builtinIsFunction,
@@ -163,6 +182,52 @@ var builtinFuncs = []*BuiltinFunction{
 		Name:  "is_nil",
 		Value: builtinIsNil,
 	},
+	{
+		Name:  "bigint",
+		Value: builtinBigInt,
+	},
+	{
+		Name:  "decimal",
+		Value: builtinDecimal,
+	},
+	{
+		Name:  "is_bigint",
+		Value: builtinIsBigInt,
+	},
+	{
+		Name:  "is_decimal",
+		Value: builtinIsDecimal,
+	},
+	{
+		Name:  "checked",
+		Value: builtinChecked,
+	},
+}
+
+func init() {
+	// the builtin functions running the VM are set here, as the VM refers to
+	// builtinFuncs
+	for _, fn := range builtinFuncs {
+		if fn.Name == "array_sort" {
+			fn.Value = builtinArraySort
+		}
+	}
+	builtinFuncs = append(builtinFuncs, []*BuiltinFunction{
+		{
+			Name:      "spawn",
+			Value:     builtinSpawn,
+			NeedVMObj: true,
+		},
+		{
+			Name:  "chan",
+			Value: builtinChan,
+		},
+		{
+			Name:      "select",
+			Value:     builtinSelect,
+			NeedVMObj: true,
+		},
+	}...)
 }
 
 // GetAllBuiltinFunctions returns all builtin function objects.
@@ -184,10 +249,12 @@ func builtinLen(args ...Object) (Object, error) {
 		return &Int{Value: int64(len(arg.Value))}, nil
 	case *Map:
 		return &Int{Value: int64(len(arg.Value))}, nil
+	case *Range:
+		return &Int{Value: arg.len()}, nil
 	default:
 		return nil, ErrInvalidArgumentType{
 			Name:     "first",
-			Expected: "array/string/bytes/map",
+			Expected: "array/string/bytes/map/range",
 			Found:    arg.TypeName(),
 		}
 	}
@@ -244,25 +311,7 @@ func builtinRange(args ...Object) (Object, error) {
 		step = &Int{Value: int64(1)}
 	}
 
-	return buildRange(start.Value, stop.Value, step.Value), nil
-}
-
-func buildRange(start, stop, step int64) *Array {
-	array := &Array{}
-	if start <= stop {
-		for i := start; i < stop; i += step {
-			array.Value = append(array.Value, &Int{
-				Value: i,
-			})
-		}
-	} else {
-		for i := start; i > stop; i -= step {
-			array.Value = append(array.Value, &Int{
-				Value: i,
-			})
-		}
-	}
-	return array
+	return newRange(start.Value, stop.Value, step.Value), nil
 }
 
 func builtinFormat(args ...Object) (Object, error) {
@@ -292,6 +341,122 @@ func builtinCopy(args ...Object) (Object, error) {
 	return args[0].Copy(), nil
 }
 
+// builtinFreeze returns an immutable copy of the value and the arrays and
+// maps in it.
+// usage: freeze(value)
+func builtinFreeze(args ...Object) (Object, error) {
+	if len(args) != 1 {
+		return nil, ErrWrongNumArguments
+	}
+	return Freeze(args[0]), nil
+}
+
+// builtinSpawn calls the function with the arguments on a new VM in its own
+// goroutine, and returns the routine. The routine gets its own copies of the
+// function, the arguments and the globals; use channels to communicate.
+// Generators and iterators cannot be passed to a routine.
+// usage: spawn(fn, args...)
+func builtinSpawn(args ...Object) (Object, error) {
+	if len(args) < 2 {
+		return nil, ErrWrongNumArguments
+	}
+	vm, ok := args[0].(*VMObj)
+	if !ok {
+		return nil, ErrWrongNumArguments
+	}
+	if !args[1].CanCall() {
+		return nil, ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "callable",
+			Found:    args[1].TypeName(),
+		}
+	}
+	r, err := vm.Value.spawn(args[1], args[2:])
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// builtinChan creates a channel with an optional buffer size.
+// usage: chan([size])
+func builtinChan(args ...Object) (Object, error) {
+	if len(args) > 1 {
+		return nil, ErrWrongNumArguments
+	}
+	var size int
+	if len(args) == 1 {
+		var ok bool
+		if size, ok = ToInt(args[0]); !ok || size < 0 {
+			return nil, ErrInvalidArgumentType{
+				Name:     "first",
+				Expected: "non-negative int",
+				Found:    args[0].TypeName(),
+			}
+		}
+	}
+	return &Channel{Value: make(chan Object, size)}, nil
+}
+
+// builtinSelect receives a value from whichever of the channels is ready
+// first, and returns an array of the index of the channel and the value. The
+// value is nil if the channel is closed. If the optional timeout in
+// milliseconds expires, the index is -1.
+// usage: select(channels[, timeout])
+func builtinSelect(args ...Object) (Object, error) {
+	abort, args, err := vmAbortChan(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 || len(args) > 2 {
+		return nil, ErrWrongNumArguments
+	}
+	arr, ok := toArray(args[0])
+	if !ok {
+		return nil, ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "array",
+			Found:    args[0].TypeName(),
+		}
+	}
+	timeout, err := timeoutArg(args, 1, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	cases := make([]reflect.SelectCase, 0, len(arr.Value)+2)
+	for _, elem := range arr.Value {
+		ch, ok := elem.(*Channel)
+		if !ok {
+			return nil, ErrInvalidArgumentType{
+				Name:     "first",
+				Expected: "array of channels",
+				Found:    elem.TypeName(),
+			}
+		}
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(ch.Value),
+		})
+	}
+	cases = append(cases,
+		reflect.SelectCase{Dir: reflect.SelectRecv,
+			Chan: reflect.ValueOf(timeout)},
+		reflect.SelectCase{Dir: reflect.SelectRecv,
+			Chan: reflect.ValueOf(abort)})
+
+	chosen, recv, ok := reflect.Select(cases)
+	if chosen >= len(arr.Value) {
+		// timeout or abort
+		return &Array{Value: []Object{&Int{Value: -1}, NilValue}}, nil
+	}
+	var val Object = NilValue
+	if ok {
+		val = recv.Interface().(Object)
+	}
+	return &Array{Value: []Object{&Int{Value: int64(chosen)}, val}}, nil
+}
+
 func builtinMapKeys(args ...Object) (Object, error) {
 	if len(args) != 1 {
 		return nil, ErrWrongNumArguments
@@ -333,11 +498,20 @@ func builtinMapValues(args ...Object) (Object, error) {
 }
 
 func builtinArraySort(args ...Object) (Object, error) {
+	if len(args) < 1 {
+		return nil, ErrWrongNumArguments
+	}
+	vmObj, ok := args[0].(*VMObj)
+	if !ok {
+		return nil, ErrWrongNumArguments
+	}
+	vm := vmObj.Value
+	args = args[1:]
 	argsLen := len(args)
 	if argsLen < 1 || argsLen > 2 {
 		return nil, ErrWrongNumArguments
 	}
-	a, ok := args[0].(*Array)
+	a, ok := toArray(args[0])
 	if !ok {
 		return nil, ErrInvalidArgumentType{
 			Name:     "array",
@@ -389,6 +563,28 @@ func builtinArraySort(args ...Object) (Object, error) {
 			}
 			return res[i].(*String).Value < res[j].(*String).Value
 		})
+	default:
+		// compared like the > operator, including overloaded comparisons
+		res = append(res, a.Value...)
+		var err error
+		sort.Slice(res, func(i int, j int) bool {
+			if err != nil {
+				return false
+			}
+			x, y := res[j], res[i]
+			if c {
+				x, y = y, x
+			}
+			gt := vm.binaryOp(parser.TokenGreater, x, y)
+			if vm.err != nil {
+				err = vm.err
+				return false
+			}
+			return !gt.IsFalsy()
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &Array{Value:res}, nil
@@ -398,7 +594,7 @@ func builtinArrayRand(args ...Object) (Object, error) {
 	if len(args) != 1 {
 		return nil, ErrWrongNumArguments
 	}
-	a, ok := args[0].(*Array)
+	a, ok := toArray(args[0])
 	if !ok {
 		return nil, ErrInvalidArgumentType{
 			Name:     "array",
@@ -418,7 +614,7 @@ func builtinArrayPush(args ...Object) (Object, error) {
 	if len(args) < 1 {
 		return nil, ErrWrongNumArguments
 	}
-	a, ok := args[0].(*Array)
+	a, ok := toArray(args[0])
 	if !ok {
 		return nil, ErrInvalidArgumentType{
 			Name:     "array",
@@ -433,7 +629,7 @@ func builtinArrayPop(args ...Object) (Object, error) {
 	if len(args) != 1 {
 		return nil, ErrWrongNumArguments
 	}
-	a, ok := args[0].(*Array)
+	a, ok := toArray(args[0])
 	if !ok {
 		return nil, ErrInvalidArgumentType{
 			Name:     "array",
@@ -441,6 +637,9 @@ func builtinArrayPop(args ...Object) (Object, error) {
 			Found:    a.TypeName(),
 		}
 	}
+	if a.Immutable {
+		return nil, ErrImmutable
+	}
 	l := len(a.Value) - 1
 	e := a.Value[l]
 	a.Value = a.Value[:l]
@@ -451,7 +650,7 @@ func builtinArrayUnShift(args ...Object) (Object, error) {
 	if len(args) < 1 {
 		return nil, ErrWrongNumArguments
 	}
-	a, ok := args[0].(*Array)
+	a, ok := toArray(args[0])
 	if !ok {
 		return nil, ErrInvalidArgumentType{
 			Name:     "array",
@@ -466,7 +665,7 @@ func builtinArrayShift(args ...Object) (Object, error) {
 	if len(args) != 1 {
 		return nil, ErrWrongNumArguments
 	}
-	a, ok := args[0].(*Array)
+	a, ok := toArray(args[0])
 	if !ok {
 		return nil, ErrInvalidArgumentType{
 			Name:     "array",
@@ -474,6 +673,9 @@ func builtinArrayShift(args ...Object) (Object, error) {
 			Found:    a.TypeName(),
 		}
 	}
+	if a.Immutable {
+		return nil, ErrImmutable
+	}
 	f := a.Value[0]
 	a.Value = a.Value[1:]
 	return f, nil
@@ -483,7 +685,7 @@ func builtinArrayReverse(args ...Object) (Object, error) {
 	if len(args) != 1 {
 		return nil, ErrWrongNumArguments
 	}
-	a, ok := args[0].(*Array)
+	a, ok := toArray(args[0])
 	if !ok {
 		return nil, ErrInvalidArgumentType{
 			Name:     "array",
@@ -491,6 +693,9 @@ func builtinArrayReverse(args ...Object) (Object, error) {
 			Found:    a.TypeName(),
 		}
 	}
+	if a.Immutable {
+		return nil, ErrImmutable
+	}
 	for i, j := 0, len(a.Value)-1; i < j; i, j = i+1, j-1 {
 		a.Value[i], a.Value[j] = a.Value[j], a.Value[i]
 	}
@@ -501,7 +706,7 @@ func builtinArrayUnique(args ...Object) (Object, error) {
 	if len(args) != 1 {
 		return nil, ErrWrongNumArguments
 	}
-	a, ok := args[0].(*Array)
+	a, ok := toArray(args[0])
 	if !ok {
 		return nil, ErrInvalidArgumentType{
 			Name:     "array",
@@ -524,7 +729,7 @@ func builtinArrayColumn(args ...Object) (Object, error) {
 	if len(args) != 2 {
 		return nil, ErrWrongNumArguments
 	}
-	a, ok := args[0].(*Array)
+	a, ok := toArray(args[0])
 	if !ok {
 		return nil, ErrInvalidArgumentType{
 			Name:     "array",
@@ -566,7 +771,7 @@ func builtinArraySplice(args ...Object) (Object, error) {
 		return nil, ErrWrongNumArguments
 	}
 
-	array, ok := args[0].(*Array)
+	array, ok := toArray(args[0])
 	if !ok {
 		return nil, ErrInvalidArgumentType{
 			Name:     "first",
@@ -574,6 +779,9 @@ func builtinArraySplice(args ...Object) (Object, error) {
 			Found:    args[0].TypeName(),
 		}
 	}
+	if array.Immutable {
+		return nil, ErrImmutable
+	}
 	arrayLen := len(array.Value)
 
 	var startIdx int
@@ -637,6 +845,9 @@ func builtinAppend(args ...Object) (Object, error) {
 	}
 	switch arg := args[0].(type) {
 	case *Map:
+		if arg.Immutable {
+			return nil, ErrImmutable
+		}
 		for _, m := range args[1:] {
 			m1, ok := m.(*Map)
 			if !ok {
@@ -653,6 +864,8 @@ func builtinAppend(args ...Object) (Object, error) {
 		return &Map{Value:arg.Value}, nil
 	case *Array:
 		return &Array{Value: append(arg.Value, args[1:]...)}, nil
+	case *Range:
+		return &Array{Value: append(arg.appendTo(nil), args[1:]...)}, nil
 	default:
 		return nil, ErrInvalidArgumentType{
 			Name:     "first",
@@ -671,6 +884,9 @@ func builtinDelete(args ...Object) (Object, error) {
 	}
 	switch arg := args[0].(type) {
 	case *Map:
+		if arg.Immutable {
+			return nil, ErrImmutable
+		}
 		if key, ok := args[1].(*String); ok {
 			delete(arg.Value, key.Value)
 			return NilValue, nil
@@ -681,6 +897,9 @@ func builtinDelete(args ...Object) (Object, error) {
 			Found:    args[1].TypeName(),
 		}
 	case *Array:
+		if arg.Immutable {
+			return nil, ErrImmutable
+		}
 		if key, ok := args[1].(*Int); ok {
 			arg.Value = append(arg.Value[:key.Value], arg.Value[key.Value+1:]...)
 			return NilValue, nil
@@ -690,6 +909,8 @@ func builtinDelete(args ...Object) (Object, error) {
 			Expected: "int",
 			Found:    args[1].TypeName(),
 		}
+	case *Range:
+		return builtinDelete(arg.array(), args[1])
 	default:
 		return nil, ErrInvalidArgumentType{
 			Name:     "first",
@@ -719,6 +940,8 @@ func builtinExists(args ...Object) (Object, error) {
 			}
 		}
 		return &Bool{Value:false}, nil
+	case *Range:
+		return builtinExists(&Array{Value: arg.appendTo(nil)}, args[1])
 	default:
 		return nil, ErrInvalidArgumentType{
 			Name:     "first",
@@ -802,6 +1025,66 @@ func builtinFloat(args ...Object) (Object, error) {
 	return NilValue, nil
 }
 
+func builtinBigInt(args ...Object) (Object, error) {
+	argsLen := len(args)
+	if !(argsLen == 1 || argsLen == 2) {
+		return nil, ErrWrongNumArguments
+	}
+	switch o := args[0].(type) {
+	case *BigInt:
+		return o, nil
+	case *Int:
+		return &BigInt{Value: big.NewInt(o.Value)}, nil
+	case *Float:
+		if !math.IsInf(o.Value, 0) && !math.IsNaN(o.Value) {
+			v, _ := big.NewFloat(o.Value).Int(nil)
+			return &BigInt{Value: v}, nil
+		}
+	case *Decimal:
+		return &BigInt{Value: o.Int()}, nil
+	case *String:
+		if v, ok := new(big.Int).SetString(o.Value, 0); ok {
+			return newBigInt(v)
+		}
+	}
+	if argsLen == 2 {
+		return args[1], nil
+	}
+	return NilValue, nil
+}
+
+func builtinDecimal(args ...Object) (Object, error) {
+	argsLen := len(args)
+	if !(argsLen == 1 || argsLen == 2) {
+		return nil, ErrWrongNumArguments
+	}
+	if v, ok := toDecimal(args[0]); ok {
+		return v, nil
+	}
+	if s, ok := args[0].(*String); ok {
+		v, err := ParseDecimal(s.Value)
+		if err == nil {
+			return v, nil
+		} else if err == ErrBigIntLimit {
+			return nil, err
+		}
+	}
+	if argsLen == 2 {
+		return args[1], nil
+	}
+	return NilValue, nil
+}
+
+// builtinChecked returns its argument. A direct call checked(expr) is compiled
+// into expr in checked arithmetic mode, so it's only called indirectly, e.g.
+// f := checked; f(x).
+func builtinChecked(args ...Object) (Object, error) {
+	if len(args) != 1 {
+		return nil, ErrWrongNumArguments
+	}
+	return args[0], nil
+}
+
 func builtinChar(args ...Object) (Object, error) {
 	argsLen := len(args)
 	if !(argsLen == 1 || argsLen == 2) {
@@ -876,6 +1159,26 @@ func builtinIsFloat(args ...Object) (Object, error) {
 	return FalseValue, nil
 }
 
+func builtinIsBigInt(args ...Object) (Object, error) {
+	if len(args) != 1 {
+		return nil, ErrWrongNumArguments
+	}
+	if _, ok := args[0].(*BigInt); ok {
+		return TrueValue, nil
+	}
+	return FalseValue, nil
+}
+
+func builtinIsDecimal(args ...Object) (Object, error) {
+	if len(args) != 1 {
+		return nil, ErrWrongNumArguments
+	}
+	if _, ok := args[0].(*Decimal); ok {
+		return TrueValue, nil
+	}
+	return FalseValue, nil
+}
+
 func builtinIsBool(args ...Object) (Object, error) {
 	if len(args) != 1 {
 		return nil, ErrWrongNumArguments
@@ -910,7 +1213,8 @@ func builtinIsArray(args ...Object) (Object, error) {
 	if len(args) != 1 {
 		return nil, ErrWrongNumArguments
 	}
-	if _, ok := args[0].(*Array); ok {
+	switch args[0].(type) {
+	case *Array, *Range:
 		return TrueValue, nil
 	}
 	return FalseValue, nil
@@ -926,6 +1230,23 @@ func builtinIsMap(args ...Object) (Object, error) {
 	return FalseValue, nil
 }
 
+func builtinIsImmutable(args ...Object) (Object, error) {
+	if len(args) != 1 {
+		return nil, ErrWrongNumArguments
+	}
+	switch arg := args[0].(type) {
+	case *Array:
+		if arg.Immutable {
+			return TrueValue, nil
+		}
+	case *Map:
+		if arg.Immutable {
+			return TrueValue, nil
+		}
+	}
+	return FalseValue, nil
+}
+
 func builtinIsFunction(args ...Object) (Object, error) {
 	if len(args) != 1 {
 		return nil, ErrWrongNumArguments
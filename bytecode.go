@@ -242,6 +242,14 @@ func updateConstIndexes(insts []byte, indexMap map[int]int) {
 				panic(fmt.Errorf("constant index not found: %d", curIdx))
 			}
 			copy(insts[i:], MakeInstruction(op, newIdx, numFree))
+		case parser.OpSwitch:
+			curIdx := int(insts[i+2]) | int(insts[i+1])<<8
+			dst := int(insts[i+4]) | int(insts[i+3])<<8
+			newIdx, ok := indexMap[curIdx]
+			if !ok {
+				panic(fmt.Errorf("constant index not found: %d", curIdx))
+			}
+			copy(insts[i:], MakeInstruction(op, newIdx, dst))
 		}
 
 		i += 1 + read
@@ -267,6 +275,7 @@ func init() {
 	gob.Register(&Float{})
 	gob.Register(&Int{})
 	gob.Register(&Map{})
+	gob.Register(&Range{})
 	gob.Register(&String{})
 	gob.Register(&Time{})
 	gob.Register(&Nil{})
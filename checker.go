@@ -0,0 +1,1624 @@
+package gslang
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gslang/gslang/parser"
+)
+
+// CheckError represents an error or a warning reported by the checker.
+type CheckError struct {
+	Pos     parser.FilePos
+	Warning bool
+	Msg     string
+}
+
+func (e *CheckError) Error() string {
+	if e.Warning {
+		return fmt.Sprintf("Check Warning: %s\n\tat %s", e.Msg, e.Pos)
+	}
+	return fmt.Sprintf("Check Error: %s\n\tat %s", e.Msg, e.Pos)
+}
+
+// Checker verifies the optional type annotations of the parameters, results
+// and variables. The annotations are ignored by the compiler, so the checker
+// is a separate pass that does not affect how the script runs. The types of
+// the expressions without annotations are inferred where possible, and are
+// any otherwise.
+type Checker struct {
+	file     *parser.Code
+	globals  map[string]*checkType
+	errs     []*CheckError
+	report   bool
+	assigned map[string]bool // names of the reassigned variables
+	sigs     map[*parser.FuncType]*checkType
+	scope    *checkScope
+	funcs    []*checkFunc
+}
+
+// NewChecker creates a Checker for the source file.
+func NewChecker(file *parser.Code) *Checker {
+	return &Checker{
+		file:    file,
+		globals: make(map[string]*checkType),
+	}
+}
+
+// Define declares a global variable defined outside the script, such as the
+// variables added to a Script, with the type of its value.
+func (c *Checker) Define(name string, value Object) {
+	c.globals[name] = typeOfObject(value)
+}
+
+// Check verifies the file and returns the errors and the warnings sorted by
+// their positions.
+func (c *Checker) Check(file *parser.File) []*CheckError {
+	// the first pass only finds the reassigned variables, whose types are
+	// not inferred from their definitions
+	c.assigned = make(map[string]bool)
+	c.run(file, false)
+	c.run(file, true)
+
+	sort.SliceStable(c.errs, func(i, j int) bool {
+		return c.errs[i].Pos.Offset < c.errs[j].Pos.Offset
+	})
+	return c.errs
+}
+
+func (c *Checker) run(file *parser.File, report bool) {
+	c.report = report
+	c.errs = nil
+	c.sigs = make(map[*parser.FuncType]*checkType)
+	c.funcs = nil
+	c.scope = newCheckScope(nil)
+	c.stmts(file.Stmts)
+}
+
+func (c *Checker) errorf(node parser.Node, format string, args ...interface{}) {
+	c.add(node.Pos(), false, fmt.Sprintf(format, args...))
+}
+
+func (c *Checker) warnf(node parser.Node, format string, args ...interface{}) {
+	c.add(node.Pos(), true, fmt.Sprintf(format, args...))
+}
+
+func (c *Checker) add(pos parser.Pos, warning bool, msg string) {
+	if !c.report {
+		return
+	}
+	c.errs = append(c.errs, &CheckError{
+		Pos:     c.file.Position(pos),
+		Warning: warning,
+		Msg:     msg,
+	})
+}
+
+// assign reports whether a value of type src can be used as a value of type
+// dst. context describes the use in the messages.
+func (c *Checker) assign(
+	dst, src *checkType,
+	node parser.Node,
+	context string,
+) {
+	res := assignable(dst, src)
+	if res == assignMaybe && src.lits != nil && dst.kind == src.kind &&
+		isLiteral(node) {
+		// the elements of a literal are checked one by one
+		res = assignOK
+		for _, t := range src.lits {
+			if r := assignable(dst.elem, t); r < res {
+				res = r
+			}
+		}
+	}
+	switch res {
+	case assignNo:
+		c.errorf(node, "cannot use value of type %s as %s in %s",
+			src, dst, context)
+	case assignMaybe:
+		c.warnf(node, "value of type %s may not be %s in %s",
+			src, dst, context)
+	}
+}
+
+// isLiteral returns true if the expression is an array or a map literal.
+func isLiteral(x parser.Node) bool {
+	for {
+		switch y := x.(type) {
+		case *parser.ParenExpr:
+			x = y.Expr
+		case *parser.ArrayLit, *parser.MapLit:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+func (c *Checker) openScope() {
+	c.scope = newCheckScope(c.scope)
+}
+
+func (c *Checker) closeScope() {
+	c.scope = c.scope.parent
+}
+
+func (c *Checker) define(name string, typ *checkType, annotated bool) {
+	c.scope.vars[name] = &checkVar{typ: typ, annotated: annotated}
+}
+
+// defineInferred defines a variable without a type annotation. The element
+// types of arrays and maps are not inferred as the elements may change.
+func (c *Checker) defineInferred(name string, typ *checkType) {
+	if c.assigned[name] {
+		typ = anyType
+	}
+	c.define(name, widen(typ), false)
+}
+
+func (c *Checker) lookup(name string) *checkVar {
+	for s := c.scope; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+	if typ, ok := c.globals[name]; ok {
+		return &checkVar{typ: typ}
+	}
+	if typ, ok := builtinTypes[name]; ok {
+		return &checkVar{typ: typ}
+	}
+	return nil
+}
+
+func (c *Checker) lookupType(name string) *checkType {
+	for s := c.scope; s != nil; s = s.parent {
+		if typ, ok := s.types[name]; ok {
+			return typ
+		}
+	}
+	return nil
+}
+
+// resolveType returns the type of the type annotation.
+func (c *Checker) resolveType(x parser.Expr) *checkType {
+	switch x := x.(type) {
+	case *parser.Ident:
+		switch x.Name {
+		case "any":
+			return anyType
+		case "array":
+			return arrayOf(anyType)
+		case "map":
+			return mapOf(anyType)
+		case "func":
+			return &checkType{kind: typeFunc}
+		}
+		if typ, ok := basicTypes[x.Name]; ok {
+			return typ
+		}
+		if typ := c.lookupType(x.Name); typ != nil {
+			return typ
+		}
+		c.errorf(x, "unknown type '%s'", x.Name)
+	case *parser.ArrayType:
+		return arrayOf(c.resolveType(x.Elem))
+	case *parser.MapType:
+		key := c.resolveType(x.Key)
+		if key.kind != typeString && key.kind != typeAny {
+			c.errorf(x.Key, "invalid map key type %s: keys are strings", key)
+		}
+		return mapOf(c.resolveType(x.Value))
+	case *parser.FuncSigType:
+		sig := &funcSig{varArgs: x.VarArgs, result: anyType}
+		for i, p := range x.Params {
+			typ := c.resolveType(p)
+			if x.VarArgs && i == len(x.Params)-1 {
+				typ = c.varArgsType(p, typ)
+			} else {
+				sig.required++
+			}
+			sig.params = append(sig.params, typ)
+		}
+		if x.Result != nil {
+			sig.result = c.resolveType(x.Result)
+		}
+		return &checkType{kind: typeFunc, sig: sig}
+	case *parser.UnionType:
+		var types []*checkType
+		for _, t := range x.Types {
+			types = append(types, c.resolveType(t))
+		}
+		return unionOf(types...)
+	case *parser.NullableType:
+		return unionOf(c.resolveType(x.Type), nilType)
+	case *parser.ParenExpr:
+		return c.resolveType(x.Expr)
+	}
+	return anyType
+}
+
+// varArgsType returns the type of the variadic parameter annotated with typ,
+// which is the type of the array of the variadic arguments.
+func (c *Checker) varArgsType(node parser.Node, typ *checkType) *checkType {
+	switch typ.kind {
+	case typeArray:
+		return typ
+	case typeAny:
+		return arrayOf(anyType)
+	}
+	c.errorf(node, "invalid variadic parameter type %s: must be an array",
+		typ)
+	return arrayOf(anyType)
+}
+
+// funcType returns the type of the function literal from its annotations.
+// The result type of a function without the annotation is inferred once its
+// body is checked.
+func (c *Checker) funcType(t *parser.FuncType) *checkType {
+	if typ, ok := c.sigs[t]; ok {
+		return typ
+	}
+	params := t.Params
+	sig := &funcSig{varArgs: params.VarArgs, result: anyType}
+	for i, p := range params.List {
+		typ := anyType
+		if x := params.Type(i); x != nil {
+			typ = c.resolveType(x)
+		}
+		if params.VarArgs && i == len(params.List)-1 {
+			typ = c.varArgsType(params.Type(i), typ)
+		} else if params.Default(i) == nil {
+			sig.required++
+		}
+		sig.params = append(sig.params, typ)
+		sig.names = append(sig.names, p.Name)
+	}
+	if t.Result != nil {
+		sig.result = c.resolveType(t.Result)
+	}
+	typ := &checkType{kind: typeFunc, sig: sig}
+	c.sigs[t] = typ
+	return typ
+}
+
+func (c *Checker) funcLit(x *parser.FuncLit, self *checkType) *checkType {
+	typ := c.funcType(x.Type)
+	sig := typ.sig
+	params := x.Type.Params
+
+	c.openScope()
+	defer c.closeScope()
+	if self != nil {
+		c.define("self", self, false)
+	}
+	for i, p := range params.List {
+		if d := params.Default(i); d != nil {
+			t := c.expr(d)
+			if params.Type(i) != nil {
+				c.assign(sig.params[i], t, d, "default value of "+p.Name)
+			}
+		}
+		c.define(p.Name, sig.params[i], params.Type(i) != nil)
+	}
+
+	fn := &checkFunc{}
+	if x.Type.Result != nil {
+		fn.result = sig.result
+	}
+	c.funcs = append(c.funcs, fn)
+	c.stmts(x.Body.Stmts)
+	c.funcs = c.funcs[:len(c.funcs)-1]
+
+	switch {
+	case fn.yield:
+		// generator function returns a generator
+		sig.result = anyType
+	case fn.result == nil:
+		if !terminates(x.Body) {
+			fn.returns = append(fn.returns, nilType)
+		}
+		sig.result = unionOf(fn.returns...)
+	case !terminates(x.Body) && assignable(fn.result, nilType) != assignOK:
+		c.add(x.Body.RBrace, true, fmt.Sprintf(
+			"missing return at end of function returning %s", fn.result))
+	}
+	return typ
+}
+
+func (c *Checker) stmts(stmts []parser.Stmt) {
+	c.declareClasses(stmts)
+	for _, s := range stmts {
+		c.stmt(s)
+	}
+}
+
+// declareClasses declares the classes of the block before its statements are
+// checked, so that the type annotations can refer to them.
+func (c *Checker) declareClasses(stmts []parser.Stmt) {
+	var classes []*parser.ClassStmt
+	for _, s := range stmts {
+		if s, ok := s.(*parser.ClassStmt); ok {
+			classes = append(classes, s)
+			c.scope.types[s.Name.Name] = &checkType{
+				kind: typeClass,
+				class: &checkClass{
+					name:    s.Name.Name,
+					methods: make(map[string]*checkType),
+				},
+			}
+		}
+	}
+	// the methods may refer to any of the classes
+	for _, s := range classes {
+		class := c.scope.types[s.Name.Name].class
+		for _, elt := range s.Body.Elements {
+			if fn, ok := elt.Value.(*parser.FuncLit); ok {
+				class.methods[elt.Key] = c.funcType(fn.Type)
+			}
+		}
+	}
+}
+
+func (c *Checker) stmt(s parser.Stmt) {
+	switch s := s.(type) {
+	case *parser.AssignStmt:
+		c.assignStmt(s)
+	case *parser.ExprStmt:
+		c.expr(s.Expr)
+	case *parser.IncDecStmt:
+		c.expr(s.Expr)
+	case *parser.BlockStmt:
+		c.openScope()
+		c.stmts(s.Stmts)
+		c.closeScope()
+	case *parser.IfStmt:
+		c.openScope()
+		if s.Init != nil {
+			c.stmt(s.Init)
+		}
+		c.expr(s.Cond)
+		c.stmt(s.Body)
+		if s.Else != nil {
+			c.stmt(s.Else)
+		}
+		c.closeScope()
+	case *parser.ForStmt:
+		c.openScope()
+		if s.Init != nil {
+			c.stmt(s.Init)
+		}
+		if s.Cond != nil {
+			c.expr(s.Cond)
+		}
+		if s.Post != nil {
+			c.stmt(s.Post)
+		}
+		c.stmt(s.Body)
+		c.closeScope()
+	case *parser.ForInStmt:
+		c.openScope()
+		key, value := iterTypes(c.expr(s.Iterable))
+		c.defineInferred(s.Key.Name, key)
+		c.defineInferred(s.Value.Name, value)
+		c.stmt(s.Body)
+		c.closeScope()
+	case *parser.SwitchStmt:
+		c.openScope()
+		if s.Init != nil {
+			c.stmt(s.Init)
+		}
+		if s.Tag != nil {
+			c.expr(s.Tag)
+		}
+		for _, clause := range s.Body.Stmts {
+			clause := clause.(*parser.CaseClause)
+			for _, x := range clause.List {
+				c.expr(x)
+			}
+			c.openScope()
+			c.stmts(clause.Body)
+			c.closeScope()
+		}
+		c.closeScope()
+	case *parser.TryStmt:
+		c.stmt(s.Body)
+		if s.Catch != nil {
+			c.openScope()
+			if s.CatchIdent != nil {
+				c.define(s.CatchIdent.Name, anyType, false)
+			}
+			c.stmts(s.Catch.Stmts)
+			c.closeScope()
+		}
+		if s.Finally != nil {
+			c.stmt(s.Finally)
+		}
+	case *parser.LabeledStmt:
+		c.stmt(s.Stmt)
+	case *parser.ReturnStmt:
+		typ := nilType
+		var node parser.Node = s
+		if s.Result != nil {
+			typ = c.expr(s.Result)
+			node = s.Result
+		}
+		if len(c.funcs) == 0 {
+			break
+		}
+		fn := c.funcs[len(c.funcs)-1]
+		if fn.result != nil {
+			c.assign(fn.result, typ, node, "return")
+		} else {
+			fn.returns = append(fn.returns, typ)
+		}
+	case *parser.YieldStmt:
+		if len(c.funcs) > 0 {
+			c.funcs[len(c.funcs)-1].yield = true
+		}
+		if s.Key != nil {
+			c.expr(s.Key)
+		}
+		if s.Value != nil {
+			c.expr(s.Value)
+		}
+	case *parser.ExportStmt:
+		if s.Result != nil {
+			c.expr(s.Result)
+		} else {
+			c.stmt(s.Decl)
+		}
+	case *parser.ConstStmt:
+		c.defineInferred(s.Name.Name, c.expr(s.Value))
+	case *parser.ClassStmt:
+		c.classStmt(s)
+	case *parser.DeferStmt:
+		c.call(s.Call)
+	case *parser.ThrowStmt:
+		c.expr(s.Expr)
+	}
+}
+
+func (c *Checker) assignStmt(s *parser.AssignStmt) {
+	if len(s.LHS) > 1 || len(s.RHS) > 1 || isPattern(s.LHS[0]) {
+		// destructuring assignment
+		for _, x := range s.RHS {
+			c.expr(x)
+		}
+		for _, x := range s.LHS {
+			c.pattern(x, s.Token == parser.TokenDefine)
+		}
+		return
+	}
+
+	lhs, rhs := s.LHS[0], s.RHS[0]
+	switch s.Token {
+	case parser.TokenDefine:
+		ident, ok := lhs.(*parser.Ident)
+		if !ok {
+			c.expr(rhs)
+			return
+		}
+		var typ *checkType
+		if s.Type != nil {
+			typ = c.resolveType(s.Type)
+		}
+		if fn, ok := rhs.(*parser.FuncLit); ok && typ == nil {
+			// defined before the body is checked so that it can call itself
+			c.define(ident.Name, c.funcType(fn.Type), false)
+		}
+		value := c.expr(rhs)
+		if typ != nil {
+			c.assign(typ, value, rhs, "definition of "+ident.Name)
+			c.define(ident.Name, typ, true)
+		} else {
+			c.defineInferred(ident.Name, value)
+		}
+	default:
+		typ, checked := c.target(lhs)
+		value := c.expr(rhs)
+		if s.Token != parser.TokenAssign {
+			// the compound assignment operators are in the same order as
+			// the binary operators
+			op := s.Token - parser.TokenAddAssign + parser.TokenAdd
+			value = c.binary(s, op, typ, value)
+		}
+		if !checked {
+			return
+		}
+		context := "assignment to element"
+		if ident, ok := lhs.(*parser.Ident); ok {
+			context = "assignment to " + ident.Name
+		}
+		c.assign(typ, value, rhs, context)
+	}
+}
+
+// target returns the type of the left-hand side of an assignment, and
+// whether the assigned value is checked against the type.
+func (c *Checker) target(lhs parser.Expr) (typ *checkType, checked bool) {
+	switch x := lhs.(type) {
+	case *parser.Ident:
+		c.assigned[x.Name] = true
+		if v := c.lookup(x.Name); v != nil {
+			return v.typ, v.annotated
+		}
+	case *parser.IndexExpr:
+		container := c.expr(x.Expr)
+		c.expr(x.Index)
+		if container.kind == typeArray || container.kind == typeMap {
+			return container.elem, true
+		}
+	case *parser.SelectorExpr:
+		if container := c.expr(x.Expr); container.kind == typeMap {
+			return container.elem, true
+		}
+	default:
+		c.expr(lhs)
+	}
+	return anyType, false
+}
+
+// pattern defines or assigns the variables of a destructuring pattern.
+func (c *Checker) pattern(x parser.Expr, define bool) {
+	switch x := x.(type) {
+	case *parser.Ident:
+		if define {
+			c.define(x.Name, anyType, false)
+		} else {
+			c.target(x)
+		}
+	case *parser.ArrayLit:
+		for _, e := range x.Elements {
+			c.pattern(e, define)
+		}
+	case *parser.MapLit:
+		for _, e := range x.Elements {
+			c.pattern(e.Value, define)
+		}
+	case *parser.SpreadExpr:
+		c.pattern(x.Expr, define)
+	default:
+		c.expr(x)
+	}
+}
+
+func (c *Checker) classStmt(s *parser.ClassStmt) {
+	instance := c.lookupType(s.Name.Name)
+	ctor := &funcSig{
+		params:  []*checkType{arrayOf(anyType)},
+		varArgs: true,
+		result:  instance,
+	}
+	if init, ok := instance.class.methods["init"]; ok {
+		sig := *init.sig
+		sig.result = instance
+		ctor = &sig
+	}
+	c.define(s.Name.Name, &checkType{kind: typeFunc, sig: ctor}, false)
+
+	for _, elt := range s.Body.Elements {
+		if fn, ok := elt.Value.(*parser.FuncLit); ok {
+			c.funcLit(fn, instance)
+		} else {
+			c.expr(elt.Value)
+		}
+	}
+}
+
+// expr checks the expression and returns its type.
+func (c *Checker) expr(x parser.Expr) *checkType {
+	switch x := x.(type) {
+	case *parser.IntLit:
+		return intType
+	case *parser.FloatLit:
+		return floatType
+	case *parser.StringLit:
+		return stringType
+	case *parser.InterpStringLit:
+		for _, part := range x.Parts {
+			c.expr(part)
+		}
+		return stringType
+	case *parser.CharLit:
+		return charType
+	case *parser.BoolLit:
+		return boolType
+	case *parser.NilLit:
+		return nilType
+	case *parser.Ident:
+		if v := c.lookup(x.Name); v != nil {
+			return v.typ
+		}
+	case *parser.ArrayLit:
+		var elems []*checkType
+		for _, e := range x.Elements {
+			if s, ok := e.(*parser.SpreadExpr); ok {
+				elems = append(elems, elemType(c.expr(s.Expr)))
+			} else {
+				elems = append(elems, c.expr(e))
+			}
+		}
+		typ := arrayOf(unionOf(elems...))
+		typ.lits = elems
+		return typ
+	case *parser.MapLit:
+		var elems []*checkType
+		for _, e := range x.Elements {
+			if s, ok := e.Value.(*parser.SpreadExpr); ok {
+				elems = append(elems, elemType(c.expr(s.Expr)))
+			} else {
+				elems = append(elems, c.expr(e.Value))
+			}
+		}
+		typ := mapOf(unionOf(elems...))
+		typ.lits = elems
+		return typ
+	case *parser.ArrayComp:
+		c.openScope()
+		defer c.closeScope()
+		c.compClause(x.Clause)
+		return arrayOf(c.expr(x.Elem))
+	case *parser.MapComp:
+		c.openScope()
+		defer c.closeScope()
+		c.compClause(x.Clause)
+		c.expr(x.Key)
+		return mapOf(c.expr(x.Value))
+	case *parser.BinaryExpr:
+		return c.binary(x, x.Token, c.expr(x.LHS), c.expr(x.RHS))
+	case *parser.UnaryExpr:
+		typ := c.expr(x.Expr)
+		if x.Token == parser.TokenNot {
+			return boolType
+		}
+		if typ.kind == typeInt || typ.kind == typeFloat {
+			return typ
+		}
+	case *parser.CondExpr:
+		c.expr(x.Cond)
+		return unionOf(c.expr(x.True), c.expr(x.False))
+	case *parser.ParenExpr:
+		return c.expr(x.Expr)
+	case *parser.CallExpr:
+		return c.call(x)
+	case *parser.FuncLit:
+		return c.funcLit(x, nil)
+	case *parser.IndexExpr:
+		typ := c.expr(x.Expr)
+		c.expr(x.Index)
+		_, elem := iterTypes(typ)
+		if x.Optional {
+			return unionOf(elem, nilType)
+		}
+		return elem
+	case *parser.SliceExpr:
+		typ := c.expr(x.Expr)
+		if x.Low != nil {
+			c.expr(x.Low)
+		}
+		if x.High != nil {
+			c.expr(x.High)
+		}
+		switch typ.kind {
+		case typeArray, typeString, typeBytes:
+			return typ
+		}
+	case *parser.SelectorExpr:
+		typ := c.selector(c.expr(x.Expr), x.Sel)
+		if x.Optional {
+			return unionOf(typ, nilType)
+		}
+		return typ
+	case *parser.ChainExpr:
+		return unionOf(c.expr(x.Expr), nilType)
+	case *parser.ErrorExpr:
+		c.expr(x.Expr)
+		return errorType
+	case *parser.ImmutableExpr:
+		return c.expr(x.Expr)
+	case *parser.PropagateExpr:
+		return without(c.expr(x.Expr), typeError)
+	case *parser.MatchExpr:
+		subject := c.expr(x.Subject)
+		var arms []*checkType
+		for _, arm := range x.Arms {
+			c.openScope()
+			for _, p := range arm.Patterns {
+				c.matchPattern(p, subject)
+			}
+			if arm.Guard != nil {
+				c.expr(arm.Guard)
+			}
+			arms = append(arms, c.expr(arm.Value))
+			c.closeScope()
+		}
+		return unionOf(arms...)
+	case *parser.SpreadExpr:
+		c.expr(x.Expr)
+	}
+	return anyType
+}
+
+// matchPattern defines the variables bound by the pattern of a match arm
+// that is matched against a value of type typ.
+func (c *Checker) matchPattern(pat parser.Expr, typ *checkType) {
+	switch pat := pat.(type) {
+	case *parser.Ident:
+		if pat.Name != "_" && !typePatterns[pat.Name] {
+			c.defineInferred(pat.Name, typ)
+		}
+	case *parser.CallExpr:
+		// type(pattern)
+		if ident, ok := pat.Func.(*parser.Ident); ok && len(pat.Args) == 1 {
+			typ = anyType
+			if typePatterns[ident.Name] {
+				typ = c.resolveType(ident)
+			}
+			c.matchPattern(pat.Args[0], typ)
+		}
+	case *parser.ErrorExpr:
+		c.matchPattern(pat.Expr, anyType)
+	case *parser.ArrayLit:
+		_, elem := iterTypes(typ)
+		if typ.kind != typeArray {
+			elem = anyType
+		}
+		for _, e := range pat.Elements {
+			if rest, ok := e.(*parser.SpreadExpr); ok {
+				c.matchPattern(rest.Expr, arrayOf(elem))
+			} else {
+				c.matchPattern(e, elem)
+			}
+		}
+	case *parser.MapLit:
+		elem := anyType
+		if typ.kind == typeMap {
+			elem = typ.elem
+		}
+		for _, e := range pat.Elements {
+			c.matchPattern(e.Value, elem)
+		}
+	}
+}
+
+func (c *Checker) compClause(clause *parser.CompClause) {
+	key, value := iterTypes(c.expr(clause.Iterable))
+	if clause.Key != nil {
+		c.defineInferred(clause.Key.Name, key)
+	}
+	c.defineInferred(clause.Value.Name, value)
+	if clause.Cond != nil {
+		c.expr(clause.Cond)
+	}
+}
+
+// selector returns the type of the element sel of a value of type typ.
+func (c *Checker) selector(typ *checkType, sel parser.Expr) *checkType {
+	switch typ.kind {
+	case typeMap:
+		return typ.elem
+	case typeClass:
+		if s, ok := sel.(*parser.StringLit); ok {
+			if method, ok := typ.class.methods[s.Value]; ok {
+				return method
+			}
+		}
+	}
+	return anyType
+}
+
+func (c *Checker) call(x *parser.CallExpr) *checkType {
+	fn := c.expr(x.Func)
+
+	// the types of the arguments before the first spread argument
+	var args []*checkType
+	spread := false
+	for i, arg := range x.Args {
+		if s, ok := arg.(*parser.SpreadExpr); ok {
+			c.expr(s.Expr)
+			spread = true
+			continue
+		}
+		typ := c.expr(arg)
+		if x.Ellipsis.IsValid() && i == len(x.Args)-1 {
+			// f(a...) spreads the last argument
+			spread = true
+		}
+		if !spread {
+			args = append(args, typ)
+		}
+	}
+	kwargs := make([]*checkType, len(x.NamedArgs))
+	for i, arg := range x.NamedArgs {
+		kwargs[i] = c.expr(arg.Value)
+	}
+
+	switch fn.kind {
+	case typeAny:
+		return anyType
+	case typeFunc:
+	case typeUnion:
+		for _, t := range fn.types {
+			if t.kind == typeFunc || t.kind == typeAny {
+				c.warnf(x.Func, "value of type %s may not be callable", fn)
+				return anyType
+			}
+		}
+		fallthrough
+	default:
+		c.errorf(x.Func, "cannot call value of type %s", fn)
+		return anyType
+	}
+	sig := fn.sig
+	if sig == nil {
+		return anyType
+	}
+
+	name := calleeName(x.Func)
+	numParams := len(sig.params)
+	if sig.varArgs {
+		numParams--
+	}
+	for i, t := range args {
+		switch {
+		case i < numParams:
+			c.assign(sig.params[i], t, x.Args[i],
+				argContext(sig, i, name))
+		case sig.varArgs:
+			c.assign(sig.params[numParams].elem, t, x.Args[i],
+				"variadic argument to "+name)
+		}
+	}
+	if !spread && !sig.varArgs && len(args) > numParams {
+		c.errorf(x.Args[numParams],
+			"wrong number of arguments in call to %s: want<=%d, got=%d",
+			name, numParams, len(args))
+	}
+
+	bound := make([]bool, numParams)
+	for i := 0; i < len(args) && i < numParams; i++ {
+		bound[i] = true
+	}
+	if sig.names != nil {
+		for i, arg := range x.NamedArgs {
+			idx := indexOf(sig.names[:numParams], arg.Key)
+			switch {
+			case idx < 0:
+				c.errorf(arg, "unexpected argument '%s' in call to %s",
+					arg.Key, name)
+			case bound[idx]:
+				c.errorf(arg, "multiple values for argument '%s' in call to %s",
+					arg.Key, name)
+			default:
+				bound[idx] = true
+				c.assign(sig.params[idx], kwargs[i], arg.Value,
+					argContext(sig, idx, name))
+			}
+		}
+	}
+	if !spread {
+		for i := 0; i < sig.required; i++ {
+			if bound[i] {
+				continue
+			}
+			if sig.names == nil {
+				c.errorf(x, "wrong number of arguments in call to %s: "+
+					"want>=%d, got=%d", name, sig.required, len(args))
+				break
+			}
+			c.errorf(x, "missing argument '%s' in call to %s",
+				sig.names[i], name)
+		}
+	}
+
+	if sig.convert && len(x.Args) == 2 && len(args) == 2 {
+		// the second argument is returned if the conversion fails
+		return unionOf(without(sig.result, typeNil), args[1])
+	}
+	return sig.result
+}
+
+func calleeName(x parser.Expr) string {
+	switch x := x.(type) {
+	case *parser.Ident:
+		return x.Name
+	case *parser.SelectorExpr:
+		if s, ok := x.Sel.(*parser.StringLit); ok {
+			return s.Value
+		}
+	}
+	return "function"
+}
+
+func argContext(sig *funcSig, i int, name string) string {
+	if sig.names != nil {
+		return fmt.Sprintf("argument '%s' to %s", sig.names[i], name)
+	}
+	return fmt.Sprintf("argument %d to %s", i+1, name)
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// terminates reports whether the statement always ends with a return or a
+// throw. It is conservative, and may report false for the statements that
+// never complete normally.
+func terminates(s parser.Stmt) bool {
+	switch s := s.(type) {
+	case *parser.ReturnStmt, *parser.ThrowStmt:
+		return true
+	case *parser.BlockStmt:
+		return len(s.Stmts) > 0 && terminates(s.Stmts[len(s.Stmts)-1])
+	case *parser.IfStmt:
+		return s.Else != nil && terminates(s.Body) && terminates(s.Else)
+	case *parser.ForStmt:
+		return s.Cond == nil && !hasBreak(s.Body.Stmts)
+	case *parser.LabeledStmt:
+		return terminates(s.Stmt)
+	case *parser.TryStmt:
+		if s.Finally != nil && terminates(s.Finally) {
+			return true
+		}
+		return terminates(s.Body) && (s.Catch == nil || terminates(s.Catch))
+	case *parser.SwitchStmt:
+		hasDefault := false
+		for _, clause := range s.Body.Stmts {
+			clause := clause.(*parser.CaseClause)
+			if clause.List == nil {
+				hasDefault = true
+			}
+			n := len(clause.Body)
+			if n == 0 || hasBreak(clause.Body) {
+				return false
+			}
+			last := clause.Body[n-1]
+			if b, ok := last.(*parser.BranchStmt); ok &&
+				b.Token == parser.TokenFallthrough {
+				continue
+			}
+			if !terminates(last) {
+				return false
+			}
+		}
+		return hasDefault
+	}
+	return false
+}
+
+// hasBreak reports whether the statements contain a break statement out of
+// the enclosing loop or switch.
+func hasBreak(stmts []parser.Stmt) bool {
+	for _, s := range stmts {
+		switch s := s.(type) {
+		case *parser.BranchStmt:
+			if s.Token == parser.TokenBreak {
+				return true
+			}
+		case *parser.BlockStmt:
+			if hasBreak(s.Stmts) {
+				return true
+			}
+		case *parser.IfStmt:
+			if hasBreak(s.Body.Stmts) ||
+				(s.Else != nil && hasBreak([]parser.Stmt{s.Else})) {
+				return true
+			}
+		case *parser.TryStmt:
+			for _, b := range []*parser.BlockStmt{s.Body, s.Catch, s.Finally} {
+				if b != nil && hasBreak(b.Stmts) {
+					return true
+				}
+			}
+		}
+		// break in a nested loop or switch applies to that statement
+	}
+	return false
+}
+
+type checkScope struct {
+	parent *checkScope
+	vars   map[string]*checkVar
+	types  map[string]*checkType // classes declared in the scope
+}
+
+func newCheckScope(parent *checkScope) *checkScope {
+	return &checkScope{
+		parent: parent,
+		vars:   make(map[string]*checkVar),
+		types:  make(map[string]*checkType),
+	}
+}
+
+type checkVar struct {
+	typ       *checkType
+	annotated bool
+}
+
+type checkFunc struct {
+	result  *checkType // nil if the result type is inferred
+	returns []*checkType
+	yield   bool
+}
+
+type checkClass struct {
+	name    string
+	methods map[string]*checkType
+}
+
+// typeKind is the kind of a checkType.
+type typeKind int
+
+const (
+	typeAny typeKind = iota
+	typeNil
+	typeInt
+	typeFloat
+	typeString
+	typeBool
+	typeChar
+	typeBytes
+	typeBigInt
+	typeDecimal
+	typeTime
+	typeError
+	typeRange
+	typeArray
+	typeMap
+	typeFunc
+	typeClass
+	typeUnion
+)
+
+// checkType is a type of the checker.
+type checkType struct {
+	kind  typeKind
+	elem  *checkType   // element type of an array or a map
+	sig   *funcSig     // signature of a function, nil if unknown
+	class *checkClass  // class of an instance
+	types []*checkType // members of a union
+	lits  []*checkType // element types of an array or a map literal
+}
+
+// funcSig is a function signature. The variadic parameter is the array of
+// the variadic arguments.
+type funcSig struct {
+	params   []*checkType
+	names    []string // nil if unknown
+	required int
+	varArgs  bool
+	result   *checkType
+	convert  bool // result is the optional second argument on failure
+}
+
+var (
+	anyType     = &checkType{kind: typeAny}
+	nilType     = &checkType{kind: typeNil}
+	intType     = &checkType{kind: typeInt}
+	floatType   = &checkType{kind: typeFloat}
+	stringType  = &checkType{kind: typeString}
+	boolType    = &checkType{kind: typeBool}
+	charType    = &checkType{kind: typeChar}
+	bytesType   = &checkType{kind: typeBytes}
+	bigIntType  = &checkType{kind: typeBigInt}
+	decimalType = &checkType{kind: typeDecimal}
+	timeType    = &checkType{kind: typeTime}
+	errorType   = &checkType{kind: typeError}
+	rangeType   = &checkType{kind: typeRange}
+)
+
+var typeNames = [...]string{
+	typeAny:     "any",
+	typeNil:     "nil",
+	typeInt:     "int",
+	typeFloat:   "float",
+	typeString:  "string",
+	typeBool:    "bool",
+	typeChar:    "char",
+	typeBytes:   "bytes",
+	typeBigInt:  "bigint",
+	typeDecimal: "decimal",
+	typeTime:    "time",
+	typeError:   "error",
+	typeRange:   "range",
+}
+
+// basicTypes are the named types other than any, array, map and func.
+var basicTypes = map[string]*checkType{
+	"nil":     nilType,
+	"int":     intType,
+	"float":   floatType,
+	"string":  stringType,
+	"bool":    boolType,
+	"char":    charType,
+	"bytes":   bytesType,
+	"bigint":  bigIntType,
+	"decimal": decimalType,
+	"time":    timeType,
+	"error":   errorType,
+	"range":   rangeType,
+}
+
+// builtinTypes are the types of the builtin functions known to the checker.
+var builtinTypes = map[string]*checkType{
+	"len":        funcTypeOf(intType, 1, anyType),
+	"type":       funcTypeOf(stringType, 1, anyType),
+	"range":      funcTypeOf(rangeType, 2, intType, intType, intType),
+	"format":     funcTypeOf(stringType, 1, stringType, arrayOf(anyType)),
+	"map_keys":   funcTypeOf(arrayOf(stringType), 1, mapOf(anyType)),
+	"map_values": funcTypeOf(arrayOf(anyType), 1, mapOf(anyType)),
+	"string":     convertType(stringType),
+	"int":        convertType(intType),
+	"float":      convertType(floatType),
+	"char":       convertType(charType),
+	"bytes":      convertType(bytesType),
+	"bigint":     convertType(bigIntType),
+	"decimal":    convertType(decimalType),
+	"bool":       funcTypeOf(unionOf(boolType, nilType), 1, anyType),
+}
+
+func init() {
+	for _, fn := range builtinFuncs {
+		if strings.HasPrefix(fn.Name, "is_") {
+			builtinTypes[fn.Name] = funcTypeOf(boolType, 1, anyType)
+		}
+	}
+	builtinTypes["format"].sig.varArgs = true
+}
+
+func funcTypeOf(
+	result *checkType,
+	required int,
+	params ...*checkType,
+) *checkType {
+	return &checkType{kind: typeFunc, sig: &funcSig{
+		params:   params,
+		required: required,
+		result:   result,
+	}}
+}
+
+func convertType(typ *checkType) *checkType {
+	fn := funcTypeOf(unionOf(typ, nilType), 1, anyType, anyType)
+	fn.sig.convert = true
+	return fn
+}
+
+func arrayOf(elem *checkType) *checkType {
+	return &checkType{kind: typeArray, elem: elem}
+}
+
+func mapOf(elem *checkType) *checkType {
+	return &checkType{kind: typeMap, elem: elem}
+}
+
+// unionOf returns the union of the types. It returns any if there are no
+// types.
+func unionOf(types ...*checkType) *checkType {
+	var members []*checkType
+	seen := make(map[string]bool)
+	var add func(t *checkType) bool
+	add = func(t *checkType) bool {
+		switch t.kind {
+		case typeAny:
+			return false
+		case typeUnion:
+			for _, m := range t.types {
+				add(m)
+			}
+			return true
+		}
+		if s := t.String(); !seen[s] {
+			seen[s] = true
+			members = append(members, t)
+		}
+		return true
+	}
+	for _, t := range types {
+		if !add(t) {
+			return anyType
+		}
+	}
+	switch len(members) {
+	case 0:
+		return anyType
+	case 1:
+		return members[0]
+	}
+	return &checkType{kind: typeUnion, types: members}
+}
+
+// without returns the type without the members of the kind.
+func without(t *checkType, kind typeKind) *checkType {
+	if t.kind != typeUnion {
+		return t
+	}
+	var types []*checkType
+	for _, m := range t.types {
+		if m.kind != kind {
+			types = append(types, m)
+		}
+	}
+	return unionOf(types...)
+}
+
+// widen returns the type with the element types of arrays and maps replaced
+// with any.
+func widen(t *checkType) *checkType {
+	switch t.kind {
+	case typeArray:
+		return arrayOf(anyType)
+	case typeMap:
+		return mapOf(anyType)
+	case typeUnion:
+		var types []*checkType
+		for _, m := range t.types {
+			types = append(types, widen(m))
+		}
+		return unionOf(types...)
+	}
+	return t
+}
+
+// elemType returns the type of the elements of a value of type t when it is
+// spread.
+func elemType(t *checkType) *checkType {
+	if t.kind == typeArray || t.kind == typeMap {
+		return t.elem
+	}
+	return anyType
+}
+
+// iterTypes returns the types of the keys and the values of a value of type
+// t when it is iterated or indexed.
+func iterTypes(t *checkType) (key, value *checkType) {
+	switch t.kind {
+	case typeArray:
+		return intType, t.elem
+	case typeMap:
+		return stringType, t.elem
+	case typeString:
+		return intType, charType
+	case typeBytes, typeRange:
+		return intType, intType
+	}
+	return anyType, anyType
+}
+
+// binary returns the type of the result of the binary operation, and reports
+// the operands the operator cannot be applied to.
+func (c *Checker) binary(
+	node parser.Node,
+	op parser.Token,
+	lhs, rhs *checkType,
+) *checkType {
+	switch operands(op, lhs, rhs) {
+	case assignNo:
+		c.errorf(node, "invalid operation: %s %s %s", lhs, op, rhs)
+	case assignMaybe:
+		c.warnf(node, "operation %s %s %s may be invalid", lhs, op, rhs)
+	}
+	return binaryType(op, lhs, rhs)
+}
+
+// operands reports whether the binary operator can be applied to values of
+// types lhs and rhs. It is assignMaybe if it can only be applied to some of
+// the members of a union.
+func operands(op parser.Token, lhs, rhs *checkType) int {
+	members := func(t *checkType) []*checkType {
+		if t.kind == typeUnion {
+			return t.types
+		}
+		return []*checkType{t}
+	}
+	ok, no := 0, 0
+	for _, x := range members(lhs) {
+		for _, y := range members(rhs) {
+			if operates(op, x.kind, y.kind) {
+				ok++
+			} else {
+				no++
+			}
+		}
+	}
+	switch {
+	case no == 0:
+		return assignOK
+	case ok == 0:
+		return assignNo
+	}
+	return assignMaybe
+}
+
+// operates returns false if the VM fails with an invalid operation when the
+// binary operator is applied to values of kinds lhs and rhs.
+func operates(op parser.Token, lhs, rhs typeKind) bool {
+	switch {
+	case lhs == typeAny || rhs == typeAny:
+		return true
+	case lhs == typeMap || rhs == typeMap ||
+		lhs == typeClass || rhs == typeClass:
+		// the operator may be overloaded
+		return true
+	}
+	number := func(k typeKind) bool {
+		return k == typeInt || k == typeFloat || k == typeBigInt ||
+			k == typeDecimal
+	}
+	// a float is not converted to a decimal, but a decimal operand converts
+	// a float
+	numbers := number(lhs) && number(rhs) &&
+		!(lhs == typeFloat && rhs == typeDecimal)
+	switch op {
+	case parser.TokenEqual, parser.TokenNotEqual, parser.TokenLAnd,
+		parser.TokenLOr, parser.TokenCoalesce:
+		return true
+	case parser.TokenLess, parser.TokenLessEq, parser.TokenGreater,
+		parser.TokenGreaterEq:
+		switch {
+		case lhs == typeChar || rhs == typeChar:
+			return lhs == rhs || lhs == typeInt || rhs == typeInt
+		case lhs == typeString || lhs == typeTime:
+			return lhs == rhs
+		}
+		// the operands of < and <= are swapped, so a float can be compared
+		// with a decimal by one of them
+		return numbers || (lhs == typeFloat && rhs == typeDecimal)
+	case parser.TokenRange, parser.TokenRangeExcl:
+		return lhs == typeInt && rhs == typeInt
+	case parser.TokenAdd:
+		switch lhs {
+		case typeString:
+			return true
+		case typeArray, typeRange:
+			return rhs == typeArray || rhs == typeRange
+		case typeBytes:
+			return rhs == typeBytes
+		}
+		fallthrough
+	case parser.TokenSub:
+		switch {
+		case lhs == typeChar:
+			return rhs == typeChar || rhs == typeInt
+		case lhs == typeInt && rhs == typeChar:
+			return true
+		case lhs == typeTime:
+			return rhs == typeInt || (op == parser.TokenSub && rhs == typeTime)
+		}
+		return numbers
+	case parser.TokenMul, parser.TokenQuo:
+		return numbers
+	case parser.TokenRem:
+		// only decimals convert a float operand
+		return numbers && (lhs == typeDecimal ||
+			lhs != typeFloat && rhs != typeFloat)
+	case parser.TokenAnd, parser.TokenOr, parser.TokenXor,
+		parser.TokenAndNot, parser.TokenShl, parser.TokenShr:
+		return (lhs == typeInt || lhs == typeBigInt) &&
+			(rhs == typeInt || rhs == typeBigInt)
+	}
+	return true
+}
+
+// binaryType returns the type of the result of the binary operation.
+func binaryType(op parser.Token, lhs, rhs *checkType) *checkType {
+	switch op {
+	case parser.TokenEqual, parser.TokenNotEqual, parser.TokenLess,
+		parser.TokenLessEq, parser.TokenGreater, parser.TokenGreaterEq:
+		return boolType
+	case parser.TokenRange, parser.TokenRangeExcl:
+		return rangeType
+	case parser.TokenLAnd, parser.TokenLOr:
+		return unionOf(lhs, rhs)
+	case parser.TokenCoalesce:
+		return unionOf(without(lhs, typeNil), rhs)
+	case parser.TokenAdd:
+		if lhs.kind == typeString {
+			return stringType
+		}
+		if lhs.kind == typeChar && rhs.kind == typeInt {
+			return charType
+		}
+		fallthrough
+	case parser.TokenSub, parser.TokenMul, parser.TokenQuo, parser.TokenRem:
+		switch {
+		case lhs.kind == typeInt && rhs.kind == typeInt:
+			return intType
+		case (lhs.kind == typeFloat || lhs.kind == typeInt) &&
+			(rhs.kind == typeFloat || rhs.kind == typeInt):
+			return floatType
+		case lhs.kind == rhs.kind &&
+			(lhs.kind == typeBigInt || lhs.kind == typeDecimal):
+			return lhs
+		}
+	case parser.TokenAnd, parser.TokenOr, parser.TokenXor,
+		parser.TokenAndNot, parser.TokenShl, parser.TokenShr:
+		if lhs.kind == typeInt && rhs.kind == typeInt {
+			return intType
+		}
+	}
+	return anyType
+}
+
+// assignability of a value of a type to another type
+const (
+	assignNo = iota
+	assignMaybe
+	assignOK
+)
+
+// assignable reports whether a value of type src can be used as a value of
+// type dst. It is assignMaybe if only some values of type src can.
+func assignable(dst, src *checkType) int {
+	if dst.kind == typeAny || src.kind == typeAny {
+		return assignOK
+	}
+	if src.kind == typeUnion {
+		ok, no := 0, 0
+		for _, m := range src.types {
+			switch assignable(dst, m) {
+			case assignOK:
+				ok++
+			case assignNo:
+				no++
+			}
+		}
+		switch len(src.types) {
+		case ok:
+			return assignOK
+		case no:
+			return assignNo
+		}
+		return assignMaybe
+	}
+	if dst.kind == typeUnion {
+		best := assignNo
+		for _, m := range dst.types {
+			if r := assignable(m, src); r > best {
+				best = r
+			}
+		}
+		return best
+	}
+	if dst.kind == typeFloat && src.kind == typeInt {
+		return assignOK
+	}
+	if dst.kind == typeArray && src.kind == typeRange {
+		// a range is an array of ints
+		return assignable(dst.elem, intType)
+	}
+	if dst.kind != src.kind {
+		return assignNo
+	}
+	switch dst.kind {
+	case typeArray, typeMap:
+		return assignable(dst.elem, src.elem)
+	case typeClass:
+		if dst.class != src.class {
+			return assignNo
+		}
+	case typeFunc:
+		return assignableSig(dst.sig, src.sig)
+	}
+	return assignOK
+}
+
+// assignableSig reports whether a function with the signature src can be
+// called as a function with the signature dst.
+func assignableSig(dst, src *funcSig) int {
+	if dst == nil || src == nil {
+		return assignOK
+	}
+	if dst.required < src.required ||
+		(dst.varArgs && !src.varArgs) ||
+		(!src.varArgs && len(dst.params) > len(src.params)) {
+		return assignNo
+	}
+	res := assignable(dst.result, src.result)
+	for i, p := range dst.params {
+		if i >= len(src.params) || (dst.varArgs && i == len(dst.params)-1) {
+			break
+		}
+		if r := assignable(src.params[i], p); r < res {
+			res = r
+		}
+	}
+	return res
+}
+
+func (t *checkType) String() string {
+	switch t.kind {
+	case typeArray:
+		return "[" + t.elem.String() + "]"
+	case typeMap:
+		return "{string: " + t.elem.String() + "}"
+	case typeClass:
+		return t.class.name
+	case typeFunc:
+		if t.sig == nil {
+			return "func"
+		}
+		var params []string
+		for i, p := range t.sig.params {
+			if t.sig.varArgs && i == len(t.sig.params)-1 {
+				params = append(params, "..."+p.String())
+			} else {
+				params = append(params, p.String())
+			}
+		}
+		s := "func(" + strings.Join(params, ", ") + ")"
+		if t.sig.result.kind != typeAny {
+			s += ": " + t.sig.result.String()
+		}
+		return s
+	case typeUnion:
+		var types []string
+		nullable := false
+		for _, m := range t.types {
+			if m.kind == typeNil {
+				nullable = true
+				continue
+			}
+			s := m.String()
+			if m.kind == typeFunc {
+				s = "(" + s + ")"
+			}
+			types = append(types, s)
+		}
+		if !nullable {
+			return strings.Join(types, " | ")
+		}
+		if len(types) == 1 {
+			return types[0] + "?"
+		}
+		return strings.Join(types, " | ") + " | nil"
+	}
+	return typeNames[t.kind]
+}
+
+// typeOfObject returns the type of the value.
+func typeOfObject(o Object) *checkType {
+	switch o := o.(type) {
+	case *Nil:
+		return nilType
+	case *Int:
+		return intType
+	case *Float:
+		return floatType
+	case *String:
+		return stringType
+	case *Bool:
+		return boolType
+	case *Char:
+		return charType
+	case *Bytes:
+		return bytesType
+	case *BigInt:
+		return bigIntType
+	case *Decimal:
+		return decimalType
+	case *Time:
+		return timeType
+	case *Error:
+		return errorType
+	case *Range:
+		return rangeType
+	case *Array:
+		return arrayOf(anyType)
+	case *Map:
+		return mapOf(anyType)
+	default:
+		if o.CanCall() {
+			return &checkType{kind: typeFunc}
+		}
+	}
+	return anyType
+}
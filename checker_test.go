@@ -0,0 +1,104 @@
+package gslang_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gslang/gslang"
+)
+
+type checkTest struct {
+	input    string
+	expected []string
+}
+
+// expectCheck checks the input script and compares the errors and warnings
+// found by the checker with expected, in order. Each expected entry must be
+// contained in the corresponding error message.
+func expectCheck(t *testing.T, input string, expected ...string) {
+	t.Helper()
+	errs, err := gslang.NewScript([]byte(input)).Check()
+	if err != nil {
+		t.Errorf("unexpected error\n\tinput: %s\n\terror: %s", input, err)
+		return
+	}
+	var actual []string
+	for _, e := range errs {
+		actual = append(actual, e.Error())
+	}
+	if len(actual) != len(expected) {
+		t.Errorf("unexpected check result\n\tinput: %s\n\texpected: %q\n\tactual: %q",
+			input, expected, actual)
+		return
+	}
+	for i := range expected {
+		if !strings.Contains(actual[i], expected[i]) {
+			t.Errorf("unexpected check result\n\tinput: %s\n\texpected: %q\n\tactual: %q",
+				input, expected, actual)
+			return
+		}
+	}
+}
+
+func TestCheckTypes(t *testing.T) {
+	for _, tt := range []checkTest{
+		{`add := func(a: int, b: int = 2): int { return a + b }
+x: int := add(1)
+m: {string: [int]} := {a: [1, 2]}
+u: (int | string)? := "s"
+sq := (n: int) => n * n
+y := sq(4)`, nil},
+		{`x: int := "hello"`, []string{
+			"Check Error: cannot use value of type string as int in definition of x"}},
+		{`add := func(a: int, b: int = 2): int { return a + b }
+add("a")
+add(1, 2, 3)
+add()
+add(1, c: 3)`, []string{
+			"cannot use value of type string as int in argument 'a' to add",
+			"wrong number of arguments in call to add: want<=2, got=3",
+			"missing argument 'a' in call to add",
+			"unexpected argument 'c' in call to add"}},
+		{`n: int? := nil
+w: int := n`, []string{
+			"Check Warning: value of type int? may not be int in definition of w"}},
+		{`q: Foo := 1`, []string{"unknown type 'Foo'"}},
+		{`h := func(v: int): string { if v > 0 { return "pos" } }`, []string{
+			"Check Warning: missing return at end of function returning string"}},
+		{`r: [int] := [1, "a"]`, []string{
+			"cannot use value of type [int | string] as [int] in definition of r"}},
+		{`k: int := 1
+k = 2.5`, []string{
+			"cannot use value of type float as int in assignment to k"}},
+		{`class P { x: 0, init: func(x: int) { self.x = x } }
+p := P("a")`, []string{
+			"cannot use value of type string as int in argument 'x' to P"}},
+		{`f := (n: int): string => n`, []string{
+			"cannot use value of type int as string"}},
+		{`a: [int] := range(0, 3)
+b: [float] := 1..3
+for i in range(0, 3) { c: int := i }
+s: [string] := range(0, 3)`, []string{
+			"cannot use value of type range as [string] in definition of s"}},
+		{`add := func(a: int, b: int): int { return a + b }
+z := add(1, 2) + "s"
+s := "a" + 1
+c := 'a' + 1
+t := 1 < 2.5
+r := range(0, 2) + [2]
+d := 1.5 % 2
+k := {a: 1} + 1
+x: int := 1
+x += "s"`, []string{
+			"Check Error: invalid operation: int + string",
+			"Check Error: invalid operation: float % int",
+			"Check Error: invalid operation: int + string"}},
+		{`u: (int | string) := 1
+v := u + 1
+n: int? := nil
+w := n * 2`, []string{
+			"Check Warning: operation int? * int may be invalid"}},
+	} {
+		expectCheck(t, tt.input, tt.expected...)
+	}
+}
@@ -23,6 +23,7 @@ const (
 
 var (
 	compileOutput string
+	checkOnly     bool
 	showHelp      bool
 	showVersion   bool
 	resolvePath   bool // TODO Remove this flag at version 3
@@ -32,6 +33,7 @@ var (
 func init() {
 	flag.BoolVar(&showHelp, "help", false, "Show help")
 	flag.StringVar(&compileOutput, "o", "", "Compile output file")
+	flag.BoolVar(&checkOnly, "check", false, "Check type annotations")
 	flag.BoolVar(&showVersion, "version", false, "Show version")
 	flag.BoolVar(&resolvePath, "resolve", false,
 		"Resolve relative import paths")
@@ -71,7 +73,16 @@ func main() {
 		copy(inputData, "//")
 	}
 
-	if compileOutput != "" {
+	if checkOnly {
+		ok, err := CheckOnly(inputData, inputFile)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+	} else if compileOutput != "" {
 		err := CompileOnly(modules, inputData, inputFile,
 			compileOutput)
 		if err != nil {
@@ -128,6 +139,28 @@ func CompileOnly(
 	return
 }
 
+// CheckOnly verifies the type annotations of the source code and prints the
+// errors and the warnings. It returns false if there are errors.
+func CheckOnly(data []byte, inputFile string) (ok bool, err error) {
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile(filepath.Base(inputFile), -1, len(data))
+
+	p := parser.NewParser(srcFile, data, nil)
+	file, err := p.ParseFile()
+	if err != nil {
+		return
+	}
+
+	ok = true
+	for _, e := range gslang.NewChecker(srcFile).Check(file) {
+		_, _ = fmt.Fprintln(os.Stderr, e.Error())
+		if !e.Warning {
+			ok = false
+		}
+	}
+	return
+}
+
 // CompileAndRun compiles the source code and executes it.
 func CompileAndRun(
 	modules *gslang.ModuleMap,
@@ -258,6 +291,7 @@ func doHelp() {
 	fmt.Println("Flags:")
 	fmt.Println()
 	fmt.Println("	-o        compile output file")
+	fmt.Println("	-check    check type annotations without running")
 	fmt.Println("	-version  show version")
 	fmt.Println()
 	fmt.Println("Examples:")
@@ -279,6 +313,10 @@ func doHelp() {
 	fmt.Println()
 	fmt.Println("	          Run bytecode file (myapp)")
 	fmt.Println()
+	fmt.Println("	gslang -check myapp.gslang")
+	fmt.Println()
+	fmt.Println("	          Check type annotations of source file (myapp.gslang)")
+	fmt.Println()
 	fmt.Println()
 }
 
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"path/filepath"
 	"reflect"
 	"strings"
@@ -17,6 +18,8 @@ type compilationScope struct {
 	Instructions []byte
 	SymbolInit   map[string]bool
 	SourceMap    map[int]parser.Pos
+	Tries        []*parser.BlockStmt // finally blocks of enclosing try statements
+	Generator    bool                // if the function contains yield statements
 }
 
 // loop represents a loop construct that the compiler uses to track the current
@@ -24,6 +27,11 @@ type compilationScope struct {
 type loop struct {
 	Continues []int
 	Breaks    []int
+	Switch    bool          // switch statements only take breaks
+	Tries     int           // number of enclosing try statements
+	Scope     int           // index of the enclosing compilation scope
+	Label     string        // label of the loop, if any
+	Iterator  *SymbolObject // iterator of a for-in loop
 }
 
 // CompilerError represents a compiler error.
@@ -51,8 +59,12 @@ type Compiler struct {
 	modules         *ModuleMap
 	compiledModules map[string]*CompiledFunction
 	allowFileImport bool
+	checked         bool
 	loops           []*loop
 	loopIndex       int
+	chainJumps      []int
+	exports         []*SymbolObject
+	exportValue     bool
 	trace           io.Writer
 	indent          int
 }
@@ -133,9 +145,14 @@ func (c *Compiler) Compile(node parser.Node) error {
 			return err
 		}
 	case *parser.BinaryExpr:
-		if node.Token == parser.TokenLAnd || node.Token == parser.TokenLOr {
+		if node.Token == parser.TokenLAnd || node.Token == parser.TokenLOr ||
+			node.Token == parser.TokenCoalesce {
 			return c.compileLogical(node)
 		}
+		if value := c.constantValue(node); value != nil {
+			c.emitConstant(node, value)
+			return nil
+		}
 		if node.Token == parser.TokenLess {
 			if err := c.Compile(node.RHS); err != nil {
 				return err
@@ -164,11 +181,11 @@ func (c *Compiler) Compile(node parser.Node) error {
 
 		switch node.Token {
 		case parser.TokenAdd:
-			c.emit(node, parser.OpBinaryOp, int(parser.TokenAdd))
+			c.emitBinaryOp(node, parser.TokenAdd)
 		case parser.TokenSub:
-			c.emit(node, parser.OpBinaryOp, int(parser.TokenSub))
+			c.emitBinaryOp(node, parser.TokenSub)
 		case parser.TokenMul:
-			c.emit(node, parser.OpBinaryOp, int(parser.TokenMul))
+			c.emitBinaryOp(node, parser.TokenMul)
 		case parser.TokenQuo:
 			c.emit(node, parser.OpBinaryOp, int(parser.TokenQuo))
 		case parser.TokenRem:
@@ -190,9 +207,13 @@ func (c *Compiler) Compile(node parser.Node) error {
 		case parser.TokenAndNot:
 			c.emit(node, parser.OpBinaryOp, int(parser.TokenAndNot))
 		case parser.TokenShl:
-			c.emit(node, parser.OpBinaryOp, int(parser.TokenShl))
+			c.emitBinaryOp(node, parser.TokenShl)
 		case parser.TokenShr:
 			c.emit(node, parser.OpBinaryOp, int(parser.TokenShr))
+		case parser.TokenRange:
+			c.emit(node, parser.OpBinaryOp, int(parser.TokenRange))
+		case parser.TokenRangeExcl:
+			c.emit(node, parser.OpBinaryOp, int(parser.TokenRangeExcl))
 		default:
 			return c.errorf(node, "invalid binary operator: %s",
 				node.Token.String())
@@ -215,12 +236,40 @@ func (c *Compiler) Compile(node parser.Node) error {
 		}
 		c.emit(node, parser.OpConstant,
 			c.addConstant(&String{Value: node.Value}))
+	case *parser.InterpStringLit:
+		// $"a${x}b" is compiled into "a" + x + "b", and the first operand
+		// is always a string so that the values are converted by
+		// String.BinaryOp.
+		parts := node.Parts
+		if len(parts) > 0 {
+			if _, isText := parts[0].(*parser.StringLit); isText {
+				if err := c.Compile(parts[0]); err != nil {
+					return err
+				}
+				parts = parts[1:]
+			} else {
+				c.emit(node, parser.OpConstant,
+					c.addConstant(&String{Value: ""}))
+			}
+		} else {
+			c.emit(node, parser.OpConstant, c.addConstant(&String{Value: ""}))
+		}
+		for _, part := range parts {
+			if err := c.Compile(part); err != nil {
+				return err
+			}
+			c.emit(part, parser.OpBinaryOp, int(parser.TokenAdd))
+		}
 	case *parser.CharLit:
 		c.emit(node, parser.OpConstant,
 			c.addConstant(&Char{Value: node.Value}))
 	case *parser.NilLit:
 		c.emit(node, parser.OpNull)
 	case *parser.UnaryExpr:
+		if value := c.constantValue(node); value != nil {
+			c.emitConstant(node, value)
+			return nil
+		}
 		if err := c.Compile(node.Expr); err != nil {
 			return err
 		}
@@ -229,7 +278,11 @@ func (c *Compiler) Compile(node parser.Node) error {
 		case parser.TokenNot:
 			c.emit(node, parser.OpLNot)
 		case parser.TokenSub:
-			c.emit(node, parser.OpMinus)
+			if c.checked {
+				c.emit(node, parser.OpCheckedMinus)
+			} else {
+				c.emit(node, parser.OpMinus)
+			}
 		case parser.TokenXor:
 			c.emit(node, parser.OpBComplement)
 		case parser.TokenAdd:
@@ -279,24 +332,73 @@ func (c *Compiler) Compile(node parser.Node) error {
 			c.changeOperand(jumpPos1, curPos)
 		}
 	case *parser.ForStmt:
-		return c.compileForStmt(node)
+		return c.compileForStmt(node, "")
 	case *parser.ForInStmt:
-		return c.compileForInStmt(node)
+		return c.compileForInStmt(node, "")
+	case *parser.LabeledStmt:
+		return c.compileLabeledStmt(node)
+	case *parser.SwitchStmt:
+		return c.compileSwitchStmt(node)
+	case *parser.TryStmt:
+		return c.compileTryStmt(node)
+	case *parser.DeferStmt:
+		if c.symbol.Parent(true) == nil {
+			// outside the function
+			return c.errorf(node, "defer not allowed outside function")
+		}
+		if err := c.Compile(node.Call.Func); err != nil {
+			return err
+		}
+		numArgs, flags, err := c.compileCallArgs(node.Call)
+		if err != nil {
+			return err
+		}
+		c.emit(node, parser.OpDefer, numArgs, flags)
+	case *parser.ThrowStmt:
+		if err := c.Compile(node.Expr); err != nil {
+			return err
+		}
+		c.emit(node, parser.OpThrow, 0)
 	case *parser.BranchStmt:
 		if node.Token == parser.TokenBreak {
-			curLoop := c.currentLoop()
+			curLoop := c.currentLoop(false)
+			if node.Label != nil {
+				curLoop = c.labeledLoop(node.Label.Name)
+				if curLoop == nil {
+					return c.errorf(node.Label,
+						"invalid break label %s", node.Label.Name)
+				}
+			}
 			if curLoop == nil {
 				return c.errorf(node, "break not allowed outside loop")
 			}
+			if err := c.exitTries(node, curLoop.Tries); err != nil {
+				return err
+			}
+			c.exitLoops(node, curLoop, true)
 			pos := c.emit(node, parser.OpJump, 0)
 			curLoop.Breaks = append(curLoop.Breaks, pos)
 		} else if node.Token == parser.TokenContinue {
-			curLoop := c.currentLoop()
+			curLoop := c.currentLoop(true)
+			if node.Label != nil {
+				curLoop = c.labeledLoop(node.Label.Name)
+				if curLoop == nil {
+					return c.errorf(node.Label,
+						"invalid continue label %s", node.Label.Name)
+				}
+			}
 			if curLoop == nil {
 				return c.errorf(node, "continue not allowed outside loop")
 			}
+			if err := c.exitTries(node, curLoop.Tries); err != nil {
+				return err
+			}
+			c.exitLoops(node, curLoop, false)
 			pos := c.emit(node, parser.OpJump, 0)
 			curLoop.Continues = append(curLoop.Continues, pos)
+		} else if node.Token == parser.TokenFallthrough {
+			// a valid fallthrough is consumed by compileSwitchStmt
+			return c.errorf(node, "fallthrough statement out of place")
 		} else {
 			panic(fmt.Errorf("invalid branch statement: %s",
 				node.Token.String()))
@@ -322,6 +424,11 @@ func (c *Compiler) Compile(node parser.Node) error {
 			return err
 		}
 	case *parser.Ident:
+		// a constant value is emitted without capturing the name
+		if value := c.constantValue(node); value != nil {
+			c.emitConstant(node, value)
+			break
+		}
 		symbol, _, ok := c.symbol.Resolve(node.Name, false)
 		if !ok {
 			return c.errorf(node, "unresolved reference '%s'", node.Name)
@@ -338,32 +445,37 @@ func (c *Compiler) Compile(node parser.Node) error {
 			c.emit(node, parser.OpGetFree, symbol.Index)
 		}
 	case *parser.ArrayLit:
-		for _, elem := range node.Elements {
-			if err := c.Compile(elem); err != nil {
-				return err
-			}
-		}
-		c.emit(node, parser.OpArray, len(node.Elements))
+		return c.compileArrayLit(node, node.Elements)
+	case *parser.ArrayComp:
+		return c.compileComp(node, node.Clause, node.Elem)
+	case *parser.MapComp:
+		return c.compileComp(node, node.Clause, node.Key, node.Value)
+	case *parser.SpreadExpr:
+		return c.errorf(node, "rest element not allowed here")
 	case *parser.MapLit:
-		for _, elt := range node.Elements {
-			// key
-			if len(elt.Key) > MaxStringLen {
-				return c.error(node, ErrStringLimit)
-			}
-			c.emit(node, parser.OpConstant,
-				c.addConstant(&String{Value: elt.Key}))
+		return c.compileMapLit(node)
 
-			// value
-			if err := c.Compile(elt.Value); err != nil {
-				return err
-			}
+	case *parser.MatchExpr:
+		return c.compileMatchExpr(node)
+	case *parser.ChainExpr:
+		jumps := c.chainJumps
+		c.chainJumps = nil
+		if err := c.Compile(node.Expr); err != nil {
+			return err
 		}
-		c.emit(node, parser.OpMap, len(node.Elements)*2)
-
+		curPos := len(c.currentInstructions())
+		for _, pos := range c.chainJumps {
+			c.changeOperand(pos, curPos)
+		}
+		c.chainJumps = jumps
 	case *parser.SelectorExpr: // selector on RHS side
 		if err := c.Compile(node.Expr); err != nil {
 			return err
 		}
+		if node.Optional {
+			c.chainJumps = append(c.chainJumps,
+				c.emit(node, parser.OpNilJump, 0))
+		}
 		if err := c.Compile(node.Sel); err != nil {
 			return err
 		}
@@ -372,6 +484,10 @@ func (c *Compiler) Compile(node parser.Node) error {
 		if err := c.Compile(node.Expr); err != nil {
 			return err
 		}
+		if node.Optional {
+			c.chainJumps = append(c.chainJumps,
+				c.emit(node, parser.OpNilJump, 0))
+		}
 		if err := c.Compile(node.Index); err != nil {
 			return err
 		}
@@ -380,6 +496,10 @@ func (c *Compiler) Compile(node parser.Node) error {
 		if err := c.Compile(node.Expr); err != nil {
 			return err
 		}
+		if node.Optional {
+			c.chainJumps = append(c.chainJumps,
+				c.emit(node, parser.OpNilJump, 0))
+		}
 		if node.Low != nil {
 			if err := c.Compile(node.Low); err != nil {
 				return err
@@ -396,92 +516,27 @@ func (c *Compiler) Compile(node parser.Node) error {
 		}
 		c.emit(node, parser.OpSliceIndex)
 	case *parser.FuncLit:
-		c.enterScope()
-
-		for _, p := range node.Type.Params.List {
-			s := c.symbol.Define(p.Name)
-
-			// function arguments is not assigned directly.
-			s.LocalAssigned = true
-		}
-
-		if err := c.Compile(node.Body); err != nil {
-			return err
-		}
-
-		// code optimization
-		c.optimizeFunc(node)
-
-		freeSymbols := c.symbol.FreeSymbols()
-		numLocals := c.symbol.MaxSymbols()
-		instructions, sourceMap := c.leaveScope()
-
-		for _, s := range freeSymbols {
-			switch s.Scope {
-			case ScopeLocal:
-				if !s.LocalAssigned {
-					// Here, the closure is capturing a local variable that's
-					// not yet assigned its value. One example is a local
-					// recursive function:
-					//
-					//   func() {
-					//     foo := func(x) {
-					//       // ..
-					//       return foo(x-1)
-					//     }
-					//   }
-					//
-					// which translate into
-					//
-					//   0000 GETL    0
-					//   0002 CLOSURE ?     1
-					//   0006 DEFL    0
-					//
-					// . So the local variable (0) is being captured before
-					// it's assigned the value.
-					//
-					// Solution is to transform the code into something like
-					// this:
-					//
-					//   func() {
-					//     foo := nil
-					//     foo = func(x) {
-					//       // ..
-					//       return foo(x-1)
-					//     }
-					//   }
-					//
-					// that is equivalent to
-					//
-					//   0000 NULL
-					//   0001 DEFL    0
-					//   0003 GETL    0
-					//   0005 CLOSURE ?     1
-					//   0009 SETL    0
-					//
-					c.emit(node, parser.OpNull)
-					c.emit(node, parser.OpDefineLocal, s.Index)
-					s.LocalAssigned = true
-				}
-				c.emit(node, parser.OpGetLocalPtr, s.Index)
-			case ScopeFree:
-				c.emit(node, parser.OpGetFreePtr, s.Index)
+		return c.compileFuncLit(node, false)
+	case *parser.YieldStmt:
+		if c.scopeIndex == 0 {
+			return c.errorf(node, "yield not allowed outside function")
+		}
+		hasKey := 0
+		if node.Key != nil {
+			if err := c.Compile(node.Key); err != nil {
+				return err
 			}
+			hasKey = 1
 		}
-
-		compiledFunction := &CompiledFunction{
-			Instructions:  instructions,
-			NumLocals:     numLocals,
-			NumParameters: len(node.Type.Params.List),
-			VarArgs:       node.Type.Params.VarArgs,
-			SourceMap:     sourceMap,
-		}
-		if len(freeSymbols) > 0 {
-			c.emit(node, parser.OpClosure,
-				c.addConstant(compiledFunction), len(freeSymbols))
+		if node.Value != nil {
+			if err := c.Compile(node.Value); err != nil {
+				return err
+			}
 		} else {
-			c.emit(node, parser.OpConstant, c.addConstant(compiledFunction))
+			c.emit(node, parser.OpNull)
 		}
+		c.emit(node, parser.OpYield, hasKey)
+		c.scopes[c.scopeIndex].Generator = true
 	case *parser.ReturnStmt:
 		if c.symbol.Parent(true) == nil {
 			// outside the function
@@ -489,27 +544,27 @@ func (c *Compiler) Compile(node parser.Node) error {
 		}
 
 		if node.Result == nil {
+			if err := c.exitTries(node, 0); err != nil {
+				return err
+			}
+			c.exitLoops(node, nil, true)
 			c.emit(node, parser.OpReturn, 0)
 		} else {
-			if err := c.Compile(node.Result); err != nil {
+			// the exception handlers and the iterators must stay until the
+			// call returns
+			tail := len(c.scopes[c.scopeIndex].Tries) == 0 &&
+				!c.inForIn()
+			if err := c.compileResult(node.Result, tail); err != nil {
 				return err
 			}
-			c.emit(node, parser.OpReturn, 1)
-		}
-	case *parser.CallExpr:
-		if err := c.Compile(node.Func); err != nil {
-			return err
-		}
-		for _, arg := range node.Args {
-			if err := c.Compile(arg); err != nil {
+			if err := c.exitTries(node, 0); err != nil {
 				return err
 			}
+			c.exitLoops(node, nil, true)
+			c.emit(node, parser.OpReturn, 1)
 		}
-		ellipsis := 0
-		if node.Ellipsis.IsValid() {
-			ellipsis = 1
-		}
-		c.emit(node, parser.OpCall, len(node.Args), ellipsis)
+	case *parser.CallExpr:
+		return c.compileCallExpr(node, parser.OpCall)
 	case *parser.ImportExpr:
 		if node.ModuleName == "" {
 			return c.errorf(node, "empty module name")
@@ -563,53 +618,114 @@ func (c *Compiler) Compile(node parser.Node) error {
 		} else {
 			return c.errorf(node, "module '%s' not found", node.ModuleName)
 		}
+	case *parser.ClassStmt:
+		return c.compileClassStmt(node)
+	case *parser.ConstStmt:
+		return c.compileConstStmt(node)
 	case *parser.ExportStmt:
 		// export statement must be in top-level scope
 		if c.scopeIndex != 0 {
 			return c.errorf(node, "export not allowed inside function")
 		}
+		if node.Decl != nil {
+			return c.compileExportConst(node)
+		}
 
 		// export statement is simply ignore when compiling non-module code
 		if c.parent == nil {
 			break
 		}
+		if len(c.exports) > 0 {
+			return c.errorf(node,
+				"export value not allowed with exported constants")
+		}
+		c.exportValue = true
 		if err := c.Compile(node.Result); err != nil {
 			return err
 		}
+		if err := c.exitTries(node, 0); err != nil {
+			return err
+		}
+		c.emit(node, parser.OpReturn, 1)
+	case *parser.PropagateExpr:
+		if c.symbol.Parent(true) == nil {
+			// outside the function
+			return c.errorf(node,
+				"error propagation not allowed outside function")
+		}
+		if err := c.Compile(node.Expr); err != nil {
+			return err
+		}
+
+		// return the value if it's an error
+		jumpPos := c.emit(node, parser.OpJumpNotError, 0)
+		if err := c.exitTries(node, 0); err != nil {
+			return err
+		}
 		c.emit(node, parser.OpReturn, 1)
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
 	case *parser.ErrorExpr:
 		if err := c.Compile(node.Expr); err != nil {
 			return err
 		}
 		c.emit(node, parser.OpError)
-	case *parser.CondExpr:
-		if err := c.Compile(node.Cond); err != nil {
+	case *parser.ImmutableExpr:
+		if err := c.Compile(node.Expr); err != nil {
 			return err
 		}
+		c.emit(node, parser.OpImmutable)
+	case *parser.CondExpr:
+		return c.compileCondExpr(node, false)
+	}
+	return nil
+}
 
-		// first jump placeholder
-		jumpPos1 := c.emit(node, parser.OpJumpFalsy, 0)
-		if err := c.Compile(node.True); err != nil {
-			return err
-		}
+// compileCondExpr compiles the conditional expression. If it's in tail
+// position, so are the expressions of both branches.
+func (c *Compiler) compileCondExpr(node *parser.CondExpr, tail bool) error {
+	if err := c.Compile(node.Cond); err != nil {
+		return err
+	}
 
-		// second jump placeholder
-		jumpPos2 := c.emit(node, parser.OpJump, 0)
+	// first jump placeholder
+	jumpPos1 := c.emit(node, parser.OpJumpFalsy, 0)
+	if err := c.compileResult(node.True, tail); err != nil {
+		return err
+	}
 
-		// update first jump offset
-		curPos := len(c.currentInstructions())
-		c.changeOperand(jumpPos1, curPos)
-		if err := c.Compile(node.False); err != nil {
-			return err
-		}
+	// second jump placeholder
+	jumpPos2 := c.emit(node, parser.OpJump, 0)
 
-		// update second jump offset
-		curPos = len(c.currentInstructions())
-		c.changeOperand(jumpPos2, curPos)
+	// update first jump offset
+	curPos := len(c.currentInstructions())
+	c.changeOperand(jumpPos1, curPos)
+	if err := c.compileResult(node.False, tail); err != nil {
+		return err
 	}
+
+	// update second jump offset
+	curPos = len(c.currentInstructions())
+	c.changeOperand(jumpPos2, curPos)
 	return nil
 }
 
+// compileResult compiles the value of an expression. If it's in tail position,
+// the function returns the value, and a call reuses the frame of the function.
+func (c *Compiler) compileResult(expr parser.Expr, tail bool) error {
+	if !tail {
+		return c.Compile(expr)
+	}
+	switch expr := expr.(type) {
+	case *parser.CallExpr:
+		return c.compileCallExpr(expr, parser.OpTailCall)
+	case *parser.ParenExpr:
+		return c.compileResult(expr.Expr, true)
+	case *parser.CondExpr:
+		return c.compileCondExpr(expr, true)
+	}
+	return c.Compile(expr)
+}
+
 // Bytecode returns a compiled bytecode.
 func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
@@ -622,6 +738,13 @@ func (c *Compiler) Bytecode() *Bytecode {
 	}
 }
 
+// EnableCheckedArithmetic enables or disables checked arithmetic mode, where
+// an int overflow of +, -, * and << operations and unary minus is a runtime
+// error instead of wrapping around.
+func (c *Compiler) EnableCheckedArithmetic(enable bool) {
+	c.checked = enable
+}
+
 // EnableFileImport enables or disables module loading from local files.
 // Local file modules are disabled by default.
 func (c *Compiler) EnableFileImport(enable bool) {
@@ -639,8 +762,12 @@ func (c *Compiler) compileAssign(
 	op parser.Token,
 ) error {
 	numLHS, numRHS := len(lhs), len(rhs)
-	if numLHS > 1 || numRHS > 1 {
-		return c.errorf(node, "tuple assignment not allowed")
+	if numLHS > 1 || numRHS > 1 || isPattern(lhs[0]) {
+		return c.compileDestructuring(node, lhs, rhs, op)
+	}
+
+	if _, isChain := lhs[0].(*parser.ChainExpr); isChain {
+		return c.errorf(node, "cannot assign to optional chain")
 	}
 
 	// resolve and compile left-hand side
@@ -680,11 +807,11 @@ func (c *Compiler) compileAssign(
 
 	switch op {
 	case parser.TokenAddAssign:
-		c.emit(node, parser.OpBinaryOp, int(parser.TokenAdd))
+		c.emitBinaryOp(node, parser.TokenAdd)
 	case parser.TokenSubAssign:
-		c.emit(node, parser.OpBinaryOp, int(parser.TokenSub))
+		c.emitBinaryOp(node, parser.TokenSub)
 	case parser.TokenMulAssign:
-		c.emit(node, parser.OpBinaryOp, int(parser.TokenMul))
+		c.emitBinaryOp(node, parser.TokenMul)
 	case parser.TokenQuoAssign:
 		c.emit(node, parser.OpBinaryOp, int(parser.TokenQuo))
 	case parser.TokenRemAssign:
@@ -698,11 +825,27 @@ func (c *Compiler) compileAssign(
 	case parser.TokenXorAssign:
 		c.emit(node, parser.OpBinaryOp, int(parser.TokenXor))
 	case parser.TokenShlAssign:
-		c.emit(node, parser.OpBinaryOp, int(parser.TokenShl))
+		c.emitBinaryOp(node, parser.TokenShl)
 	case parser.TokenShrAssign:
 		c.emit(node, parser.OpBinaryOp, int(parser.TokenShr))
 	}
 
+	return c.compileStore(node, symbol, selectors, op)
+}
+
+// compileStore stores the value on top of the stack to the symbol, or to its
+// element designated by the selectors.
+func (c *Compiler) compileStore(
+	node parser.Node,
+	symbol *SymbolObject,
+	selectors []parser.Expr,
+	op parser.Token,
+) error {
+	if symbol.Constant {
+		return c.errorf(node, "cannot assign to constant '%s'", symbol.Name)
+	}
+	numSel := len(selectors)
+
 	// compile selector expressions (right to left)
 	for i := numSel - 1; i >= 0; i-- {
 		if err := c.Compile(selectors[i]); err != nil {
@@ -743,103 +886,636 @@ func (c *Compiler) compileAssign(
 	return nil
 }
 
-func (c *Compiler) compileLogical(node *parser.BinaryExpr) error {
-	// left side term
-	if err := c.Compile(node.LHS); err != nil {
-		return err
+// compileDestructuring compiles the assignments of multiple values, and the
+// assignments to array and map patterns:
+//
+//	a, b := f()              // same as [a, b] := f()
+//	a, b = b, a              // values are evaluated before assignments
+//	[x, y, ...rest] := arr
+//	{name, age: n} := person
+func (c *Compiler) compileDestructuring(
+	node parser.Node,
+	lhs, rhs []parser.Expr,
+	op parser.Token,
+) error {
+	if op != parser.TokenAssign && op != parser.TokenDefine {
+		return c.errorf(node, "operator '%s' not allowed with multiple values",
+			op.String())
 	}
 
-	// jump position
-	var jumpPos int
-	if node.Token == parser.TokenLAnd {
-		jumpPos = c.emit(node, parser.OpAndJump, 0)
+	var numNew int
+	if len(rhs) == 1 {
+		if err := c.Compile(rhs[0]); err != nil {
+			return err
+		}
+		target := lhs[0]
+		if len(lhs) > 1 {
+			target = &parser.ArrayLit{Elements: lhs}
+		}
+		if err := c.compileUnpack(node, target, op, &numNew); err != nil {
+			return err
+		}
+	} else if len(lhs) == len(rhs) {
+		for _, expr := range rhs {
+			if err := c.Compile(expr); err != nil {
+				return err
+			}
+		}
+		for i := len(lhs) - 1; i >= 0; i-- {
+			err := c.compileUnpack(node, lhs[i], op, &numNew)
+			if err != nil {
+				return err
+			}
+		}
 	} else {
-		jumpPos = c.emit(node, parser.OpOrJump, 0)
+		return c.errorf(node, "assignment mismatch: %d variables but %d values",
+			len(lhs), len(rhs))
 	}
 
-	// right side term
-	if err := c.Compile(node.RHS); err != nil {
-		return err
+	if op == parser.TokenDefine && numNew == 0 {
+		return c.errorf(node, "no new variables on left side of :=")
 	}
-
-	c.changeOperand(jumpPos, len(c.currentInstructions()))
 	return nil
 }
 
-func (c *Compiler) compileForStmt(stmt *parser.ForStmt) error {
-	c.symbol = c.symbol.Fork(true)
-	defer func() {
-		c.symbol = c.symbol.Parent(false)
-	}()
-
-	// init statement
-	if stmt.Init != nil {
-		if err := c.Compile(stmt.Init); err != nil {
-			return err
+// compileUnpack assigns the value on top of the stack to the target, which is
+// either an array or map pattern, or a single assignable expression. With
+// operator ':=', the variables not yet declared in the block are defined and
+// counted in numNew; the others are assigned.
+func (c *Compiler) compileUnpack(
+	node parser.Node,
+	target parser.Expr,
+	op parser.Token,
+	numNew *int,
+) error {
+	switch target := target.(type) {
+	case *parser.ArrayLit:
+		elements := target.Elements
+		var rest *parser.SpreadExpr
+		for i, elem := range elements {
+			if spread, ok := elem.(*parser.SpreadExpr); ok {
+				if i != len(elements)-1 {
+					return c.errorf(elem, "rest element must be last")
+				}
+				rest = spread
+				elements = elements[:i]
+			}
 		}
-	}
-
-	// pre-condition position
-	preCondPos := len(c.currentInstructions())
 
-	// condition expression
-	postCondPos := -1
-	if stmt.Cond != nil {
-		if err := c.Compile(stmt.Cond); err != nil {
-			return err
+		// UNPACK pushes the rest array first and then the elements in
+		// reverse order, so they are popped in order.
+		if rest != nil {
+			c.emit(node, parser.OpUnpack, len(elements), 1)
+		} else {
+			c.emit(node, parser.OpUnpack, len(elements), 0)
+		}
+		for _, elem := range elements {
+			if err := c.compileUnpack(node, elem, op, numNew); err != nil {
+				return err
+			}
+		}
+		if rest != nil {
+			return c.compileUnpack(node, rest.Expr, op, numNew)
+		}
+		return nil
+	case *parser.MapLit:
+		for _, elem := range target.Elements {
+			c.emit(elem, parser.OpConstant,
+				c.addConstant(&String{Value: elem.Key}))
+		}
+		c.emit(node, parser.OpUnpackMap, len(target.Elements))
+		for _, elem := range target.Elements {
+			err := c.compileUnpack(node, elem.Value, op, numNew)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case *parser.SpreadExpr:
+		return c.errorf(target, "rest element not allowed here")
+	case *parser.Ident:
+		if target.Name == "_" {
+			c.emit(node, parser.OpPop)
+			return nil
 		}
-		// condition jump position
-		postCondPos = c.emit(stmt, parser.OpJumpFalsy, 0)
 	}
 
-	// enter loop
-	loop := c.enterLoop()
+	if _, isChain := target.(*parser.ChainExpr); isChain {
+		return c.errorf(target, "cannot assign to optional chain")
+	}
 
-	// body statement
-	if err := c.Compile(stmt.Body); err != nil {
-		c.leaveLoop()
-		return err
+	ident, selectors := resolveAssignLHS(target)
+	if op == parser.TokenDefine && len(selectors) > 0 {
+		// using selector on new variable does not make sense
+		return c.errorf(target, "operator ':=' not allowed with selector")
 	}
 
-	c.leaveLoop()
+	symbol, depth, exists := c.symbol.Resolve(ident, false)
+	if op == parser.TokenDefine && (depth != 0 || !exists) {
+		symbol = c.symbol.Define(ident)
+		*numNew++
+	} else if !exists {
+		return c.errorf(target, "unresolved reference '%s'", ident)
+	}
+	return c.compileStore(target, symbol, selectors, op)
+}
 
-	// post-body position
-	postBodyPos := len(c.currentInstructions())
+func (c *Compiler) compileClassStmt(node *parser.ClassStmt) error {
+	name := node.Name.Name
+	_, depth, exists := c.symbol.Resolve(name, false)
+	if depth == 0 && exists {
+		return c.errorf(node, "'%s' redeclared in this block", name)
+	}
+	// defined before the methods are compiled so that they can refer to it
+	symbol := c.symbol.Define(name)
 
-	// post statement
-	if stmt.Post != nil {
-		if err := c.Compile(stmt.Post); err != nil {
+	c.emit(node, parser.OpConstant, c.addConstant(&String{Value: name}))
+	var methods []*parser.MapElementLit
+	seen := make(map[string]bool)
+	for _, elt := range node.Body.Elements {
+		if isSpread(elt.Value) {
+			return c.errorf(elt, "spread element not allowed in class")
+		}
+		if seen[elt.Key] {
+			return c.errorf(elt, "duplicate class element '%s'", elt.Key)
+		}
+		seen[elt.Key] = true
+		if len(elt.Key) > MaxStringLen {
+			return c.error(elt, ErrStringLimit)
+		}
+		if _, ok := elt.Value.(*parser.FuncLit); ok {
+			methods = append(methods, elt)
+			continue
+		}
+		c.emit(elt, parser.OpConstant, c.addConstant(&String{Value: elt.Key}))
+		if err := c.Compile(elt.Value); err != nil {
 			return err
 		}
 	}
+	for _, elt := range methods {
+		c.emit(elt, parser.OpConstant, c.addConstant(&String{Value: elt.Key}))
+		fn := elt.Value.(*parser.FuncLit)
+		if err := c.compileFuncLit(fn, true); err != nil {
+			return err
+		}
+	}
+	numFields := len(node.Body.Elements) - len(methods)
+	c.emit(node, parser.OpClass, numFields, len(methods))
+	return c.compileStore(node, symbol, nil, parser.TokenDefine)
+}
 
-	// back to condition
-	c.emit(stmt, parser.OpJump, preCondPos)
-
-	// post-statement position
-	postStmtPos := len(c.currentInstructions())
-	if postCondPos >= 0 {
-		c.changeOperand(postCondPos, postStmtPos)
+func (c *Compiler) compileConstStmt(node *parser.ConstStmt) error {
+	// fold the value before the name is defined so that it refers to the
+	// outer symbol, if any
+	value := c.constantValue(node.Value)
+	err := c.compileAssign(node, []parser.Expr{node.Name},
+		[]parser.Expr{node.Value}, parser.TokenDefine)
+	if err != nil {
+		return err
 	}
+	symbol, _, _ := c.symbol.Resolve(node.Name.Name, false)
+	symbol.Constant = true
+	symbol.Value = value
+	return nil
+}
 
-	// update all break/continue jump positions
-	for _, pos := range loop.Breaks {
-		c.changeOperand(pos, postStmtPos)
+func (c *Compiler) compileExportConst(node *parser.ExportStmt) error {
+	if c.symbol.block {
+		return c.errorf(node, "exported constant must be in top-level scope")
 	}
-	for _, pos := range loop.Continues {
-		c.changeOperand(pos, postBodyPos)
+	if c.exportValue {
+		return c.errorf(node,
+			"exported constants not allowed with export value")
+	}
+	if err := c.compileConstStmt(node.Decl); err != nil {
+		return err
+	}
+
+	// exported constants are ignored when compiling non-module code
+	if c.parent != nil {
+		symbol, _, _ := c.symbol.Resolve(node.Decl.Name.Name, false)
+		c.exports = append(c.exports, symbol)
 	}
 	return nil
 }
 
-func (c *Compiler) compileForInStmt(stmt *parser.ForInStmt) error {
-	c.symbol = c.symbol.Fork(true)
-	defer func() {
-		c.symbol = c.symbol.Parent(false)
-	}()
-
-	// for-in statement is compiled like following:
-	//
+// compileConstExports returns an immutable map of the exported constants as
+// the module value.
+func (c *Compiler) compileConstExports(node parser.Node) {
+	for _, symbol := range c.exports {
+		c.emit(node, parser.OpConstant,
+			c.addConstant(&String{Value: symbol.Name}))
+		if symbol.Value != nil {
+			c.emitConstant(node, symbol.Value)
+		} else {
+			c.emit(node, parser.OpGetLocal, symbol.Index)
+		}
+	}
+	c.emit(node, parser.OpMap, len(c.exports)*2)
+	c.emit(node, parser.OpImmutable)
+	c.emit(node, parser.OpReturn, 1)
+}
+
+// compileArrayLit compiles the array of the elements. The elements from the
+// first spread element are appended one by one.
+func (c *Compiler) compileArrayLit(node parser.Node, elems []parser.Expr) error {
+	n := 0
+	for n < len(elems) && !isSpread(elems[n]) {
+		n++
+	}
+	for _, elem := range elems[:n] {
+		if err := c.Compile(elem); err != nil {
+			return err
+		}
+	}
+	c.emit(node, parser.OpArray, n)
+	for _, elem := range elems[n:] {
+		if spread, ok := elem.(*parser.SpreadExpr); ok {
+			if err := c.Compile(spread.Expr); err != nil {
+				return err
+			}
+			c.emit(spread, parser.OpSpread, 0)
+			continue
+		}
+		if err := c.Compile(elem); err != nil {
+			return err
+		}
+		c.emit(elem, parser.OpAppend)
+	}
+	return nil
+}
+
+// compileMapLit compiles the map literal. The elements from the first spread
+// element are set one by one.
+func (c *Compiler) compileMapLit(node *parser.MapLit) error {
+	n := 0
+	for n < len(node.Elements) && !isSpread(node.Elements[n].Value) {
+		n++
+	}
+	for _, elt := range node.Elements[:n] {
+		if err := c.compileMapElementLit(node, elt); err != nil {
+			return err
+		}
+	}
+	c.emit(node, parser.OpMap, n*2)
+	for _, elt := range node.Elements[n:] {
+		if spread, ok := elt.Value.(*parser.SpreadExpr); ok {
+			if err := c.Compile(spread.Expr); err != nil {
+				return err
+			}
+			c.emit(spread, parser.OpSpread, 1)
+			continue
+		}
+		if err := c.compileMapElementLit(node, elt); err != nil {
+			return err
+		}
+		c.emit(elt, parser.OpAppendMap)
+	}
+	return nil
+}
+
+func (c *Compiler) compileMapElementLit(
+	node *parser.MapLit,
+	elt *parser.MapElementLit,
+) error {
+	// key
+	if len(elt.Key) > MaxStringLen {
+		return c.error(node, ErrStringLimit)
+	}
+	c.emit(node, parser.OpConstant, c.addConstant(&String{Value: elt.Key}))
+
+	// value
+	return c.Compile(elt.Value)
+}
+
+// compileCallExpr compiles the call using the opcode op, which is either CALL
+// or TAILCALL.
+func (c *Compiler) compileCallExpr(
+	node *parser.CallExpr,
+	op parser.Opcode,
+) error {
+	if c.isBuiltin(node.Func, "checked") && len(node.Args) == 1 &&
+		!node.Ellipsis.IsValid() && len(node.NamedArgs) == 0 {
+		// checked(expr) compiles expr in checked arithmetic mode
+		checked := c.checked
+		c.checked = true
+		err := c.Compile(node.Args[0])
+		c.checked = checked
+		return err
+	}
+	if err := c.Compile(node.Func); err != nil {
+		return err
+	}
+	numArgs, flags, err := c.compileCallArgs(node)
+	if err != nil {
+		return err
+	}
+	c.emit(node, op, numArgs, flags)
+	return nil
+}
+
+// compileCallArgs compiles the arguments of the call, and returns the number
+// of the arguments on the stack and the call flags. The named arguments are
+// passed as a map after the other arguments.
+func (c *Compiler) compileCallArgs(
+	node *parser.CallExpr,
+) (numArgs, flags int, err error) {
+	args := node.Args
+	if node.Ellipsis.IsValid() {
+		// f(a...) is the same as f(...a)
+		last := len(args) - 1
+		args = append(args[:last:last], &parser.SpreadExpr{
+			Ellipsis: node.Ellipsis,
+			Expr:     args[last],
+		})
+	}
+	numSpread := 0
+	for _, arg := range args {
+		if _, ok := arg.(*parser.SpreadExpr); ok {
+			numSpread++
+		}
+	}
+	numArgs = len(args)
+	switch {
+	case numSpread == 0:
+		for _, arg := range args {
+			if err := c.Compile(arg); err != nil {
+				return 0, 0, err
+			}
+		}
+	case numSpread == 1 && numArgs > 0 && isSpread(args[numArgs-1]):
+		// only the last argument is spread on the stack
+		for _, arg := range args[:numArgs-1] {
+			if err := c.Compile(arg); err != nil {
+				return 0, 0, err
+			}
+		}
+		spread := args[numArgs-1].(*parser.SpreadExpr)
+		if err := c.Compile(spread.Expr); err != nil {
+			return 0, 0, err
+		}
+		flags |= callSpread
+	default:
+		// the arguments are collected into an array which is spread
+		if err := c.compileArrayLit(node, args); err != nil {
+			return 0, 0, err
+		}
+		numArgs = 1
+		flags |= callSpread
+	}
+	if len(node.NamedArgs) == 0 {
+		return numArgs, flags, nil
+	}
+	seen := make(map[string]bool)
+	for _, arg := range node.NamedArgs {
+		if seen[arg.Key] {
+			return 0, 0, c.errorf(arg, "duplicate argument '%s'", arg.Key)
+		}
+		seen[arg.Key] = true
+		c.emit(arg, parser.OpConstant,
+			c.addConstant(&String{Value: arg.Key}))
+		if err := c.Compile(arg.Value); err != nil {
+			return 0, 0, err
+		}
+	}
+	c.emit(node, parser.OpMap, len(node.NamedArgs)*2)
+	return numArgs + 1, flags | callKwargs, nil
+}
+
+// compileFuncLit compiles the function literal. A method takes the instance
+// as the implicit first parameter self.
+func (c *Compiler) compileFuncLit(node *parser.FuncLit, method bool) error {
+	c.enterScope()
+
+	params := node.Type.Params
+	numParams := len(params.List)
+	var paramNames []string
+	if method {
+		// the instance is passed as the first argument
+		c.symbol.Define("self").LocalAssigned = true
+		paramNames = append(paramNames, "self")
+		numParams++
+	}
+	symbols := make([]*SymbolObject, len(params.List))
+	for i, p := range params.List {
+		s := c.symbol.Define(p.Name)
+
+		// function arguments is not assigned directly.
+		s.LocalAssigned = true
+		symbols[i] = s
+		paramNames = append(paramNames, p.Name)
+	}
+
+	// the parameters with default values that are not passed are set before
+	// the function body runs
+	numDefaults := 0
+	for i, p := range params.List {
+		def := params.Default(i)
+		if def == nil {
+			if numDefaults > 0 && !(params.VarArgs && i == len(params.List)-1) {
+				return c.errorf(p, "missing default value for parameter '%s'",
+					p.Name)
+			}
+			continue
+		}
+		numDefaults++
+		c.emit(p, parser.OpGetLocal, symbols[i].Index)
+		jumpPos := c.emit(p, parser.OpArgJump, 0)
+		if err := c.Compile(def); err != nil {
+			return err
+		}
+		c.emit(p, parser.OpSetLocal, symbols[i].Index)
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+	}
+
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+
+	// code optimization
+	c.optimizeFunc(node)
+
+	freeSymbols := c.symbol.FreeSymbols()
+	numLocals := c.symbol.MaxSymbols()
+	generator := c.scopes[c.scopeIndex].Generator
+	instructions, sourceMap := c.leaveScope()
+
+	for _, s := range freeSymbols {
+		switch s.Scope {
+		case ScopeLocal:
+			if !s.LocalAssigned {
+				// Here, the closure is capturing a local variable that's
+				// not yet assigned its value. One example is a local
+				// recursive function:
+				//
+				//   func() {
+				//     foo := func(x) {
+				//       // ..
+				//       return foo(x-1)
+				//     }
+				//   }
+				//
+				// which translate into
+				//
+				//   0000 GETL    0
+				//   0002 CLOSURE ?     1
+				//   0006 DEFL    0
+				//
+				// . So the local variable (0) is being captured before
+				// it's assigned the value.
+				//
+				// Solution is to transform the code into something like
+				// this:
+				//
+				//   func() {
+				//     foo := nil
+				//     foo = func(x) {
+				//       // ..
+				//       return foo(x-1)
+				//     }
+				//   }
+				//
+				// that is equivalent to
+				//
+				//   0000 NULL
+				//   0001 DEFL    0
+				//   0003 GETL    0
+				//   0005 CLOSURE ?     1
+				//   0009 SETL    0
+				//
+				c.emit(node, parser.OpNull)
+				c.emit(node, parser.OpDefineLocal, s.Index)
+				s.LocalAssigned = true
+			}
+			c.emit(node, parser.OpGetLocalPtr, s.Index)
+		case ScopeFree:
+			c.emit(node, parser.OpGetFreePtr, s.Index)
+		}
+	}
+
+	compiledFunction := &CompiledFunction{
+		Instructions:  instructions,
+		NumLocals:     numLocals,
+		NumParameters: numParams,
+		NumDefaults:   numDefaults,
+		ParamNames:    paramNames,
+		VarArgs:       params.VarArgs,
+		Generator:     generator,
+		SourceMap:     sourceMap,
+	}
+	if len(freeSymbols) > 0 {
+		c.emit(node, parser.OpClosure,
+			c.addConstant(compiledFunction), len(freeSymbols))
+	} else {
+		c.emit(node, parser.OpConstant, c.addConstant(compiledFunction))
+	}
+	return nil
+}
+
+func (c *Compiler) compileLogical(node *parser.BinaryExpr) error {
+	// left side term
+	if err := c.Compile(node.LHS); err != nil {
+		return err
+	}
+
+	// jump position
+	var jumpPos int
+	switch node.Token {
+	case parser.TokenLAnd:
+		jumpPos = c.emit(node, parser.OpAndJump, 0)
+	case parser.TokenLOr:
+		jumpPos = c.emit(node, parser.OpOrJump, 0)
+	default:
+		jumpPos = c.emit(node, parser.OpCoalesceJump, 0)
+	}
+
+	// right side term
+	if err := c.Compile(node.RHS); err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+func (c *Compiler) compileForStmt(stmt *parser.ForStmt, label string) error {
+	c.symbol = c.symbol.Fork(true)
+	defer func() {
+		c.symbol = c.symbol.Parent(false)
+	}()
+
+	// init statement
+	if stmt.Init != nil {
+		if err := c.Compile(stmt.Init); err != nil {
+			return err
+		}
+	}
+
+	// pre-condition position
+	preCondPos := len(c.currentInstructions())
+
+	// condition expression
+	postCondPos := -1
+	if stmt.Cond != nil {
+		if err := c.Compile(stmt.Cond); err != nil {
+			return err
+		}
+		// condition jump position
+		postCondPos = c.emit(stmt, parser.OpJumpFalsy, 0)
+	}
+
+	// enter loop
+	loop := c.enterLoop()
+	loop.Label = label
+
+	// body statement
+	if err := c.Compile(stmt.Body); err != nil {
+		c.leaveLoop()
+		return err
+	}
+
+	c.leaveLoop()
+
+	// post-body position
+	postBodyPos := len(c.currentInstructions())
+
+	// post statement
+	if stmt.Post != nil {
+		if err := c.Compile(stmt.Post); err != nil {
+			return err
+		}
+	}
+
+	// back to condition
+	c.emit(stmt, parser.OpJump, preCondPos)
+
+	// post-statement position
+	postStmtPos := len(c.currentInstructions())
+	if postCondPos >= 0 {
+		c.changeOperand(postCondPos, postStmtPos)
+	}
+
+	// update all break/continue jump positions
+	for _, pos := range loop.Breaks {
+		c.changeOperand(pos, postStmtPos)
+	}
+	for _, pos := range loop.Continues {
+		c.changeOperand(pos, postBodyPos)
+	}
+	return nil
+}
+
+func (c *Compiler) compileForInStmt(
+	stmt *parser.ForInStmt,
+	label string,
+) error {
+	c.symbol = c.symbol.Fork(true)
+	defer func() {
+		c.symbol = c.symbol.Parent(false)
+	}()
+
+	// for-in statement is compiled like following:
+	//
 	//   for :it := iterator(iterable); :it.next();  {
 	//     k, v := :it.get()  // DEFINE operator
 	//
@@ -862,86 +1538,746 @@ func (c *Compiler) compileForInStmt(stmt *parser.ForInStmt) error {
 		c.emit(stmt, parser.OpDefineLocal, itSymbol.Index)
 	}
 
-	// pre-condition position
-	preCondPos := len(c.currentInstructions())
+	// pre-condition position
+	preCondPos := len(c.currentInstructions())
+
+	// condition
+	//  :it.HasMore()
+	if itSymbol.Scope == ScopeGlobal {
+		c.emit(stmt, parser.OpGetGlobal, itSymbol.Index)
+	} else {
+		c.emit(stmt, parser.OpGetLocal, itSymbol.Index)
+	}
+	c.emit(stmt, parser.OpIteratorNext)
+
+	// condition jump position
+	postCondPos := c.emit(stmt, parser.OpJumpFalsy, 0)
+
+	// enter loop
+	loop := c.enterLoop()
+	loop.Label = label
+	loop.Iterator = itSymbol
+
+	// assign key variable
+	if stmt.Key.Name != "_" {
+		keySymbol := c.symbol.Define(stmt.Key.Name)
+		if itSymbol.Scope == ScopeGlobal {
+			c.emit(stmt, parser.OpGetGlobal, itSymbol.Index)
+		} else {
+			c.emit(stmt, parser.OpGetLocal, itSymbol.Index)
+		}
+		c.emit(stmt, parser.OpIteratorKey)
+		if keySymbol.Scope == ScopeGlobal {
+			c.emit(stmt, parser.OpSetGlobal, keySymbol.Index)
+		} else {
+			keySymbol.LocalAssigned = true
+			c.emit(stmt, parser.OpDefineLocal, keySymbol.Index)
+		}
+	}
+
+	// assign value variable
+	if stmt.Value.Name != "_" {
+		valueSymbol := c.symbol.Define(stmt.Value.Name)
+		if itSymbol.Scope == ScopeGlobal {
+			c.emit(stmt, parser.OpGetGlobal, itSymbol.Index)
+		} else {
+			c.emit(stmt, parser.OpGetLocal, itSymbol.Index)
+		}
+		c.emit(stmt, parser.OpIteratorValue)
+		if valueSymbol.Scope == ScopeGlobal {
+			c.emit(stmt, parser.OpSetGlobal, valueSymbol.Index)
+		} else {
+			valueSymbol.LocalAssigned = true
+			c.emit(stmt, parser.OpDefineLocal, valueSymbol.Index)
+		}
+	}
+
+	// body statement
+	if err := c.Compile(stmt.Body); err != nil {
+		c.leaveLoop()
+		return err
+	}
+
+	c.leaveLoop()
+
+	// post-body position
+	postBodyPos := len(c.currentInstructions())
+
+	// back to condition
+	c.emit(stmt, parser.OpJump, preCondPos)
+
+	// post-statement position
+	postStmtPos := len(c.currentInstructions())
+	c.changeOperand(postCondPos, postStmtPos)
+
+	// update all break/continue jump positions
+	for _, pos := range loop.Breaks {
+		c.changeOperand(pos, postStmtPos)
+	}
+	for _, pos := range loop.Continues {
+		c.changeOperand(pos, postBodyPos)
+	}
+	return nil
+}
+
+// compileComp compiles an array or map comprehension into a loop adding the
+// elements to the result, which is kept on the stack below the iterable and
+// preallocated if the length of the iterable is known:
+//
+//	:it := iterator(iterable)
+//	for :it.next() {
+//	  k, v := :it.get()
+//	  if cond { append(result, elem) }  // or result[key] = value
+//	}
+func (c *Compiler) compileComp(
+	node parser.Expr,
+	clause *parser.CompClause,
+	elems ...parser.Expr,
+) error {
+	c.symbol = c.symbol.Fork(true)
+	defer func() {
+		c.symbol = c.symbol.Parent(false)
+	}()
+
+	isMap := len(elems) == 2
+	it := c.symbol.Define(":it")
+	if err := c.Compile(clause.Iterable); err != nil {
+		return err
+	}
+	if isMap {
+		c.emit(node, parser.OpComp, 1)
+	} else {
+		c.emit(node, parser.OpComp, 0)
+	}
+	c.emit(node, parser.OpIteratorInit)
+	if err := c.compileStore(node, it, nil, parser.TokenDefine); err != nil {
+		return err
+	}
+	load := func() {
+		if it.Scope == ScopeGlobal {
+			c.emit(node, parser.OpGetGlobal, it.Index)
+		} else {
+			c.emit(node, parser.OpGetLocal, it.Index)
+		}
+	}
+
+	preCondPos := len(c.currentInstructions())
+	load()
+	c.emit(node, parser.OpIteratorNext)
+	postCondPos := c.emit(node, parser.OpJumpFalsy, 0)
+
+	vars := []struct {
+		ident *parser.Ident
+		op    parser.Opcode
+	}{
+		{clause.Key, parser.OpIteratorKey},
+		{clause.Value, parser.OpIteratorValue},
+	}
+	for _, v := range vars {
+		if v.ident.Name == "_" {
+			continue
+		}
+		symbol := c.symbol.Define(v.ident.Name)
+		load()
+		c.emit(node, v.op)
+		err := c.compileStore(v.ident, symbol, nil, parser.TokenDefine)
+		if err != nil {
+			return err
+		}
+	}
+
+	if clause.Cond != nil {
+		if err := c.Compile(clause.Cond); err != nil {
+			return err
+		}
+		c.emit(clause.Cond, parser.OpJumpFalsy, preCondPos)
+	}
+	for _, elem := range elems {
+		if err := c.Compile(elem); err != nil {
+			return err
+		}
+	}
+	if isMap {
+		c.emit(node, parser.OpAppendMap)
+	} else {
+		c.emit(node, parser.OpAppend)
+	}
+	c.emit(node, parser.OpJump, preCondPos)
+	c.changeOperand(postCondPos, len(c.currentInstructions()))
+	return nil
+}
+
+// typePatterns are the type names matching the values of the type when used
+// as identifier patterns of a match expression.
+var typePatterns = map[string]bool{
+	"array":     true,
+	"bigint":    true,
+	"bool":      true,
+	"bytes":     true,
+	"channel":   true,
+	"char":      true,
+	"decimal":   true,
+	"error":     true,
+	"float":     true,
+	"generator": true,
+	"int":       true,
+	"map":       true,
+	"routine":   true,
+	"string":    true,
+	"time":      true,
+}
+
+// compileMatchExpr compiles the match expression into a sequence of pattern
+// tests on the subject stored in ":m" local variable: a failed test jumps to
+// the next arm, and the first arm matching evaluates to its value.
+func (c *Compiler) compileMatchExpr(node *parser.MatchExpr) error {
+	c.symbol = c.symbol.Fork(true)
+	defer func() {
+		c.symbol = c.symbol.Parent(false)
+	}()
+
+	exhaustive, hasDefault := false, false
+	bools := make(map[bool]bool)
+	for _, arm := range node.Arms {
+		if arm.Patterns == nil {
+			if hasDefault {
+				return c.errorf(arm, "multiple defaults in match")
+			}
+			hasDefault = true
+		}
+		if arm.Guard == nil && c.irrefutable(arm) {
+			exhaustive = true
+		}
+		if arm.Guard == nil {
+			for _, pat := range arm.Patterns {
+				if v, ok := c.constantValue(pat).(*Bool); ok {
+					bools[v.Value] = true
+				}
+			}
+		}
+	}
+	// true and false patterns cover a bool subject
+	if len(bools) == 2 {
+		exhaustive = true
+	}
+	if !exhaustive && !c.matchesConstant(node) {
+		return c.errorf(node, "non-exhaustive match: missing default case")
+	}
+
+	subject := c.symbol.Define(":m")
+	if err := c.Compile(node.Subject); err != nil {
+		return err
+	}
+	if err := c.compileStore(node, subject, nil,
+		parser.TokenDefine); err != nil {
+		return err
+	}
+	load := func(node parser.Node) {
+		if subject.Scope == ScopeGlobal {
+			c.emit(node, parser.OpGetGlobal, subject.Index)
+		} else {
+			c.emit(node, parser.OpGetLocal, subject.Index)
+		}
+	}
+
+	var ends []int
+	for _, arm := range node.Arms {
+		c.symbol = c.symbol.Fork(true)
+		var fails []int
+		if len(arm.Patterns) == 1 {
+			err := c.compilePattern(arm.Patterns[0], load, &fails)
+			if err != nil {
+				return err
+			}
+		} else if len(arm.Patterns) > 1 {
+			// alternatives: a match jumps to the guard, and a failed
+			// test jumps to the next alternative
+			var matches []int
+			for i, pat := range arm.Patterns {
+				if patternBinds(pat) {
+					return c.errorf(pat,
+						"bindings not allowed in alternative patterns")
+				}
+				var altFails []int
+				if err := c.compilePattern(pat, load, &altFails); err != nil {
+					return err
+				}
+				if i == len(arm.Patterns)-1 {
+					fails = altFails
+					break
+				}
+				matches = append(matches, c.emit(pat, parser.OpJump, 0))
+				curPos := len(c.currentInstructions())
+				for _, pos := range altFails {
+					c.changeOperand(pos, curPos)
+				}
+			}
+			curPos := len(c.currentInstructions())
+			for _, pos := range matches {
+				c.changeOperand(pos, curPos)
+			}
+		}
+		if arm.Guard != nil {
+			if err := c.Compile(arm.Guard); err != nil {
+				return err
+			}
+			fails = append(fails, c.emit(arm.Guard, parser.OpJumpFalsy, 0))
+		}
+		if err := c.Compile(arm.Value); err != nil {
+			return err
+		}
+		ends = append(ends, c.emit(arm, parser.OpJump, 0))
+		curPos := len(c.currentInstructions())
+		for _, pos := range fails {
+			c.changeOperand(pos, curPos)
+		}
+		c.symbol = c.symbol.Parent(false)
+	}
+
+	// no arm matched
+	c.emit(node, parser.OpNull)
+	curPos := len(c.currentInstructions())
+	for _, pos := range ends {
+		c.changeOperand(pos, curPos)
+	}
+	return nil
+}
+
+// compilePattern compiles the test of the value pushed by load against the
+// pattern. A failed test jumps to a position added to fails, and a match
+// defines the variables bound by the pattern.
+func (c *Compiler) compilePattern(
+	pat parser.Expr,
+	load func(node parser.Node),
+	fails *[]int,
+) error {
+	fail := func(node parser.Node) {
+		*fails = append(*fails, c.emit(node, parser.OpJumpFalsy, 0))
+	}
+
+	switch pat := pat.(type) {
+	case *parser.Ident:
+		if pat.Name == "_" {
+			return nil
+		}
+		if typePatterns[pat.Name] {
+			c.emitTypeTest(pat, load, pat.Name)
+			fail(pat)
+			return nil
+		}
+		_, depth, exists := c.symbol.Resolve(pat.Name, false)
+		if depth == 0 && exists {
+			return c.errorf(pat, "'%s' redeclared in this block", pat.Name)
+		}
+		symbol := c.symbol.Define(pat.Name)
+		load(pat)
+		return c.compileStore(pat, symbol, nil, parser.TokenDefine)
+	case *parser.CallExpr:
+		// type(pattern)
+		typ, ok := pat.Func.(*parser.Ident)
+		if !ok || len(pat.Args) != 1 || pat.Ellipsis.IsValid() ||
+			len(pat.NamedArgs) > 0 {
+			return c.errorf(pat, "invalid type pattern")
+		}
+		c.emitTypeTest(pat, load, typ.Name)
+		fail(pat)
+		return c.compilePattern(pat.Args[0], load, fails)
+	case *parser.ErrorExpr:
+		// error(pattern) matches the value of the error
+		c.emitTypeTest(pat, load, "error")
+		fail(pat)
+		key := c.addConstant(&String{Value: "value"})
+		valueLoad := func(node parser.Node) {
+			load(node)
+			c.emit(node, parser.OpConstant, key)
+			c.emit(node, parser.OpIndex)
+		}
+		return c.compilePattern(pat.Expr, valueLoad, fails)
+	case *parser.ArrayLit:
+		c.emitTypeTest(pat, load, "array")
+		fail(pat)
+		elems := pat.Elements
+		var rest *parser.SpreadExpr
+		if n := len(elems); n > 0 {
+			if rest, _ = elems[n-1].(*parser.SpreadExpr); rest != nil {
+				elems = elems[:n-1]
+			}
+		}
+
+		// len(value) == n, or len(value) >= n with a rest element
+		c.emit(pat, parser.OpGetBuiltin, builtinIndex("len"))
+		load(pat)
+		c.emit(pat, parser.OpCall, 1, 0)
+		c.emit(pat, parser.OpConstant,
+			c.addConstant(&Int{Value: int64(len(elems))}))
+		if rest != nil {
+			c.emit(pat, parser.OpBinaryOp, int(parser.TokenGreaterEq))
+		} else {
+			c.emit(pat, parser.OpEqual)
+		}
+		fail(pat)
+
+		for i, elem := range elems {
+			if _, ok := elem.(*parser.SpreadExpr); ok {
+				return c.errorf(elem, "rest element must be last")
+			}
+			idx := c.addConstant(&Int{Value: int64(i)})
+			elemLoad := func(node parser.Node) {
+				load(node)
+				c.emit(node, parser.OpConstant, idx)
+				c.emit(node, parser.OpIndex)
+			}
+			if err := c.compilePattern(elem, elemLoad, fails); err != nil {
+				return err
+			}
+		}
+		if rest != nil {
+			low := c.addConstant(&Int{Value: int64(len(elems))})
+			restLoad := func(node parser.Node) {
+				load(node)
+				c.emit(node, parser.OpConstant, low)
+				c.emit(node, parser.OpNull)
+				c.emit(node, parser.OpSliceIndex)
+			}
+			return c.compilePattern(rest.Expr, restLoad, fails)
+		}
+	case *parser.MapLit:
+		c.emitTypeTest(pat, load, "map")
+		fail(pat)
+		for _, elt := range pat.Elements {
+			key := c.addConstant(&String{Value: elt.Key})
+			eltLoad := func(node parser.Node) {
+				load(node)
+				c.emit(node, parser.OpConstant, key)
+				c.emit(node, parser.OpIndex)
+			}
+
+			// the key matches if it's in the map, even with a nil value
+			load(elt)
+			c.emit(elt, parser.OpConstant, key)
+			c.emit(elt, parser.OpHasKey)
+			fail(elt)
+			if err := c.compilePattern(elt.Value, eltLoad, fails); err != nil {
+				return err
+			}
+		}
+	case *parser.NilLit:
+		load(pat)
+		c.emit(pat, parser.OpNull)
+		c.emit(pat, parser.OpEqual)
+		fail(pat)
+	default:
+		value := c.constantValue(pat)
+		if value == nil {
+			return c.errorf(pat, "invalid pattern")
+		}
+		load(pat)
+		c.emitConstant(pat, value)
+		c.emit(pat, parser.OpEqual)
+		fail(pat)
+	}
+	return nil
+}
+
+// emitTypeTest emits the comparison of the type name of the value pushed by
+// load with the given name.
+func (c *Compiler) emitTypeTest(
+	node parser.Node,
+	load func(node parser.Node),
+	name string,
+) {
+	c.emit(node, parser.OpGetBuiltin, builtinIndex("type"))
+	load(node)
+	c.emit(node, parser.OpCall, 1, 0)
+	c.emit(node, parser.OpConstant, c.addConstant(&String{Value: name}))
+	c.emit(node, parser.OpEqual)
+}
+
+// irrefutable returns true if the match arm matches any value, ignoring its
+// guard.
+func (c *Compiler) irrefutable(arm *parser.MatchArm) bool {
+	if arm.Patterns == nil {
+		return true
+	}
+	for _, pat := range arm.Patterns {
+		if ident, ok := pat.(*parser.Ident); ok && !typePatterns[ident.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesConstant returns true if the subject of the match expression is a
+// constant matched by a literal pattern of an arm without guard.
+func (c *Compiler) matchesConstant(node *parser.MatchExpr) bool {
+	value := c.constantValue(node.Subject)
+	if value == nil {
+		return false
+	}
+	for _, arm := range node.Arms {
+		if arm.Guard != nil {
+			continue
+		}
+		for _, pat := range arm.Patterns {
+			if v := c.constantValue(pat); v != nil && v.Equals(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// patternBinds returns true if the pattern binds a variable.
+func patternBinds(pat parser.Expr) bool {
+	switch pat := pat.(type) {
+	case *parser.Ident:
+		return pat.Name != "_" && !typePatterns[pat.Name]
+	case *parser.CallExpr:
+		return len(pat.Args) == 1 && patternBinds(pat.Args[0])
+	case *parser.ErrorExpr:
+		return patternBinds(pat.Expr)
+	case *parser.ArrayLit:
+		for _, elem := range pat.Elements {
+			if patternBinds(elem) {
+				return true
+			}
+		}
+	case *parser.SpreadExpr:
+		return patternBinds(pat.Expr)
+	case *parser.MapLit:
+		for _, elt := range pat.Elements {
+			if patternBinds(elt.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isBuiltin returns true if the expression is an identifier referring to the
+// builtin function with the given name.
+func (c *Compiler) isBuiltin(expr parser.Expr, name string) bool {
+	ident, ok := expr.(*parser.Ident)
+	if !ok || ident.Name != name {
+		return false
+	}
+	symbol, _, ok := c.symbol.Resolve(name, false)
+	return ok && symbol.Scope == ScopeBuiltin
+}
 
-	// condition
-	//  :it.HasMore()
-	if itSymbol.Scope == ScopeGlobal {
-		c.emit(stmt, parser.OpGetGlobal, itSymbol.Index)
-	} else {
-		c.emit(stmt, parser.OpGetLocal, itSymbol.Index)
+// builtinIndex returns the index of the builtin function with the given name.
+func builtinIndex(name string) int {
+	for idx, fn := range builtinFuncs {
+		if fn.Name == name {
+			return idx
+		}
 	}
-	c.emit(stmt, parser.OpIteratorNext)
+	panic(fmt.Errorf("unknown builtin function: %s", name))
+}
 
-	// condition jump position
-	postCondPos := c.emit(stmt, parser.OpJumpFalsy, 0)
+func (c *Compiler) compileLabeledStmt(stmt *parser.LabeledStmt) error {
+	label := stmt.Label.Name
+	if c.labeledLoop(label) != nil {
+		return c.errorf(stmt.Label, "label %s already defined", label)
+	}
+	switch loop := stmt.Stmt.(type) {
+	case *parser.ForStmt:
+		return c.compileForStmt(loop, label)
+	case *parser.ForInStmt:
+		return c.compileForInStmt(loop, label)
+	}
+	return c.errorf(stmt.Label, "label %s must precede a loop", label)
+}
 
-	// enter loop
-	loop := c.enterLoop()
+func (c *Compiler) compileSwitchStmt(stmt *parser.SwitchStmt) error {
+	c.symbol = c.symbol.Fork(true)
+	defer func() {
+		c.symbol = c.symbol.Parent(false)
+	}()
 
-	// assign key variable
-	if stmt.Key.Name != "_" {
-		keySymbol := c.symbol.Define(stmt.Key.Name)
-		if itSymbol.Scope == ScopeGlobal {
-			c.emit(stmt, parser.OpGetGlobal, itSymbol.Index)
-		} else {
-			c.emit(stmt, parser.OpGetLocal, itSymbol.Index)
+	// init statement
+	if stmt.Init != nil {
+		if err := c.Compile(stmt.Init); err != nil {
+			return err
 		}
-		c.emit(stmt, parser.OpIteratorKey)
-		if keySymbol.Scope == ScopeGlobal {
-			c.emit(stmt, parser.OpSetGlobal, keySymbol.Index)
-		} else {
-			keySymbol.LocalAssigned = true
-			c.emit(stmt, parser.OpDefineLocal, keySymbol.Index)
+	}
+
+	var clauses []*parser.CaseClause
+	defaultIdx := -1
+	for _, s := range stmt.Body.Stmts {
+		clause := s.(*parser.CaseClause)
+		if clause.List == nil {
+			if defaultIdx >= 0 {
+				return c.errorf(clause, "multiple defaults in switch")
+			}
+			defaultIdx = len(clauses)
 		}
+		clauses = append(clauses, clause)
 	}
 
-	// assign value variable
-	if stmt.Value.Name != "_" {
-		valueSymbol := c.symbol.Define(stmt.Value.Name)
-		if itSymbol.Scope == ScopeGlobal {
-			c.emit(stmt, parser.OpGetGlobal, itSymbol.Index)
-		} else {
-			c.emit(stmt, parser.OpGetLocal, itSymbol.Index)
+	// If every case value is a literal constant, switch statement is
+	// compiled into a single jump table lookup:
+	//
+	//   SWITCH  <table>  <default>
+	//
+	// Otherwise the case values are compared one by one with the tag value
+	// stored in ":sw" local variable, and the first match jumps into the
+	// case body.
+	var table *Map
+	var tableIdx, tablePos int
+	var keys [][]string
+	if stmt.Tag != nil {
+		var err error
+		keys, err = c.switchKeys(clauses)
+		if err != nil {
+			return err
 		}
-		c.emit(stmt, parser.OpIteratorValue)
-		if valueSymbol.Scope == ScopeGlobal {
-			c.emit(stmt, parser.OpSetGlobal, valueSymbol.Index)
-		} else {
-			valueSymbol.LocalAssigned = true
-			c.emit(stmt, parser.OpDefineLocal, valueSymbol.Index)
+		if keys != nil {
+			table = &Map{Value: make(map[string]Object)}
+			tableIdx = c.addConstant(table)
 		}
 	}
 
-	// body statement
-	if err := c.Compile(stmt.Body); err != nil {
-		c.leaveLoop()
-		return err
+	var jumps [][]int
+	var defaultJump int
+	if table != nil {
+		if err := c.Compile(stmt.Tag); err != nil {
+			return err
+		}
+		tablePos = c.emit(stmt, parser.OpSwitch, tableIdx, 0)
+	} else {
+		var tagSymbol *SymbolObject
+		if stmt.Tag != nil {
+			tagSymbol = c.symbol.Define(":sw")
+			if err := c.Compile(stmt.Tag); err != nil {
+				return err
+			}
+			if tagSymbol.Scope == ScopeGlobal {
+				c.emit(stmt, parser.OpSetGlobal, tagSymbol.Index)
+			} else {
+				tagSymbol.LocalAssigned = true
+				c.emit(stmt, parser.OpDefineLocal, tagSymbol.Index)
+			}
+		}
+
+		jumps = make([][]int, len(clauses))
+		for i, clause := range clauses {
+			for _, expr := range clause.List {
+				if tagSymbol != nil {
+					// :sw != expr
+					if tagSymbol.Scope == ScopeGlobal {
+						c.emit(expr, parser.OpGetGlobal, tagSymbol.Index)
+					} else {
+						c.emit(expr, parser.OpGetLocal, tagSymbol.Index)
+					}
+					if err := c.Compile(expr); err != nil {
+						return err
+					}
+					c.emit(expr, parser.OpNotEqual)
+				} else {
+					// !expr
+					if err := c.Compile(expr); err != nil {
+						return err
+					}
+					c.emit(expr, parser.OpLNot)
+				}
+				jumps[i] = append(jumps[i],
+					c.emit(expr, parser.OpJumpFalsy, 0))
+			}
+		}
+		defaultJump = c.emit(stmt, parser.OpJump, 0)
 	}
 
-	c.leaveLoop()
+	// enter switch: break statements jump to the end of the switch
+	loop := c.enterLoop()
+	loop.Switch = true
+
+	// case bodies in source order
+	bodyPos := make([]int, len(clauses))
+	for i, clause := range clauses {
+		bodyPos[i] = len(c.currentInstructions())
+
+		body := clause.Body
+		fallThrough := false
+		if n := len(body); n > 0 {
+			last, ok := body[n-1].(*parser.BranchStmt)
+			if ok && last.Token == parser.TokenFallthrough {
+				if i == len(clauses)-1 {
+					c.leaveLoop()
+					return c.errorf(last,
+						"cannot fallthrough final case in switch")
+				}
+				fallThrough = true
+				body = body[:n-1]
+			}
+		}
 
-	// post-body position
-	postBodyPos := len(c.currentInstructions())
+		if err := c.Compile(&parser.BlockStmt{Stmts: body}); err != nil {
+			c.leaveLoop()
+			return err
+		}
+		if !fallThrough && i < len(clauses)-1 {
+			loop.Breaks = append(loop.Breaks, c.emit(clause, parser.OpJump, 0))
+		}
+	}
 
-	// back to condition
-	c.emit(stmt, parser.OpJump, preCondPos)
+	c.leaveLoop()
 
 	// post-statement position
 	postStmtPos := len(c.currentInstructions())
-	c.changeOperand(postCondPos, postStmtPos)
+	defaultPos := postStmtPos
+	if defaultIdx >= 0 {
+		defaultPos = bodyPos[defaultIdx]
+	}
 
-	// update all break/continue jump positions
+	// update jump table or case jump positions
+	if table != nil {
+		for i := range clauses {
+			for _, key := range keys[i] {
+				table.Value[key] = &Int{Value: int64(bodyPos[i])}
+			}
+		}
+		c.changeOperand(tablePos, tableIdx, defaultPos)
+	} else {
+		for i := range clauses {
+			for _, pos := range jumps[i] {
+				c.changeOperand(pos, bodyPos[i])
+			}
+		}
+		c.changeOperand(defaultJump, defaultPos)
+	}
+
+	// update all break jump positions
 	for _, pos := range loop.Breaks {
 		c.changeOperand(pos, postStmtPos)
 	}
-	for _, pos := range loop.Continues {
-		c.changeOperand(pos, postBodyPos)
-	}
 	return nil
 }
 
+// switchKeys returns the jump table keys for the case values of each clause.
+// It returns nil if any of the case values is not a literal constant.
+func (c *Compiler) switchKeys(
+	clauses []*parser.CaseClause,
+) ([][]string, error) {
+	keys := make([][]string, len(clauses))
+	seen := make(map[string]bool)
+	for i, clause := range clauses {
+		for _, expr := range clause.List {
+			key, ok := switchKey(c.constantValue(expr))
+			if !ok {
+				return nil, nil
+			}
+			if seen[key] {
+				return nil, c.errorf(expr, "duplicate case %s in switch",
+					expr.String())
+			}
+			seen[key] = true
+			keys[i] = append(keys[i], key)
+		}
+	}
+	return keys, nil
+}
+
 func (c *Compiler) checkCyclicImports(
 	node parser.Node,
 	modulePath string,
@@ -991,6 +2327,10 @@ func (c *Compiler) compileModule(
 		return nil, err
 	}
 
+	if len(moduleCompiler.exports) > 0 {
+		moduleCompiler.compileConstExports(node)
+	}
+
 	// code optimization
 	moduleCompiler.optimizeFunc(node)
 	compiledFunc := moduleCompiler.Bytecode().MainFunction
@@ -1019,8 +2359,187 @@ func (c *Compiler) storeCompiledModule(
 	c.compiledModules[modulePath] = module
 }
 
+func (c *Compiler) compileTryStmt(stmt *parser.TryStmt) error {
+	// try statement is compiled as following:
+	//
+	//            TRY     catch     ; finally, if there's no catch block
+	//            ... body ...
+	//            ENDTRY
+	//            NULL              ; no pending exception
+	//            JMP     finally   ; end, if there's no finally block
+	//   catch:   DEFL    e         ; caught exception
+	//            TRY     finally   ; only if there's a finally block
+	//            ... catch ...
+	//            ENDTRY
+	//            NULL
+	//   finally: DEFL    :ex       ; pending exception or nil
+	//            ... finally ...
+	//            GETL    :ex
+	//            THROW   1         ; re-throw pending exception
+	//   end:
+	//
+	// break, continue and return statements leaving the try statement
+	// remove the exception handler and run the finally block inline (see
+	// exitTries).
+	tryPos := c.emit(stmt, parser.OpSetupTry, 0)
+	c.enterTry(stmt.Finally)
+	if err := c.Compile(stmt.Body); err != nil {
+		return err
+	}
+	c.leaveTry()
+	c.emit(stmt, parser.OpPopTry)
+	if stmt.Finally != nil {
+		c.emit(stmt, parser.OpNull)
+	}
+	jumpPos := c.emit(stmt, parser.OpJump, 0)
+
+	if stmt.Catch != nil {
+		c.changeOperand(tryPos, len(c.currentInstructions()))
+		var err error
+		tryPos, err = c.compileCatch(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	if stmt.Finally == nil {
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		return nil
+	}
+	c.changeOperand(tryPos, len(c.currentInstructions()))
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	// ":ex" is a local variable but it will not conflict with other user
+	// variables because character ":" is not allowed in the variable names.
+	c.symbol = c.symbol.Fork(true)
+	defer func() {
+		c.symbol = c.symbol.Parent(false)
+	}()
+	exSymbol := c.symbol.Define(":ex")
+	if exSymbol.Scope == ScopeGlobal {
+		c.emit(stmt, parser.OpSetGlobal, exSymbol.Index)
+	} else {
+		exSymbol.LocalAssigned = true
+		c.emit(stmt, parser.OpDefineLocal, exSymbol.Index)
+	}
+	if err := c.Compile(stmt.Finally); err != nil {
+		return err
+	}
+	if exSymbol.Scope == ScopeGlobal {
+		c.emit(stmt, parser.OpGetGlobal, exSymbol.Index)
+	} else {
+		c.emit(stmt, parser.OpGetLocal, exSymbol.Index)
+	}
+	c.emit(stmt, parser.OpThrow, 1)
+	return nil
+}
+
+// compileCatch compiles the catch clause of the try statement. If there's a
+// finally block, the catch block is guarded by another exception handler, and
+// the position of its TRY instruction is returned.
+func (c *Compiler) compileCatch(stmt *parser.TryStmt) (int, error) {
+	c.symbol = c.symbol.Fork(true)
+	defer func() {
+		c.symbol = c.symbol.Parent(false)
+	}()
+
+	// the caught exception is on the stack
+	if ident := stmt.CatchIdent; ident == nil || ident.Name == "_" {
+		c.emit(stmt, parser.OpPop)
+	} else {
+		symbol := c.symbol.Define(ident.Name)
+		if symbol.Scope == ScopeGlobal {
+			c.emit(ident, parser.OpSetGlobal, symbol.Index)
+		} else {
+			symbol.LocalAssigned = true
+			c.emit(ident, parser.OpDefineLocal, symbol.Index)
+		}
+	}
+
+	if stmt.Finally == nil {
+		return 0, c.Compile(stmt.Catch)
+	}
+	tryPos := c.emit(stmt, parser.OpSetupTry, 0)
+	c.enterTry(stmt.Finally)
+	if err := c.Compile(stmt.Catch); err != nil {
+		return 0, err
+	}
+	c.leaveTry()
+	c.emit(stmt, parser.OpPopTry)
+	c.emit(stmt, parser.OpNull)
+	return tryPos, nil
+}
+
+// exitTries emits the instructions leaving the enclosing try statements of the
+// current function down to the given depth: exception handlers are removed
+// and finally blocks are run, innermost first.
+func (c *Compiler) exitTries(node parser.Node, depth int) error {
+	tries := c.scopes[c.scopeIndex].Tries
+	defer func() {
+		c.scopes[c.scopeIndex].Tries = tries
+	}()
+	for i := len(tries) - 1; i >= depth; i-- {
+		c.emit(node, parser.OpPopTry)
+		if tries[i] != nil {
+			// finally block itself is not guarded by its try statement
+			c.scopes[c.scopeIndex].Tries = tries[:i:i]
+			if err := c.Compile(tries[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exitLoops emits the instructions closing the iterators of the for-in loops
+// of the current function exited by a branch to the given loop, or by a
+// return if the loop is nil, so that the generators left unfinished run their
+// deferred calls.
+func (c *Compiler) exitLoops(node parser.Node, target *loop, exitTarget bool) {
+	for i := c.loopIndex; i >= 0 && c.loops[i].Scope == c.scopeIndex; i-- {
+		l := c.loops[i]
+		if l == target && !exitTarget {
+			return
+		}
+		if it := l.Iterator; it != nil {
+			if it.Scope == ScopeGlobal {
+				c.emit(node, parser.OpGetGlobal, it.Index)
+			} else {
+				c.emit(node, parser.OpGetLocal, it.Index)
+			}
+			c.emit(node, parser.OpIteratorClose)
+		}
+		if l == target {
+			return
+		}
+	}
+}
+
+// inForIn returns true if the current function is in a for-in loop.
+func (c *Compiler) inForIn() bool {
+	for i := c.loopIndex; i >= 0 && c.loops[i].Scope == c.scopeIndex; i-- {
+		if c.loops[i].Iterator != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Compiler) enterTry(finally *parser.BlockStmt) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.Tries = append(scope.Tries, finally)
+}
+
+func (c *Compiler) leaveTry() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.Tries = scope.Tries[:len(scope.Tries)-1]
+}
+
 func (c *Compiler) enterLoop() *loop {
-	loop := &loop{}
+	loop := &loop{
+		Tries: len(c.scopes[c.scopeIndex].Tries),
+		Scope: c.scopeIndex,
+	}
 	c.loops = append(c.loops, loop)
 	c.loopIndex++
 	if c.trace != nil {
@@ -1037,9 +2556,24 @@ func (c *Compiler) leaveLoop() {
 	c.loopIndex--
 }
 
-func (c *Compiler) currentLoop() *loop {
-	if c.loopIndex >= 0 {
-		return c.loops[c.loopIndex]
+// currentLoop returns the innermost loop of the current function, or nil if
+// there is none.
+func (c *Compiler) currentLoop(skipSwitch bool) *loop {
+	for i := c.loopIndex; i >= 0 && c.loops[i].Scope == c.scopeIndex; i-- {
+		if !skipSwitch || !c.loops[i].Switch {
+			return c.loops[i]
+		}
+	}
+	return nil
+}
+
+// labeledLoop returns the enclosing loop of the current function with the
+// given label, or nil if there is none.
+func (c *Compiler) labeledLoop(label string) *loop {
+	for i := c.loopIndex; i >= 0 && c.loops[i].Scope == c.scopeIndex; i-- {
+		if c.loops[i].Label == label {
+			return c.loops[i]
+		}
 	}
 	return nil
 }
@@ -1090,6 +2624,7 @@ func (c *Compiler) fork(
 	child.modulePath = modulePath // module file path
 	child.parent = c              // parent to set to current compiler
 	child.allowFileImport = c.allowFileImport
+	child.checked = c.checked
 	child.importDir = c.importDir
 	if isFile && c.importDir != "" {
 		child.importDir = filepath.Dir(modulePath)
@@ -1129,6 +2664,13 @@ func (c *Compiler) addConstant(o Object) int {
 	return len(c.constants) - 1
 }
 
+func (c *Compiler) constant(idx int) Object {
+	if c.parent != nil {
+		return c.parent.constant(idx)
+	}
+	return c.constants[idx]
+}
+
 func (c *Compiler) addInstruction(b []byte) int {
 	posNewIns := len(c.currentInstructions())
 	c.scopes[c.scopeIndex].Instructions = append(
@@ -1165,8 +2707,15 @@ func (c *Compiler) optimizeFunc(node parser.Node) {
 		func(pos int, opcode parser.Opcode, operands []int) bool {
 			switch opcode {
 			case parser.OpJump, parser.OpJumpFalsy,
-				parser.OpAndJump, parser.OpOrJump:
+				parser.OpAndJump, parser.OpOrJump, parser.OpSetupTry,
+				parser.OpJumpNotError, parser.OpNilJump,
+				parser.OpCoalesceJump, parser.OpArgJump:
 				dsts[operands[0]] = true
+			case parser.OpSwitch:
+				dsts[operands[1]] = true
+				for _, dst := range c.constant(operands[0]).(*Map).Value {
+					dsts[int(dst.(*Int).Value)] = true
+				}
 			}
 			return true
 		})
@@ -1201,24 +2750,35 @@ func (c *Compiler) optimizeFunc(node parser.Node) {
 	var appendReturn bool
 	endPos := len(c.scopes[c.scopeIndex].Instructions)
 	newEndPost := len(newInsts)
+	newJumpDst := func(dst int) int {
+		if newDst, ok := posMap[dst]; ok {
+			return newDst
+		} else if endPos == dst {
+			// there's a jump instruction that jumps to the end of
+			// function compiler should append "return".
+			appendReturn = true
+			return newEndPost
+		}
+		panic(fmt.Errorf("invalid jump position: %d", dst))
+	}
 	iterateInstructions(newInsts,
 		func(pos int, opcode parser.Opcode, operands []int) bool {
 			switch opcode {
 			case parser.OpJump, parser.OpJumpFalsy, parser.OpAndJump,
-				parser.OpOrJump:
-				newDst, ok := posMap[operands[0]]
-				if ok {
-					copy(newInsts[pos:],
-						MakeInstruction(opcode, newDst))
-				} else if endPos == operands[0] {
-					// there's a jump instruction that jumps to the end of
-					// function compiler should append "return".
-					copy(newInsts[pos:],
-						MakeInstruction(opcode, newEndPost))
-					appendReturn = true
-				} else {
-					panic(fmt.Errorf("invalid jump position: %d", newDst))
+				parser.OpOrJump, parser.OpSetupTry,
+				parser.OpJumpNotError, parser.OpNilJump,
+				parser.OpCoalesceJump, parser.OpArgJump:
+				copy(newInsts[pos:],
+					MakeInstruction(opcode, newJumpDst(operands[0])))
+			case parser.OpSwitch:
+				table := c.constant(operands[0]).(*Map)
+				for key, dst := range table.Value {
+					table.Value[key] = &Int{
+						Value: int64(newJumpDst(int(dst.(*Int).Value))),
+					}
 				}
+				copy(newInsts[pos:], MakeInstruction(opcode, operands[0],
+					newJumpDst(operands[1])))
 			}
 			lastOp = opcode
 			return true
@@ -1244,6 +2804,33 @@ func (c *Compiler) optimizeFunc(node parser.Node) {
 	}
 }
 
+// emitBinaryOp emits the binary operation, checking int overflow in checked
+// arithmetic mode if the operator is +, -, * or <<.
+func (c *Compiler) emitBinaryOp(node parser.Node, tok parser.Token) {
+	if c.checked {
+		switch tok {
+		case parser.TokenAdd, parser.TokenSub, parser.TokenMul,
+			parser.TokenShl:
+			c.emit(node, parser.OpCheckedBinOp, int(tok))
+			return
+		}
+	}
+	c.emit(node, parser.OpBinaryOp, int(tok))
+}
+
+// emitConstant emits instructions that push the compile-time value on the
+// stack.
+func (c *Compiler) emitConstant(node parser.Node, value Object) {
+	switch value {
+	case TrueValue:
+		c.emit(node, parser.OpTrue)
+	case FalseValue:
+		c.emit(node, parser.OpFalse)
+	default:
+		c.emit(node, parser.OpConstant, c.addConstant(value))
+	}
+}
+
 func (c *Compiler) emit(
 	node parser.Node,
 	opcode parser.Opcode,
@@ -1280,6 +2867,22 @@ func (c *Compiler) printTrace(a ...interface{}) {
 	_, _ = fmt.Fprintln(c.trace, a...)
 }
 
+// isSpread returns true if the expression is a spread element.
+func isSpread(expr parser.Expr) bool {
+	_, ok := expr.(*parser.SpreadExpr)
+	return ok
+}
+
+// isPattern returns true if the expression is an array or map pattern on the
+// left-hand side of an assignment.
+func isPattern(expr parser.Expr) bool {
+	switch expr.(type) {
+	case *parser.ArrayLit, *parser.MapLit:
+		return true
+	}
+	return false
+}
+
 func resolveAssignLHS(
 	expr parser.Expr,
 ) (name string, selectors []parser.Expr) {
@@ -1297,6 +2900,120 @@ func resolveAssignLHS(
 	return
 }
 
+// constantValue returns the compile-time value of a constant expression, or
+// nil if the expression cannot be evaluated at compile time. Literals,
+// constants with a known value, and unary and binary operations on them are
+// folded.
+func (c *Compiler) constantValue(expr parser.Expr) Object {
+	switch expr := expr.(type) {
+	case *parser.IntLit:
+		return &Int{Value: expr.Value}
+	case *parser.FloatLit:
+		return &Float{Value: expr.Value}
+	case *parser.StringLit:
+		if len(expr.Value) > MaxStringLen {
+			return nil
+		}
+		return &String{Value: expr.Value}
+	case *parser.CharLit:
+		return &Char{Value: expr.Value}
+	case *parser.BoolLit:
+		if expr.Value {
+			return TrueValue
+		}
+		return FalseValue
+	case *parser.ParenExpr:
+		return c.constantValue(expr.Expr)
+	case *parser.Ident:
+		// not resolved, which would capture the name in a closure even if
+		// the value is folded
+		symbol, ok := c.symbol.lookup(expr.Name, false)
+		if ok {
+			return symbol.Value
+		}
+	case *parser.UnaryExpr:
+		x := c.constantValue(expr.Expr)
+		if x == nil {
+			return nil
+		}
+		switch expr.Token {
+		case parser.TokenNot:
+			if x.IsFalsy() {
+				return TrueValue
+			}
+			return FalseValue
+		case parser.TokenSub:
+			switch x := x.(type) {
+			case *Int:
+				// leave overflow to the runtime
+				if c.checked && x.Value == math.MinInt64 {
+					return nil
+				}
+				return &Int{Value: -x.Value}
+			case *Float:
+				return &Float{Value: -x.Value}
+			}
+		case parser.TokenXor:
+			if x, ok := x.(*Int); ok {
+				return &Int{Value: ^x.Value}
+			}
+		case parser.TokenAdd:
+			return x
+		}
+	case *parser.BinaryExpr:
+		switch expr.Token {
+		case parser.TokenLAnd, parser.TokenLOr, parser.TokenCoalesce:
+			return nil
+		}
+		lhs := c.constantValue(expr.LHS)
+		if lhs == nil {
+			return nil
+		}
+		rhs := c.constantValue(expr.RHS)
+		if rhs == nil {
+			return nil
+		}
+
+		op := expr.Token
+		switch op {
+		case parser.TokenEqual, parser.TokenNotEqual:
+			if lhs.Equals(rhs) == (op == parser.TokenEqual) {
+				return TrueValue
+			}
+			return FalseValue
+		case parser.TokenLess:
+			lhs, rhs, op = rhs, lhs, parser.TokenGreater
+		case parser.TokenLessEq:
+			lhs, rhs, op = rhs, lhs, parser.TokenGreaterEq
+		case parser.TokenQuo, parser.TokenRem:
+			// leave division by zero to the runtime
+			if rhs, ok := rhs.(*Int); ok && rhs.Value == 0 {
+				return nil
+			}
+		}
+		res, err := lhs.BinaryOp(op, rhs)
+		if err != nil {
+			return nil
+		}
+		if _, ok := res.(*Range); ok {
+			// ranges are not shared as their elements can be set
+			return nil
+		}
+		if c.checked {
+			// leave overflow to the runtime
+			x, _ := lhs.(*Int)
+			y, _ := rhs.(*Int)
+			r, _ := res.(*Int)
+			if x != nil && y != nil && r != nil &&
+				intOverflows(op, x.Value, y.Value, r.Value) {
+				return nil
+			}
+		}
+		return res
+	}
+	return nil
+}
+
 func iterateInstructions(
 	b []byte,
 	fn func(pos int, opcode parser.Opcode, operands []int) bool,
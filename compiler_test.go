@@ -0,0 +1,41 @@
+package gslang_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gslang/gslang"
+	"github.com/gslang/gslang/parser"
+)
+
+// compile compiles the input and returns the formatted constants and
+// instructions of the bytecode.
+func compile(t *testing.T, input string) string {
+	t.Helper()
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile("(main)", -1, len(input))
+	p := parser.NewParser(srcFile, []byte(input), nil)
+	file, err := p.ParseFile()
+	if err != nil {
+		t.Fatalf("unexpected error\n\tinput: %s\n\terror: %s", input, err)
+	}
+	c := gslang.NewCompiler(srcFile, nil, nil, nil, nil)
+	if err := c.Compile(file); err != nil {
+		t.Fatalf("unexpected error\n\tinput: %s\n\terror: %s", input, err)
+	}
+	b := c.Bytecode()
+	return strings.Join(append(b.FormatConstants(),
+		b.FormatInstructions()...), "\n")
+}
+
+func TestConstantCaptures(t *testing.T) {
+	for _, input := range []string{
+		`f := func() { const k = 2; return func() { return k * 3 } }`,
+		`f := func() { const k = true; return func() { if k { return 1 } } }`,
+	} {
+		if out := compile(t, input); strings.Contains(out, "CLOSURE") {
+			t.Errorf("folded constant captured\n\tinput: %s\n\tbytecode:\n%s",
+				input, out)
+		}
+	}
+}
@@ -39,12 +39,38 @@ var (
 	// exceeds the limit.
 	ErrStringLimit = errors.New("exceeding string size limit")
 
+	// ErrBigIntLimit represents an error where the size of bigint or decimal
+	// value exceeds the limit.
+	ErrBigIntLimit = errors.New("exceeding bigint size limit")
+
 	// ErrNotIndexable is an error where an Object is not indexable.
 	ErrNotIndexable = errors.New("not indexable")
 
 	// ErrNotIndexAssignable is an error where an Object is not index
 	// assignable.
 	ErrNotIndexAssignable = errors.New("not index-assignable")
+
+	// ErrImmutable is an error where an immutable Object is modified.
+	ErrImmutable = errors.New("cannot modify immutable value")
+
+	// ErrGeneratorRunning is an error where a generator is resumed while it
+	// is already running.
+	ErrGeneratorRunning = errors.New("generator already running")
+
+	// ErrIntOverflow is an error where an int operation overflows in checked
+	// arithmetic mode.
+	ErrIntOverflow = errors.New("integer overflow")
+
+	// ErrDivisionByZero is an error where a number is divided by zero.
+	ErrDivisionByZero = errors.New("division by zero")
+
+	// ErrChannelClosed is an error where a closed channel is sent to or
+	// closed.
+	ErrChannelClosed = errors.New("channel closed")
+
+	// ErrNotShareable is an error where a generator or an iterator is passed
+	// to a spawned routine.
+	ErrNotShareable = errors.New("cannot share generator or iterator with routine")
 	
 	// ErrInvalidRangeStep is an error where the step parameter is less than or equal to 0 when using builtin range function.
 	ErrInvalidRangeStep = errors.New("range step must be greater than 0")
@@ -1,6 +1,7 @@
 package gslang
 
 import (
+	"math/big"
 	"strconv"
 	"sync"
 	"unicode/utf8"
@@ -871,6 +872,43 @@ func (p *pp) fmtFloat(v float64, size int, verb rune) {
 	}
 }
 
+// fmtBigInt formats an arbitrary-precision integer. Integer verbs are handled
+// by big.Int, and floating-point verbs by big.Float.
+func (p *pp) fmtBigInt(v *big.Int, verb rune) {
+	switch verb {
+	case 'd', 'b', 'o', 'O', 'x', 'X':
+		v.Format(p, verb)
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		new(big.Float).SetInt(v).Format(p, verb)
+	case 's':
+		p.fmtString(v.String(), verb)
+	default:
+		p.badVerb(verb)
+	}
+}
+
+// fmtDecimal formats a decimal. It's converted to a big.Float precise enough
+// to keep all its digits, after rounding to the precision for %f, and %f
+// without a precision prints all of its fractional digits.
+func (p *pp) fmtDecimal(v *Decimal, verb rune) {
+	switch verb {
+	case 'f', 'F':
+		if p.fmt.precPresent {
+			v = v.Round(p.fmt.prec)
+		} else {
+			p.fmt.prec, p.fmt.precPresent = v.Scale, true
+		}
+		fallthrough
+	case 'e', 'E', 'g', 'G':
+		prec := uint(v.Value.BitLen()) + 64
+		new(big.Float).SetPrec(prec).SetRat(v.Rat()).Format(p, verb)
+	case 's':
+		p.fmtString(v.String(), verb)
+	default:
+		p.badVerb(verb)
+	}
+}
+
 func (p *pp) fmtString(v string, verb rune) {
 	switch verb {
 	case 'v':
@@ -957,6 +995,10 @@ func (p *pp) printArg(arg Object, verb rune) {
 		p.fmtFloat(f.Value, 64, verb)
 	case *Int:
 		p.fmtInteger(uint64(f.Value), signed, verb)
+	case *BigInt:
+		p.fmtBigInt(f.Value, verb)
+	case *Decimal:
+		p.fmtDecimal(f, verb)
 	case *String:
 		p.fmtString(f.Value, verb)
 	case *Bytes:
@@ -3,6 +3,7 @@ package gslang
 import (
 	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
 	"time"
 )
@@ -15,6 +16,11 @@ var (
 	// MaxBytesLen is the maximum length for bytes value. Note this limit
 	// applies to all compiler/VM instances in the process.
 	MaxBytesLen = 2147483647
+
+	// MaxBigIntBits is the maximum bit length for bigint value and for the
+	// unscaled value of decimal value. Note this limit applies to all
+	// compiler/VM instances in the process.
+	MaxBigIntBits = 1 << 24
 )
 
 const (
@@ -31,6 +37,13 @@ const (
 // CallableFunc is a function signature for the callable functions.
 type CallableFunc = func(args ...Object) (ret Object, err error)
 
+// CallableKwFunc is a function signature for the callable functions that
+// accept named arguments. kwargs is nil if there are no named arguments.
+type CallableKwFunc = func(
+	kwargs map[string]Object,
+	args ...Object,
+) (ret Object, err error)
+
 // CountObjects returns the number of objects that a given object o contains.
 // For scalar value types, it will always be 1. For compound value types,
 // this will include its elements and all of their elements recursively.
@@ -47,10 +60,47 @@ func CountObjects(o Object) (c int) {
 		}
 	case *Error:
 		c += CountObjects(o.Value)
+	case *Exception:
+		c += CountObjects(o.Value)
 	}
 	return
 }
 
+// Freeze returns an immutable copy of object o. The arrays and maps reachable
+// from o are copied as immutable, and o itself is not changed. The other
+// values are shared with o.
+func Freeze(o Object) Object {
+	return freeze(o, make(map[Object]Object))
+}
+
+func freeze(o Object, copies map[Object]Object) Object {
+	if c, ok := copies[o]; ok {
+		return c
+	}
+	switch o := o.(type) {
+	case *Range:
+		return freeze(&Array{Value: o.appendTo(nil)}, copies)
+	case *Array:
+		res := &Array{Value: make([]Object, len(o.Value)), Immutable: true}
+		copies[o] = res
+		for i, v := range o.Value {
+			res.Value[i] = freeze(v, copies)
+		}
+		return res
+	case *Map:
+		res := &Map{
+			Value:     make(map[string]Object, len(o.Value)),
+			Immutable: true,
+		}
+		copies[o] = res
+		for k, v := range o.Value {
+			res.Value[k] = freeze(v, copies)
+		}
+		return res
+	}
+	return o
+}
+
 // ToString will try to convert object o to string value.
 func ToString(o Object) (v string, ok bool) {
 	if o == NilValue {
@@ -74,6 +124,16 @@ func ToInt(o Object) (v int, ok bool) {
 	case *Float:
 		v = int(o.Value)
 		ok = true
+	case *BigInt:
+		if o.Value.IsInt64() {
+			v = int(o.Value.Int64())
+			ok = true
+		}
+	case *Decimal:
+		if i := o.Int(); i.IsInt64() {
+			v = int(i.Int64())
+			ok = true
+		}
 	case *Char:
 		v = int(o.Value)
 		ok = true
@@ -101,6 +161,16 @@ func ToInt64(o Object) (v int64, ok bool) {
 	case *Float:
 		v = int64(o.Value)
 		ok = true
+	case *BigInt:
+		if o.Value.IsInt64() {
+			v = o.Value.Int64()
+			ok = true
+		}
+	case *Decimal:
+		if i := o.Int(); i.IsInt64() {
+			v = i.Int64()
+			ok = true
+		}
 	case *Char:
 		v = int64(o.Value)
 		ok = true
@@ -128,6 +198,12 @@ func ToFloat64(o Object) (v float64, ok bool) {
 	case *Float:
 		v = o.Value
 		ok = true
+	case *BigInt:
+		v = bigIntFloat(o.Value)
+		ok = true
+	case *Decimal:
+		v, _ = o.Rat().Float64()
+		ok = true
 	case *String:
 		c, err := strconv.ParseFloat(o.Value, 64)
 		if err == nil {
@@ -171,6 +247,20 @@ func ToByteSlice(o Object) (v []byte, ok bool) {
 	return
 }
 
+// ToArray will try to convert object o to []Object value. The elements of a
+// range are returned without materializing it.
+func ToArray(o Object) (v []Object, ok bool) {
+	switch o := o.(type) {
+	case *Array:
+		v = o.Value
+		ok = true
+	case *Range:
+		v = o.appendTo(nil)
+		ok = true
+	}
+	return
+}
+
 // ToTime will try to convert object o to time.Time value.
 func ToTime(o Object) (v time.Time, ok bool) {
 	switch o := o.(type) {
@@ -193,6 +283,10 @@ func ToInterface(o Object) (res interface{}) {
 		res = o.Value
 	case *Float:
 		res = o.Value
+	case *BigInt:
+		res = new(big.Int).Set(o.Value)
+	case *Decimal:
+		res = o.Rat()
 	case *Bool:
 		res = o == TrueValue
 	case *Char:
@@ -204,6 +298,14 @@ func ToInterface(o Object) (res interface{}) {
 		for i, val := range o.Value {
 			res.([]interface{})[i] = ToInterface(val)
 		}
+	case *Range:
+		if o.arr != nil {
+			return ToInterface(o.arr)
+		}
+		res = make([]interface{}, o.Len)
+		for i := int64(0); i < o.Len; i++ {
+			res.([]interface{})[i] = o.Start + i*o.Step
+		}
 	case *Map:
 		res = make(map[string]interface{})
 		for key, v := range o.Value {
@@ -246,6 +348,17 @@ func FromInterface(v interface{}) (Object, error) {
 		return &Char{Value: rune(v)}, nil
 	case float64:
 		return &Float{Value: v}, nil
+	case *big.Int:
+		return newBigInt(new(big.Int).Set(v))
+	case *big.Rat:
+		num := &Decimal{Value: new(big.Int).Set(v.Num())}
+		q := num.quo(&Decimal{Value: new(big.Int).Set(v.Denom())})
+		return newDecimal(q.Value, q.Scale)
+	case *big.Float:
+		if v.IsInf() {
+			return nil, fmt.Errorf("cannot convert to decimal: %s", v)
+		}
+		return ParseDecimal(v.Text('g', -1))
 	case []byte:
 		if len(v) > MaxBytesLen {
 			return nil, ErrBytesLimit
@@ -283,6 +396,8 @@ func FromInterface(v interface{}) (Object, error) {
 		return v, nil
 	case CallableFunc:
 		return &UserFunction{Value: v}, nil
+	case CallableKwFunc:
+		return &UserFunction{KwValue: v}, nil
 	}
 	return nil, fmt.Errorf("cannot convert to object: %T", v)
 }
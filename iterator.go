@@ -1,5 +1,12 @@
 package gslang
 
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/gslang/gslang/parser"
+)
+
 // Iterator represents an iterator for underlying data type.
 type Iterator interface {
 	Object
@@ -107,6 +114,149 @@ func (i *BytesIterator) Value() Object {
 	return &Int{Value: int64(i.v[i.i-1])}
 }
 
+// Generator represents an iterator returned by a call to a generator
+// function, a function containing yield statements. Each iteration resumes the
+// function until it yields the next element or returns. A for-in loop left
+// early by break or return finishes the generator and runs its pending
+// deferred calls; a generator abandoned otherwise never runs them.
+type Generator struct {
+	ObjectImpl
+	vm       *VM // VM the generator was created in
+	fn       *CompiledFunction
+	ip       int
+	stack    []Object    // locals and operands of the suspended frame
+	defers   []*deferred // pending deferred calls of the suspended frame
+	handlers []handler   // exception handlers, sp relative to the frame
+	running  bool
+	done     bool
+	index    int64
+	key      Object
+	value    Object
+	driver   *VM // VM used to resume the generator from Go
+	err      error
+}
+
+func newGenerator(v *VM, fn *CompiledFunction, args []Object) *Generator {
+	stack := make([]Object, fn.NumLocals)
+	copy(stack, args)
+	return &Generator{vm: v, fn: fn, stack: stack, ip: -1}
+}
+
+// TypeName returns the name of the type.
+func (g *Generator) TypeName() string {
+	return "generator"
+}
+
+func (g *Generator) String() string {
+	return "<generator>"
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (g *Generator) Equals(x Object) bool {
+	return g == x
+}
+
+// Copy returns a copy of the type. A generator cannot be copied, so it
+// returns the generator itself.
+func (g *Generator) Copy() Object {
+	return g
+}
+
+// CanIterate returns whether the Object can be Iterated.
+func (g *Generator) CanIterate() bool {
+	return true
+}
+
+// Iterate returns the generator itself.
+func (g *Generator) Iterate() Iterator {
+	return g
+}
+
+// Next resumes the generator and returns true if it yielded an element. It is
+// used to drive the generator from Go; a runtime error in the generator stops
+// the iteration and is reported by Err. The generator shares the allocation
+// limit of the VM it was created in, and is stopped when that VM is aborted.
+func (g *Generator) Next() bool {
+	if g.done || g.running {
+		return false
+	}
+	v := g.driver
+	if v == nil {
+		v = &VM{
+			constants: g.vm.constants,
+			globals:   g.vm.globals,
+			fileSet:   g.vm.fileSet,
+			maxAllocs: g.vm.maxAllocs,
+			allocs:    g.vm.allocs,
+		}
+		v.frames[0].fn = generatorDriver
+		g.driver = v
+	}
+	v.curFrame = &v.frames[0]
+	v.curInsts = v.curFrame.fn.Instructions
+	v.framesIndex = 1
+	v.ip = -1
+	v.stack[0] = g
+	v.sp = 1
+
+	// the driver is aborted with the VM the generator was created in
+	g.vm.addChild(v)
+	defer g.vm.removeChild(v)
+	v.run()
+	for v.err != nil && v.throw() {
+		v.run()
+	}
+	if atomic.LoadInt64(&v.aborting) != 0 {
+		v.unwind(1)
+		v.err = nil
+		g.finish()
+		return false
+	}
+	if v.err != nil {
+		g.err = v.runtimeError()
+		v.unwind(1)
+		v.err = nil
+		return false
+	}
+	return v.stack[0] == TrueValue
+}
+
+// Key returns the key of the current element: the key given to yield, or the
+// index of the element.
+func (g *Generator) Key() Object {
+	if g.key != nil {
+		return g.key
+	}
+	return &Int{Value: g.index - 1}
+}
+
+// Value returns the value of the current element.
+func (g *Generator) Value() Object {
+	return g.value
+}
+
+// Err returns the runtime error that stopped the generator, if any. Errors
+// are only recorded when the generator is driven from Go.
+func (g *Generator) Err() error {
+	return g.err
+}
+
+func (g *Generator) finish() {
+	g.done = true
+	g.running = false
+	g.stack = nil
+	g.defers = nil
+	g.handlers = nil
+}
+
+// generatorDriver resumes the generator on top of the stack.
+var generatorDriver = &CompiledFunction{
+	Instructions: append(
+		MakeInstruction(parser.OpIteratorNext),
+		MakeInstruction(parser.OpSuspend)...),
+}
+
 // MapIterator represents an iterator for the map.
 type MapIterator struct {
 	ObjectImpl
@@ -159,6 +309,131 @@ func (i *MapIterator) Value() Object {
 	return i.v[k]
 }
 
+// RangeIterator is an iterator for a range.
+type RangeIterator struct {
+	ObjectImpl
+	r *Range
+	i int64
+}
+
+// TypeName returns the name of the type.
+func (i *RangeIterator) TypeName() string {
+	return "range-iterator"
+}
+
+func (i *RangeIterator) String() string {
+	return "<range-iterator>"
+}
+
+// IsFalsy returns true if the value of the type is falsy.
+func (i *RangeIterator) IsFalsy() bool {
+	return true
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (i *RangeIterator) Equals(Object) bool {
+	return false
+}
+
+// Copy returns a copy of the type.
+func (i *RangeIterator) Copy() Object {
+	return &RangeIterator{r: i.r, i: i.i}
+}
+
+// Next returns true if there are more elements to iterate.
+func (i *RangeIterator) Next() bool {
+	i.i++
+	return i.i <= i.r.Len
+}
+
+// Key returns the key or index value of the current element.
+func (i *RangeIterator) Key() Object {
+	return &Int{Value: i.i - 1}
+}
+
+// Value returns the value of the current element.
+func (i *RangeIterator) Value() Object {
+	return &Int{Value: i.r.Start + (i.i-1)*i.r.Step}
+}
+
+// ScriptIterator represents an iterator defined by the script: a map with a
+// callable __next__ that returns a map with the "done", "value" and optional
+// "key" of the next element. It runs on the VM that created it.
+type ScriptIterator struct {
+	ObjectImpl
+	vm    *VM
+	next  Object
+	i     int64
+	key   Object
+	value Object
+}
+
+// TypeName returns the name of the type.
+func (i *ScriptIterator) TypeName() string {
+	return "script-iterator"
+}
+
+func (i *ScriptIterator) String() string {
+	return "<script-iterator>"
+}
+
+// IsFalsy returns true if the value of the type is falsy.
+func (i *ScriptIterator) IsFalsy() bool {
+	return true
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (i *ScriptIterator) Equals(Object) bool {
+	return false
+}
+
+// Copy returns a copy of the type.
+func (i *ScriptIterator) Copy() Object {
+	return &ScriptIterator{vm: i.vm, next: i.next, i: i.i, key: i.key,
+		value: i.value}
+}
+
+// Next returns true if there are more elements to iterate. Errors from the
+// __next__ function are set on the VM.
+func (i *ScriptIterator) Next() bool {
+	v := i.vm
+	res := v.callObject(i.next)
+	if v.err != nil {
+		return false
+	}
+	m, ok := res.(*Map)
+	if !ok {
+		v.err = fmt.Errorf("invalid iterator result: expected map, found %s",
+			res.TypeName())
+		return false
+	}
+	if done, ok := m.Value["done"]; ok && !done.IsFalsy() {
+		return false
+	}
+	i.key = m.Value["key"]
+	i.value = m.Value["value"]
+	if i.value == nil {
+		i.value = NilValue
+	}
+	i.i++
+	return true
+}
+
+// Key returns the key or index value of the current element.
+func (i *ScriptIterator) Key() Object {
+	if i.key != nil {
+		return i.key
+	}
+	return &Int{Value: i.i - 1}
+}
+
+// Value returns the value of the current element.
+func (i *ScriptIterator) Value() Object {
+	return i.value
+}
+
 // StringIterator represents an iterator for a string.
 type StringIterator struct {
 	ObjectImpl
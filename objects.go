@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"math/big"
 	"strconv"
 	"strings"
 	"time"
@@ -150,7 +151,8 @@ func (o *ObjectImpl) CanCall() bool {
 // Array represents an array of objects.
 type Array struct {
 	ObjectImpl
-	Value []Object
+	Value     []Object
+	Immutable bool
 }
 
 // TypeName returns the name of the type.
@@ -169,6 +171,9 @@ func (o *Array) String() string {
 // BinaryOp returns another object that is the result of a given binary
 // operator and a right-hand side object.
 func (o *Array) BinaryOp(op parser.Token, rhs Object) (Object, error) {
+	if r, ok := rhs.(*Range); ok {
+		rhs = &Array{Value: r.appendTo(nil)}
+	}
 	if rhs, ok := rhs.(*Array); ok {
 		switch op {
 		case parser.TokenAdd:
@@ -181,7 +186,7 @@ func (o *Array) BinaryOp(op parser.Token, rhs Object) (Object, error) {
 	return nil, ErrInvalidOperator
 }
 
-// Copy returns a copy of the type.
+// Copy returns a copy of the type. The copy of an immutable array is mutable.
 func (o *Array) Copy() Object {
 	var c []Object
 	for _, elem := range o.Value {
@@ -202,6 +207,8 @@ func (o *Array) Equals(x Object) bool {
 	switch x := x.(type) {
 	case *Array:
 		xVal = x.Value
+	case *Range:
+		return x.Equals(o)
 	default:
 		return false
 	}
@@ -234,6 +241,10 @@ func (o *Array) IndexGet(index Object) (res Object, err error) {
 
 // IndexSet sets an element at a given index.
 func (o *Array) IndexSet(index, value Object) (err error) {
+	if o.Immutable {
+		err = ErrImmutable
+		return
+	}
 	intIdx, ok := ToInt(index)
 	if !ok {
 		err = ErrInvalidIndexType
@@ -260,6 +271,120 @@ func (o *Array) CanIterate() bool {
 	return true
 }
 
+// BigInt represents an arbitrary-precision integer value.
+type BigInt struct {
+	ObjectImpl
+	Value *big.Int
+}
+
+func (o *BigInt) String() string {
+	return o.Value.String()
+}
+
+// TypeName returns the name of the type.
+func (o *BigInt) TypeName() string {
+	return "bigint"
+}
+
+// BinaryOp returns another object that is the result of a given binary
+// operator and a right-hand side object. The result is a BigInt with an Int
+// or BigInt operand, a Float with a Float operand, or a Decimal with a
+// Decimal operand.
+func (o *BigInt) BinaryOp(op parser.Token, rhs Object) (Object, error) {
+	var y *big.Int
+	switch rhs := rhs.(type) {
+	case *Int:
+		y = big.NewInt(rhs.Value)
+	case *BigInt:
+		y = rhs.Value
+	case *Float:
+		return (&Float{Value: bigIntFloat(o.Value)}).BinaryOp(op, rhs)
+	case *Decimal:
+		return (&Decimal{Value: o.Value}).BinaryOp(op, rhs)
+	default:
+		return nil, ErrInvalidOperator
+	}
+
+	x := o.Value
+	switch op {
+	case parser.TokenAdd:
+		return newBigInt(new(big.Int).Add(x, y))
+	case parser.TokenSub:
+		return newBigInt(new(big.Int).Sub(x, y))
+	case parser.TokenMul:
+		if x.BitLen()+y.BitLen() > MaxBigIntBits+1 {
+			return nil, ErrBigIntLimit
+		}
+		return newBigInt(new(big.Int).Mul(x, y))
+	case parser.TokenQuo:
+		if y.Sign() == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return &BigInt{Value: new(big.Int).Quo(x, y)}, nil
+	case parser.TokenRem:
+		if y.Sign() == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return &BigInt{Value: new(big.Int).Rem(x, y)}, nil
+	case parser.TokenAnd:
+		return &BigInt{Value: new(big.Int).And(x, y)}, nil
+	case parser.TokenOr:
+		return &BigInt{Value: new(big.Int).Or(x, y)}, nil
+	case parser.TokenXor:
+		return &BigInt{Value: new(big.Int).Xor(x, y)}, nil
+	case parser.TokenAndNot:
+		return &BigInt{Value: new(big.Int).AndNot(x, y)}, nil
+	case parser.TokenShl, parser.TokenShr:
+		if !y.IsInt64() || y.Sign() < 0 {
+			return nil, fmt.Errorf("invalid shift count: %s", y)
+		}
+		if op == parser.TokenShr {
+			if y.Int64() > int64(x.BitLen()) {
+				// all the bits are shifted out
+				return &BigInt{Value: big.NewInt(int64(x.Sign()) >> 1)}, nil
+			}
+			return &BigInt{Value: new(big.Int).Rsh(x, uint(y.Int64()))}, nil
+		}
+		if x.Sign() != 0 && y.Int64() > int64(MaxBigIntBits-x.BitLen()) {
+			return nil, ErrBigIntLimit
+		}
+		return &BigInt{Value: new(big.Int).Lsh(x, uint(y.Int64()))}, nil
+	case parser.TokenLess:
+		return boolValue(x.Cmp(y) < 0), nil
+	case parser.TokenGreater:
+		return boolValue(x.Cmp(y) > 0), nil
+	case parser.TokenLessEq:
+		return boolValue(x.Cmp(y) <= 0), nil
+	case parser.TokenGreaterEq:
+		return boolValue(x.Cmp(y) >= 0), nil
+	}
+	return nil, ErrInvalidOperator
+}
+
+// Copy returns a copy of the type.
+func (o *BigInt) Copy() Object {
+	return &BigInt{Value: new(big.Int).Set(o.Value)}
+}
+
+// IsFalsy returns true if the value of the type is falsy.
+func (o *BigInt) IsFalsy() bool {
+	return o.Value.Sign() == 0
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *BigInt) Equals(x Object) bool {
+	switch x := x.(type) {
+	case *Int:
+		return o.Value.IsInt64() && o.Value.Int64() == x.Value
+	case *BigInt:
+		return o.Value.Cmp(x.Value) == 0
+	case *Decimal:
+		return x.Equals(o)
+	}
+	return false
+}
+
 // Bool represents a boolean value.
 type Bool struct {
 	ObjectImpl
@@ -314,11 +439,48 @@ func (o *Bool) GobEncode() (b []byte, err error) {
 	return
 }
 
+// BoundMethod represents a method of an instance. Calling it calls the method
+// with the instance as the first argument.
+type BoundMethod struct {
+	ObjectImpl
+	Self   *Instance
+	Method *CompiledFunction
+}
+
+// TypeName returns the name of the type.
+func (o *BoundMethod) TypeName() string {
+	return "bound-method"
+}
+
+func (o *BoundMethod) String() string {
+	return "<bound-method>"
+}
+
+// Copy returns a copy of the type.
+func (o *BoundMethod) Copy() Object {
+	return &BoundMethod{Self: o.Self, Method: o.Method}
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *BoundMethod) Equals(x Object) bool {
+	t, ok := x.(*BoundMethod)
+	return ok && o.Self == t.Self && o.Method == t.Method
+}
+
+// CanCall returns whether the Object can be Called.
+func (o *BoundMethod) CanCall() bool {
+	return true
+}
+
 // BuiltinFunction represents a builtin function.
 type BuiltinFunction struct {
 	ObjectImpl
-	Name  string
-	Value CallableFunc
+	Name      string
+	Value     CallableFunc
+	KwValue   CallableKwFunc // called instead of Value if set
+	Iterables bool           // script-defined iterable arguments are passed as arrays
+	NeedVMObj bool           // the calling VM is passed as the first argument
 }
 
 // TypeName returns the name of the type.
@@ -332,7 +494,12 @@ func (o *BuiltinFunction) String() string {
 
 // Copy returns a copy of the type.
 func (o *BuiltinFunction) Copy() Object {
-	return &BuiltinFunction{Value: o.Value}
+	return &BuiltinFunction{
+		Value:     o.Value,
+		KwValue:   o.KwValue,
+		Iterables: o.Iterables,
+		NeedVMObj: o.NeedVMObj,
+	}
 }
 
 // Equals returns true if the value of the type is equal to the value of
@@ -343,6 +510,9 @@ func (o *BuiltinFunction) Equals(_ Object) bool {
 
 // Call executes a builtin function.
 func (o *BuiltinFunction) Call(args ...Object) (Object, error) {
+	if o.KwValue != nil {
+		return o.KwValue(nil, args...)
+	}
 	return o.Value(args...)
 }
 
@@ -361,14 +531,14 @@ func (m *BuiltinModule) Import(moduleName string) (interface{}, error) {
 	return m.AsMap(moduleName), nil
 }
 
-// AsMap converts builtin module into an map.
+// AsMap converts builtin module into an immutable map.
 func (m *BuiltinModule) AsMap(moduleName string) *Map {
 	attrs := make(map[string]Object, len(m.Attrs))
 	for k, v := range m.Attrs {
 		attrs[k] = v.Copy()
 	}
 	attrs["__module_name__"] = &String{Value: moduleName}
-	return &Map{Value: attrs}
+	return Freeze(&Map{Value: attrs}).(*Map)
 }
 
 // Bytes represents a byte array.
@@ -564,13 +734,102 @@ func (o *Char) Equals(x Object) bool {
 	return o.Value == t.Value
 }
 
+// Class represents a class declared by a class statement. Calling the class
+// creates an instance: it's initialized by the init method called with the
+// arguments if there's one, or by assigning the arguments to the fields in
+// order otherwise.
+type Class struct {
+	ObjectImpl
+	Name     string
+	Fields   []string                     // field names in declaration order
+	Defaults map[string]Object            // default values of the fields
+	Methods  map[string]*CompiledFunction // methods taking self first
+	vm       *VM                          // VM the class was declared in
+}
+
+// TypeName returns the name of the type.
+func (o *Class) TypeName() string {
+	return "class"
+}
+
+func (o *Class) String() string {
+	return "<class " + o.Name + ">"
+}
+
+// Copy returns the class itself, as a class cannot be modified.
+func (o *Class) Copy() Object {
+	return o
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *Class) Equals(x Object) bool {
+	return o == x
+}
+
+// IndexGet returns the method of the class with the given name, which takes
+// the instance as the first argument.
+func (o *Class) IndexGet(index Object) (Object, error) {
+	strIdx, ok := ToString(index)
+	if !ok {
+		return nil, ErrInvalidIndexType
+	}
+	if m, ok := o.Methods[strIdx]; ok {
+		return m, nil
+	}
+	return NilValue, nil
+}
+
+// New creates an instance of the class with the fields set to the default
+// values, or to the arguments in order.
+func (o *Class) New(args ...Object) (*Instance, error) {
+	if len(args) > len(o.Fields) {
+		return nil, ErrWrongNumArguments
+	}
+	fields := make(map[string]Object, len(o.Fields))
+	for i, name := range o.Fields {
+		if i < len(args) {
+			fields[name] = args[i]
+		} else {
+			fields[name] = o.Defaults[name].Copy()
+		}
+	}
+	return &Instance{Class: o, Fields: fields}, nil
+}
+
+// Call creates an instance of the class, like calling the class in the
+// script. The init method, if there's one, runs on a new VM sharing the
+// globals and the allocation limit of the VM the class was declared in.
+func (o *Class) Call(args ...Object) (Object, error) {
+	fn, ok := o.Methods["init"]
+	if !ok {
+		return o.New(args...)
+	}
+	if o.vm == nil {
+		return nil, ErrNotImplemented
+	}
+	inst, _ := o.New()
+	if _, err := o.vm.callFunc(fn, append([]Object{inst}, args...)); err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+// CanCall returns whether the Object can be Called.
+func (o *Class) CanCall() bool {
+	return true
+}
+
 // CompiledFunction represents a compiled function.
 type CompiledFunction struct {
 	ObjectImpl
 	Instructions  []byte
 	NumLocals     int // number of local variables (including function parameters)
 	NumParameters int
+	NumDefaults   int      // number of trailing parameters with default values
+	ParamNames    []string // for the named arguments
 	VarArgs       bool
+	Generator     bool // if the function contains yield statements
 	SourceMap     map[int]parser.Pos
 	Free          []*ObjectPtr
 }
@@ -590,7 +849,10 @@ func (o *CompiledFunction) Copy() Object {
 		Instructions:  append([]byte{}, o.Instructions...),
 		NumLocals:     o.NumLocals,
 		NumParameters: o.NumParameters,
+		NumDefaults:   o.NumDefaults,
+		ParamNames:    o.ParamNames,
 		VarArgs:       o.VarArgs,
+		Generator:     o.Generator,
 		Free:          append([]*ObjectPtr{}, o.Free...), // DO NOT Copy() of elements; these are variable pointers
 	}
 }
@@ -617,6 +879,284 @@ func (o *CompiledFunction) CanCall() bool {
 	return true
 }
 
+// Decimal represents an arbitrary-precision decimal number. Its value is
+// Value * 10^-Scale.
+type Decimal struct {
+	ObjectImpl
+	Value *big.Int
+	Scale int
+}
+
+func (o *Decimal) String() string {
+	s := new(big.Int).Abs(o.Value).String()
+	if o.Scale > 0 {
+		if len(s) <= o.Scale {
+			s = strings.Repeat("0", o.Scale-len(s)+1) + s
+		}
+		s = s[:len(s)-o.Scale] + "." + s[len(s)-o.Scale:]
+	}
+	if o.Value.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// TypeName returns the name of the type.
+func (o *Decimal) TypeName() string {
+	return "decimal"
+}
+
+// BinaryOp returns another object that is the result of a given binary
+// operator and a right-hand side object. Int, BigInt and Float operands are
+// converted to Decimal.
+func (o *Decimal) BinaryOp(op parser.Token, rhs Object) (Object, error) {
+	y, ok := toDecimal(rhs)
+	if !ok {
+		return nil, ErrInvalidOperator
+	}
+
+	switch op {
+	case parser.TokenAdd:
+		a, b, scale := alignDecimals(o, y)
+		return newDecimal(a.Add(a, b), scale)
+	case parser.TokenSub:
+		a, b, scale := alignDecimals(o, y)
+		return newDecimal(a.Sub(a, b), scale)
+	case parser.TokenMul:
+		if o.Value.BitLen()+y.Value.BitLen() > MaxBigIntBits+1 {
+			return nil, ErrBigIntLimit
+		}
+		return newDecimal(new(big.Int).Mul(o.Value, y.Value), o.Scale+y.Scale)
+	case parser.TokenQuo:
+		if y.Value.Sign() == 0 {
+			return nil, ErrDivisionByZero
+		}
+		q := o.quo(y)
+		return newDecimal(q.Value, q.Scale)
+	case parser.TokenRem:
+		if y.Value.Sign() == 0 {
+			return nil, ErrDivisionByZero
+		}
+		a, b, scale := alignDecimals(o, y)
+		return newDecimal(a.Rem(a, b), scale)
+	case parser.TokenLess:
+		return boolValue(o.cmp(y) < 0), nil
+	case parser.TokenGreater:
+		return boolValue(o.cmp(y) > 0), nil
+	case parser.TokenLessEq:
+		return boolValue(o.cmp(y) <= 0), nil
+	case parser.TokenGreaterEq:
+		return boolValue(o.cmp(y) >= 0), nil
+	}
+	return nil, ErrInvalidOperator
+}
+
+// Copy returns a copy of the type.
+func (o *Decimal) Copy() Object {
+	return &Decimal{Value: new(big.Int).Set(o.Value), Scale: o.Scale}
+}
+
+// IsFalsy returns true if the value of the type is falsy.
+func (o *Decimal) IsFalsy() bool {
+	return o.Value.Sign() == 0
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *Decimal) Equals(x Object) bool {
+	if _, ok := x.(*Float); ok {
+		// like Int, never equal to Float
+		return false
+	}
+	y, ok := toDecimal(x)
+	return ok && o.cmp(y) == 0
+}
+
+// Int returns the integer part of the decimal, truncated toward zero.
+func (o *Decimal) Int() *big.Int {
+	return new(big.Int).Quo(o.Value, pow10(o.Scale))
+}
+
+// Rat returns the value of the decimal as a rational number.
+func (o *Decimal) Rat() *big.Rat {
+	return new(big.Rat).SetFrac(o.Value, pow10(o.Scale))
+}
+
+// Round returns the decimal rounded half away from zero to the given number
+// of fractional digits.
+func (o *Decimal) Round(scale int) *Decimal {
+	if o.Scale <= scale {
+		return o
+	}
+	return &Decimal{
+		Value: roundQuo(o.Value, pow10(o.Scale-scale)),
+		Scale: scale,
+	}
+}
+
+func (o *Decimal) cmp(y *Decimal) int {
+	a, b, _ := alignDecimals(o, y)
+	return a.Cmp(b)
+}
+
+// decimalQuoScale is the minimum number of fractional digits of the quotient
+// of decimals.
+const decimalQuoScale = 16
+
+// quo returns the quotient rounded to decimalQuoScale fractional digits, or
+// to the scale of the operands if it's greater. Trailing zeros beyond the
+// scale of the operands are removed.
+func (o *Decimal) quo(y *Decimal) *Decimal {
+	minScale := o.Scale
+	if y.Scale > minScale {
+		minScale = y.Scale
+	}
+	scale := minScale
+	if scale < decimalQuoScale {
+		scale = decimalQuoScale
+	}
+
+	num := new(big.Int).Mul(o.Value, pow10(scale+y.Scale-o.Scale))
+	q := roundQuo(num, y.Value)
+	ten, rem := big.NewInt(10), new(big.Int)
+	for scale > minScale {
+		if rem.Rem(q, ten).Sign() != 0 {
+			break
+		}
+		q.Quo(q, ten)
+		scale--
+	}
+	return &Decimal{Value: q, Scale: scale}
+}
+
+// alignDecimals returns the values of the decimals scaled to the greater of
+// their scales, and the scale.
+func alignDecimals(x, y *Decimal) (a, b *big.Int, scale int) {
+	a, b = new(big.Int).Set(x.Value), new(big.Int).Set(y.Value)
+	switch {
+	case x.Scale < y.Scale:
+		a.Mul(a, pow10(y.Scale-x.Scale))
+		return a, b, y.Scale
+	case x.Scale > y.Scale:
+		b.Mul(b, pow10(x.Scale-y.Scale))
+	}
+	return a, b, x.Scale
+}
+
+// roundQuo returns x / y rounded half away from zero.
+func roundQuo(x, y *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(x, y, new(big.Int))
+	if r.Sign() == 0 {
+		return q
+	}
+	r.Abs(r).Lsh(r, 1)
+	if r.Cmp(new(big.Int).Abs(y)) >= 0 {
+		if x.Sign() != y.Sign() {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+// maxDecimalScale returns the maximum number of fractional digits of a
+// decimal. 10^scale has less than 4*scale bits, so aligning the scales of
+// decimals keeps their values within a few times MaxBigIntBits.
+func maxDecimalScale() int {
+	return MaxBigIntBits / 4
+}
+
+// newBigInt returns a BigInt of the value, or ErrBigIntLimit if the value
+// has more than MaxBigIntBits bits.
+func newBigInt(v *big.Int) (Object, error) {
+	if v.BitLen() > MaxBigIntBits {
+		return nil, ErrBigIntLimit
+	}
+	return &BigInt{Value: v}, nil
+}
+
+// newDecimal returns a Decimal of the value and the scale, or ErrBigIntLimit
+// if the value has more than MaxBigIntBits bits or the scale is greater than
+// maxDecimalScale.
+func newDecimal(v *big.Int, scale int) (*Decimal, error) {
+	if v.BitLen() > MaxBigIntBits || scale > maxDecimalScale() {
+		return nil, ErrBigIntLimit
+	}
+	return &Decimal{Value: v, Scale: scale}, nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// ParseDecimal parses a decimal number in the form of a float literal, e.g.
+// "-12.345" or "1.5e3". It returns ErrBigIntLimit if the number exceeds the
+// size limits of decimals.
+func ParseDecimal(s string) (*Decimal, error) {
+	mant, exp := s, 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid decimal: %q", s)
+		}
+		mant, exp = s[:i], e
+	}
+	intPart, frac := mant, ""
+	if i := strings.IndexByte(mant, '.'); i >= 0 {
+		intPart, frac = mant[:i], mant[i+1:]
+	}
+	if strings.ContainsAny(frac, "+-") {
+		return nil, fmt.Errorf("invalid decimal: %q", s)
+	}
+	v, ok := new(big.Int).SetString(intPart+frac, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal: %q", s)
+	}
+	maxScale := maxDecimalScale()
+	if exp > maxScale || exp < -maxScale {
+		return nil, ErrBigIntLimit
+	}
+	scale := len(frac) - exp
+	if scale < 0 {
+		v.Mul(v, pow10(-scale))
+		scale = 0
+	}
+	return newDecimal(v, scale)
+}
+
+// toDecimal converts an Int, BigInt, finite Float or Decimal to Decimal.
+func toDecimal(o Object) (*Decimal, bool) {
+	switch o := o.(type) {
+	case *Int:
+		return &Decimal{Value: big.NewInt(o.Value)}, true
+	case *BigInt:
+		return &Decimal{Value: o.Value}, true
+	case *Float:
+		if math.IsInf(o.Value, 0) || math.IsNaN(o.Value) {
+			return nil, false
+		}
+		d, err := ParseDecimal(strconv.FormatFloat(o.Value, 'g', -1, 64))
+		return d, err == nil
+	case *Decimal:
+		return o, true
+	}
+	return nil, false
+}
+
+// bigIntFloat returns the nearest float64 value of the integer.
+func bigIntFloat(x *big.Int) float64 {
+	f, _ := new(big.Float).SetInt(x).Float64()
+	return f
+}
+
+func boolValue(b bool) Object {
+	if b {
+		return TrueValue
+	}
+	return FalseValue
+}
+
 // Error represents an error value.
 type Error struct {
 	ObjectImpl
@@ -661,6 +1201,82 @@ func (o *Error) IndexGet(index Object) (res Object, err error) {
 	return
 }
 
+// Exception represents a value thrown by a throw statement or a runtime
+// error, that can be caught by a try statement.
+type Exception struct {
+	ObjectImpl
+	Value Object         // thrown value; an Error for runtime errors
+	Err   error          // Go error of runtime errors; or nil
+	Pos   parser.FilePos // position where it was thrown
+}
+
+// TypeName returns the name of the type.
+func (o *Exception) TypeName() string {
+	return "exception"
+}
+
+func (o *Exception) String() string {
+	return fmt.Sprintf("exception: %s", o.Message())
+}
+
+// Error returns the message of the exception so that uncaught exceptions can
+// be returned as an error.
+func (o *Exception) Error() string {
+	return o.Message()
+}
+
+// Unwrap returns the Go error of the exception.
+func (o *Exception) Unwrap() error {
+	return o.Err
+}
+
+// Message returns the message of the exception.
+func (o *Exception) Message() string {
+	if o.Err != nil {
+		return o.Err.Error()
+	}
+	value := o.Value
+	if e, ok := value.(*Error); ok && e.Value != nil {
+		value = e.Value
+	}
+	s, _ := ToString(value)
+	return s
+}
+
+// IsFalsy returns true if the value of the type is falsy.
+func (o *Exception) IsFalsy() bool {
+	return true // exception is always false.
+}
+
+// Copy returns a copy of the type.
+func (o *Exception) Copy() Object {
+	return &Exception{Value: o.Value.Copy(), Err: o.Err, Pos: o.Pos}
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *Exception) Equals(x Object) bool {
+	return o == x // pointer equality
+}
+
+// IndexGet returns an element at a given index.
+func (o *Exception) IndexGet(index Object) (res Object, err error) {
+	strIdx, _ := ToString(index)
+	switch strIdx {
+	case "value":
+		res = o.Value
+	case "message":
+		res = &String{Value: o.Message()}
+	case "err":
+		res = &Error{Value: &String{Value: o.Message()}}
+	case "position":
+		res = &String{Value: o.Pos.String()}
+	default:
+		err = ErrInvalidIndexOnError
+	}
+	return
+}
+
 // Float represents a floating point number value.
 type Float struct {
 	ObjectImpl
@@ -774,6 +1390,14 @@ func (o *Float) BinaryOp(op parser.Token, rhs Object) (Object, error) {
 			}
 			return FalseValue, nil
 		}
+	case *BigInt:
+		return o.BinaryOp(op, &Float{Value: bigIntFloat(rhs.Value)})
+	case *Decimal:
+		x, ok := toDecimal(o)
+		if !ok {
+			return nil, ErrInvalidOperator
+		}
+		return x.BinaryOp(op, rhs)
 	}
 	return nil, ErrInvalidOperator
 }
@@ -798,6 +1422,81 @@ func (o *Float) Equals(x Object) bool {
 	return o.Value == t.Value
 }
 
+// Instance represents an instance of a class. Its type name is the name of
+// the class.
+type Instance struct {
+	ObjectImpl
+	Class  *Class
+	Fields map[string]Object
+}
+
+// TypeName returns the name of the type.
+func (o *Instance) TypeName() string {
+	return o.Class.Name
+}
+
+func (o *Instance) String() string {
+	var fields []string
+	for _, name := range o.Class.Fields {
+		fields = append(fields,
+			fmt.Sprintf("%s: %s", name, o.Fields[name].String()))
+	}
+	return fmt.Sprintf("%s{%s}", o.Class.Name, strings.Join(fields, ", "))
+}
+
+// Copy returns a copy of the type.
+func (o *Instance) Copy() Object {
+	c := make(map[string]Object, len(o.Fields))
+	for k, v := range o.Fields {
+		c[k] = v.Copy()
+	}
+	return &Instance{Class: o.Class, Fields: c}
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *Instance) Equals(x Object) bool {
+	t, ok := x.(*Instance)
+	if !ok || o.Class != t.Class {
+		return false
+	}
+	for k, v := range o.Fields {
+		if !v.Equals(t.Fields[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+// IndexGet returns the field with the given name, or the method bound to the
+// instance.
+func (o *Instance) IndexGet(index Object) (Object, error) {
+	strIdx, ok := ToString(index)
+	if !ok {
+		return nil, ErrInvalidIndexType
+	}
+	if v, ok := o.Fields[strIdx]; ok {
+		return v, nil
+	}
+	if m, ok := o.Class.Methods[strIdx]; ok {
+		return &BoundMethod{Self: o, Method: m}, nil
+	}
+	return NilValue, nil
+}
+
+// IndexSet sets the field with the given name.
+func (o *Instance) IndexSet(index, value Object) error {
+	strIdx, ok := ToString(index)
+	if !ok {
+		return ErrInvalidIndexType
+	}
+	if _, ok := o.Fields[strIdx]; !ok {
+		return fmt.Errorf("%s has no field '%s'", o.Class.Name, strIdx)
+	}
+	o.Fields[strIdx] = value
+	return nil
+}
+
 // Int represents an integer value.
 type Int struct {
 	ObjectImpl
@@ -873,6 +1572,13 @@ func (o *Int) BinaryOp(op parser.Token, rhs Object) (Object, error) {
 				return o, nil
 			}
 			return &Int{Value: r}, nil
+		case parser.TokenRange:
+			r := newRange(o.Value, rhs.Value, 1)
+			r.Len++
+			r.Stop += r.Step
+			return r, nil
+		case parser.TokenRangeExcl:
+			return newRange(o.Value, rhs.Value, 1), nil
 		case parser.TokenShl:
 			r := o.Value << uint64(rhs.Value)
 			if r == o.Value {
@@ -937,6 +1643,10 @@ func (o *Int) BinaryOp(op parser.Token, rhs Object) (Object, error) {
 			}
 			return FalseValue, nil
 		}
+	case *BigInt:
+		return (&BigInt{Value: big.NewInt(o.Value)}).BinaryOp(op, rhs)
+	case *Decimal:
+		return (&Decimal{Value: big.NewInt(o.Value)}).BinaryOp(op, rhs)
 	case *Char:
 		switch op {
 		case parser.TokenAdd:
@@ -981,17 +1691,20 @@ func (o *Int) IsFalsy() bool {
 // Equals returns true if the value of the type is equal to the value of
 // another object.
 func (o *Int) Equals(x Object) bool {
-	t, ok := x.(*Int)
-	if !ok {
-		return false
+	switch t := x.(type) {
+	case *Int:
+		return o.Value == t.Value
+	case *BigInt, *Decimal:
+		return t.Equals(o)
 	}
-	return o.Value == t.Value
+	return false
 }
 
 // Map represents a map of objects.
 type Map struct {
 	ObjectImpl
-	Value map[string]Object
+	Value     map[string]Object
+	Immutable bool
 }
 
 // TypeName returns the name of the type.
@@ -1007,7 +1720,7 @@ func (o *Map) String() string {
 	return fmt.Sprintf("{%s}", strings.Join(pairs, ", "))
 }
 
-// Copy returns a copy of the type.
+// Copy returns a copy of the type. The copy of an immutable map is mutable.
 func (o *Map) Copy() Object {
 	c := make(map[string]Object)
 	for k, v := range o.Value {
@@ -1059,6 +1772,10 @@ func (o *Map) IndexGet(index Object) (res Object, err error) {
 
 // IndexSet sets the value for the given key.
 func (o *Map) IndexSet(index, value Object) (err error) {
+	if o.Immutable {
+		err = ErrImmutable
+		return
+	}
 	strIdx, ok := ToString(index)
 	if !ok {
 		err = ErrInvalidIndexType
@@ -1117,6 +1834,179 @@ func (o *ObjectPtr) Equals(x Object) bool {
 	return o == x
 }
 
+// Range represents a lazy array of Len ints, from Start incremented by Step,
+// created by range or a range literal. The elements are only materialized
+// when it's used where an array is needed, after which the range behaves as
+// that array.
+type Range struct {
+	ObjectImpl
+	Start int64
+	Stop  int64 // bound the range was created with, not included
+	Step  int64 // negative for a descending range
+	Len   int64
+	arr   *Array
+}
+
+// newRange returns the range of ints from start up to, but not including,
+// stop, with the step in the direction of stop. The step must be positive.
+func newRange(start, stop, step int64) *Range {
+	// the distance is computed in uint64 as it may overflow int64
+	d, s := uint64(stop-start), uint64(step)
+	if start > stop {
+		d, step = uint64(start-stop), -step
+	}
+	n := d / s
+	if d%s != 0 {
+		n++
+	}
+	return &Range{Start: start, Stop: stop, Step: step, Len: int64(n)}
+}
+
+// TypeName returns the name of the type.
+func (o *Range) TypeName() string {
+	return "array"
+}
+
+func (o *Range) String() string {
+	if o.arr != nil {
+		return o.arr.String()
+	}
+	if o.Step == 1 || o.Step == -1 {
+		return fmt.Sprintf("range(%d, %d)", o.Start, o.Stop)
+	}
+	step := o.Step
+	if step < 0 {
+		step = -step
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", o.Start, o.Stop, step)
+}
+
+// BinaryOp returns another object that is the result of a given binary
+// operator and a right-hand side object.
+func (o *Range) BinaryOp(op parser.Token, rhs Object) (Object, error) {
+	return (&Array{Value: o.appendTo(nil)}).BinaryOp(op, rhs)
+}
+
+// Copy returns a copy of the type.
+func (o *Range) Copy() Object {
+	if o.arr != nil {
+		return o.arr.Copy()
+	}
+	return &Range{Start: o.Start, Stop: o.Stop, Step: o.Step, Len: o.Len}
+}
+
+// IsFalsy returns true if the value of the type is falsy.
+func (o *Range) IsFalsy() bool {
+	if o.arr != nil {
+		return o.arr.IsFalsy()
+	}
+	return o.Len == 0
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *Range) Equals(x Object) bool {
+	if o.arr != nil {
+		return o.arr.Equals(x)
+	}
+	switch x := x.(type) {
+	case *Range:
+		if x.arr != nil {
+			return o.Equals(x.arr)
+		}
+		if o.Len != x.Len {
+			return false
+		}
+		return o.Len == 0 ||
+			(o.Start == x.Start && (o.Len == 1 || o.Step == x.Step))
+	case *Array:
+		if o.Len != int64(len(x.Value)) {
+			return false
+		}
+		for i, e := range x.Value {
+			if !(&Int{Value: o.Start + int64(i)*o.Step}).Equals(e) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// IndexGet returns an element at a given index.
+func (o *Range) IndexGet(index Object) (Object, error) {
+	if o.arr != nil {
+		return o.arr.IndexGet(index)
+	}
+	intIdx, ok := index.(*Int)
+	if !ok {
+		return nil, ErrInvalidIndexType
+	}
+	if intIdx.Value < 0 || intIdx.Value >= o.Len {
+		return NilValue, nil
+	}
+	return &Int{Value: o.Start + intIdx.Value*o.Step}, nil
+}
+
+// IndexSet sets an element at a given index. The range is materialized.
+func (o *Range) IndexSet(index, value Object) error {
+	return o.array().IndexSet(index, value)
+}
+
+// len returns the number of elements of the range.
+func (o *Range) len() int64 {
+	if o.arr != nil {
+		return int64(len(o.arr.Value))
+	}
+	return o.Len
+}
+
+// array returns the elements of the range as an array, materializing them
+// the first time. The range shares the array from then on.
+func (o *Range) array() *Array {
+	if o.arr == nil {
+		o.arr = &Array{Value: o.appendTo(nil)}
+	}
+	return o.arr
+}
+
+// appendTo appends the elements of the range to dst and returns the extended
+// slice.
+func (o *Range) appendTo(dst []Object) []Object {
+	if o.arr != nil {
+		return append(dst, o.arr.Value...)
+	}
+	for i := int64(0); i < o.Len; i++ {
+		dst = append(dst, &Int{Value: o.Start + i*o.Step})
+	}
+	return dst
+}
+
+// Iterate creates a range iterator.
+func (o *Range) Iterate() Iterator {
+	if o.arr != nil {
+		return o.arr.Iterate()
+	}
+	return &RangeIterator{r: o}
+}
+
+// CanIterate returns whether the Object can be Iterated.
+func (o *Range) CanIterate() bool {
+	return true
+}
+
+// toArray returns the array value of o, materializing a range, and false if
+// o is not an array.
+func toArray(o Object) (*Array, bool) {
+	switch o := o.(type) {
+	case *Array:
+		return o, true
+	case *Range:
+		return o.array(), true
+	}
+	return nil, false
+}
+
 // String represents a string value.
 type String struct {
 	ObjectImpl
@@ -1388,6 +2278,7 @@ type UserFunction struct {
 	ObjectImpl
 	Name       string
 	Value      CallableFunc
+	KwValue    CallableKwFunc // called instead of Value if set
 	EncodingID string
 }
 
@@ -1402,7 +2293,7 @@ func (o *UserFunction) String() string {
 
 // Copy returns a copy of the type.
 func (o *UserFunction) Copy() Object {
-	return &UserFunction{Value: o.Value}
+	return &UserFunction{Value: o.Value, KwValue: o.KwValue}
 }
 
 // Equals returns true if the value of the type is equal to the value of
@@ -1413,6 +2304,9 @@ func (o *UserFunction) Equals(_ Object) bool {
 
 // Call invokes a user function.
 func (o *UserFunction) Call(args ...Object) (Object, error) {
+	if o.KwValue != nil {
+		return o.KwValue(nil, args...)
+	}
 	return o.Value(args...)
 }
 
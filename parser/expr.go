@@ -10,6 +10,30 @@ type Expr interface {
 	exprNode()
 }
 
+// ArrayComp represents an array comprehension.
+type ArrayComp struct {
+	LBrack Pos
+	Elem   Expr
+	Clause *CompClause
+	RBrack Pos
+}
+
+func (e *ArrayComp) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *ArrayComp) Pos() Pos {
+	return e.LBrack
+}
+
+// End returns the position of first character immediately after the node.
+func (e *ArrayComp) End() Pos {
+	return e.RBrack + 1
+}
+
+func (e *ArrayComp) String() string {
+	return "[" + e.Elem.String() + " " + e.Clause.String() + "]"
+}
+
 // ArrayLit represents an array literal.
 type ArrayLit struct {
 	Elements []Expr
@@ -109,11 +133,12 @@ func (e *BoolLit) String() string {
 
 // CallExpr represents a function call expression.
 type CallExpr struct {
-	Func     Expr
-	LParen   Pos
-	Args     []Expr
-	Ellipsis Pos
-	RParen   Pos
+	Func      Expr
+	LParen    Pos
+	Args      []Expr
+	Ellipsis  Pos
+	NamedArgs []*MapElementLit
+	RParen    Pos
 }
 
 func (e *CallExpr) exprNode() {}
@@ -136,9 +161,35 @@ func (e *CallExpr) String() string {
 	if len(args) > 0 && e.Ellipsis.IsValid() {
 		args[len(args)-1] = args[len(args)-1] + "..."
 	}
+	for _, e := range e.NamedArgs {
+		args = append(args, e.String())
+	}
 	return e.Func.String() + "(" + strings.Join(args, ", ") + ")"
 }
 
+// ChainExpr represents an optional chain: a selector, index or call
+// sequence containing at least one "?." or "?[" link. Evaluation of the
+// chain stops and yields nil as soon as an optional link sees nil.
+type ChainExpr struct {
+	Expr Expr
+}
+
+func (e *ChainExpr) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *ChainExpr) Pos() Pos {
+	return e.Expr.Pos()
+}
+
+// End returns the position of first character immediately after the node.
+func (e *ChainExpr) End() Pos {
+	return e.Expr.End()
+}
+
+func (e *ChainExpr) String() string {
+	return e.Expr.String()
+}
+
 // CharLit represents a character literal.
 type CharLit struct {
 	Value    rune
@@ -162,6 +213,40 @@ func (e *CharLit) String() string {
 	return e.Literal
 }
 
+// CompClause represents the clause of a comprehension iterating over the
+// iterable, e.g. for k, v in m if v > 0.
+type CompClause struct {
+	ForPos   Pos
+	Key      *Ident
+	Value    *Ident
+	Iterable Expr
+	Cond     Expr // or nil
+}
+
+func (e *CompClause) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *CompClause) Pos() Pos {
+	return e.ForPos
+}
+
+// End returns the position of first character immediately after the node.
+func (e *CompClause) End() Pos {
+	if e.Cond != nil {
+		return e.Cond.End()
+	}
+	return e.Iterable.End()
+}
+
+func (e *CompClause) String() string {
+	s := "for " + e.Key.String() + ", " + e.Value.String() + " in " +
+		e.Iterable.String()
+	if e.Cond != nil {
+		s += " if " + e.Cond.String()
+	}
+	return s
+}
+
 // CondExpr represents a ternary conditional expression.
 type CondExpr struct {
 	Cond        Expr
@@ -254,13 +339,14 @@ func (e *FuncLit) End() Pos {
 }
 
 func (e *FuncLit) String() string {
-	return "func" + e.Type.Params.String() + " " + e.Body.String()
+	return e.Type.String() + " " + e.Body.String()
 }
 
 // FuncType represents a function type definition.
 type FuncType struct {
 	FuncPos Pos
 	Params  *IdentList
+	Result  Expr // result type annotation, nil if none
 }
 
 func (e *FuncType) exprNode() {}
@@ -272,10 +358,16 @@ func (e *FuncType) Pos() Pos {
 
 // End returns the position of first character immediately after the node.
 func (e *FuncType) End() Pos {
+	if e.Result != nil {
+		return e.Result.End()
+	}
 	return e.Params.End()
 }
 
 func (e *FuncType) String() string {
+	if e.Result != nil {
+		return "func" + e.Params.String() + ": " + e.Result.String()
+	}
 	return "func" + e.Params.String()
 }
 
@@ -304,6 +396,30 @@ func (e *Ident) String() string {
 	return nullRep
 }
 
+// ImmutableExpr represents an immutable expression
+type ImmutableExpr struct {
+	Expr         Expr
+	ImmutablePos Pos
+	LParen       Pos
+	RParen       Pos
+}
+
+func (e *ImmutableExpr) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *ImmutableExpr) Pos() Pos {
+	return e.ImmutablePos
+}
+
+// End returns the position of first character immediately after the node.
+func (e *ImmutableExpr) End() Pos {
+	return e.RParen
+}
+
+func (e *ImmutableExpr) String() string {
+	return "immutable(" + e.Expr.String() + ")"
+}
+
 // ImportExpr represents an import expression
 type ImportExpr struct {
 	ModuleName string
@@ -330,10 +446,11 @@ func (e *ImportExpr) String() string {
 
 // IndexExpr represents an index expression.
 type IndexExpr struct {
-	Expr   Expr
-	LBrack Pos
-	Index  Expr
-	RBrack Pos
+	Expr     Expr
+	LBrack   Pos
+	Index    Expr
+	RBrack   Pos
+	Optional bool
 }
 
 func (e *IndexExpr) exprNode() {}
@@ -353,9 +470,35 @@ func (e *IndexExpr) String() string {
 	if e.Index != nil {
 		index = e.Index.String()
 	}
+	if e.Optional {
+		return e.Expr.String() + "?[" + index + "]"
+	}
 	return e.Expr.String() + "[" + index + "]"
 }
 
+// InterpStringLit represents an interpolated string literal.
+type InterpStringLit struct {
+	Parts    []Expr // StringLit for text and embedded expressions
+	ValuePos Pos
+	Literal  string
+}
+
+func (e *InterpStringLit) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *InterpStringLit) Pos() Pos {
+	return e.ValuePos
+}
+
+// End returns the position of first character immediately after the node.
+func (e *InterpStringLit) End() Pos {
+	return Pos(int(e.ValuePos) + len(e.Literal))
+}
+
+func (e *InterpStringLit) String() string {
+	return e.Literal
+}
+
 // IntLit represents an integer literal.
 type IntLit struct {
 	Value    int64
@@ -379,12 +522,39 @@ func (e *IntLit) String() string {
 	return e.Literal
 }
 
+// MapComp represents a map comprehension.
+type MapComp struct {
+	LBrace Pos
+	Key    Expr
+	Colon  Pos
+	Value  Expr
+	Clause *CompClause
+	RBrace Pos
+}
+
+func (e *MapComp) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *MapComp) Pos() Pos {
+	return e.LBrace
+}
+
+// End returns the position of first character immediately after the node.
+func (e *MapComp) End() Pos {
+	return e.RBrace + 1
+}
+
+func (e *MapComp) String() string {
+	return "{" + e.Key.String() + ": " + e.Value.String() + " " +
+		e.Clause.String() + "}"
+}
+
 // MapElementLit represents a map element.
 type MapElementLit struct {
-	Key      string
+	Key      string // empty for a spread element
 	KeyPos   Pos
-	ColonPos Pos
-	Value    Expr
+	ColonPos Pos  // NoPos for the shorthand form of identifier keys
+	Value    Expr // *SpreadExpr for a spread element
 }
 
 func (e *MapElementLit) exprNode() {}
@@ -400,6 +570,9 @@ func (e *MapElementLit) End() Pos {
 }
 
 func (e *MapElementLit) String() string {
+	if _, ok := e.Value.(*SpreadExpr); ok {
+		return e.Value.String()
+	}
 	return e.Key + ": " + e.Value.String()
 }
 
@@ -430,6 +603,72 @@ func (e *MapLit) String() string {
 	return "{" + strings.Join(elements, ", ") + "}"
 }
 
+// MatchArm represents an arm of a match expression.
+type MatchArm struct {
+	CasePos  Pos
+	Patterns []Expr // nil means default arm
+	Guard    Expr   // or nil
+	Colon    Pos
+	Value    Expr
+}
+
+func (e *MatchArm) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *MatchArm) Pos() Pos {
+	return e.CasePos
+}
+
+// End returns the position of first character immediately after the node.
+func (e *MatchArm) End() Pos {
+	return e.Value.End()
+}
+
+func (e *MatchArm) String() string {
+	if e.Patterns == nil {
+		return "default: " + e.Value.String()
+	}
+	var patterns []string
+	for _, p := range e.Patterns {
+		patterns = append(patterns, p.String())
+	}
+	s := "case " + strings.Join(patterns, ", ")
+	if e.Guard != nil {
+		s += " if " + e.Guard.String()
+	}
+	return s + ": " + e.Value.String()
+}
+
+// MatchExpr represents a match expression.
+type MatchExpr struct {
+	MatchPos Pos
+	Subject  Expr
+	LBrace   Pos
+	Arms     []*MatchArm
+	RBrace   Pos
+}
+
+func (e *MatchExpr) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *MatchExpr) Pos() Pos {
+	return e.MatchPos
+}
+
+// End returns the position of first character immediately after the node.
+func (e *MatchExpr) End() Pos {
+	return e.RBrace + 1
+}
+
+func (e *MatchExpr) String() string {
+	var arms []string
+	for _, a := range e.Arms {
+		arms = append(arms, a.String())
+	}
+	return "match " + e.Subject.String() + " { " +
+		strings.Join(arms, "; ") + " }"
+}
+
 // ParenExpr represents a parenthesis wrapped expression.
 type ParenExpr struct {
 	Expr   Expr
@@ -453,10 +692,33 @@ func (e *ParenExpr) String() string {
 	return "(" + e.Expr.String() + ")"
 }
 
+// PropagateExpr represents an error propagation expression.
+type PropagateExpr struct {
+	Expr        Expr
+	QuestionPos Pos
+}
+
+func (e *PropagateExpr) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *PropagateExpr) Pos() Pos {
+	return e.Expr.Pos()
+}
+
+// End returns the position of first character immediately after the node.
+func (e *PropagateExpr) End() Pos {
+	return e.QuestionPos + 1
+}
+
+func (e *PropagateExpr) String() string {
+	return e.Expr.String() + "?"
+}
+
 // SelectorExpr represents a selector expression.
 type SelectorExpr struct {
-	Expr Expr
-	Sel  Expr
+	Expr     Expr
+	Sel      Expr
+	Optional bool
 }
 
 func (e *SelectorExpr) exprNode() {}
@@ -472,16 +734,20 @@ func (e *SelectorExpr) End() Pos {
 }
 
 func (e *SelectorExpr) String() string {
+	if e.Optional {
+		return e.Expr.String() + "?." + e.Sel.String()
+	}
 	return e.Expr.String() + "." + e.Sel.String()
 }
 
 // SliceExpr represents a slice expression.
 type SliceExpr struct {
-	Expr   Expr
-	LBrack Pos
-	Low    Expr
-	High   Expr
-	RBrack Pos
+	Expr     Expr
+	LBrack   Pos
+	Low      Expr
+	High     Expr
+	RBrack   Pos
+	Optional bool
 }
 
 func (e *SliceExpr) exprNode() {}
@@ -504,9 +770,34 @@ func (e *SliceExpr) String() string {
 	if e.High != nil {
 		high = e.High.String()
 	}
+	if e.Optional {
+		return e.Expr.String() + "?[" + low + ":" + high + "]"
+	}
 	return e.Expr.String() + "[" + low + ":" + high + "]"
 }
 
+// SpreadExpr represents a spread element prefixed by an ellipsis.
+type SpreadExpr struct {
+	Ellipsis Pos
+	Expr     Expr
+}
+
+func (e *SpreadExpr) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *SpreadExpr) Pos() Pos {
+	return e.Ellipsis
+}
+
+// End returns the position of first character immediately after the node.
+func (e *SpreadExpr) End() Pos {
+	return e.Expr.End()
+}
+
+func (e *SpreadExpr) String() string {
+	return "..." + e.Expr.String()
+}
+
 // StringLit represents a string literal.
 type StringLit struct {
 	Value    string
@@ -20,10 +20,12 @@ type Node interface {
 
 // IdentList represents a list of identifiers.
 type IdentList struct {
-	LParen  Pos
-	VarArgs bool
-	List    []*Ident
-	RParen  Pos
+	LParen   Pos
+	VarArgs  bool
+	List     []*Ident
+	Types    []Expr // type annotations of the parameters, nil if none
+	Defaults []Expr // default values of the parameters, nil if none
+	RParen   Pos
 }
 
 // Pos returns the position of first character belonging to the node.
@@ -48,6 +50,24 @@ func (n *IdentList) End() Pos {
 	return NoPos
 }
 
+// Type returns the type annotation of the i-th identifier, or nil if it has
+// none.
+func (n *IdentList) Type(i int) Expr {
+	if i < len(n.Types) {
+		return n.Types[i]
+	}
+	return nil
+}
+
+// Default returns the default value of the i-th identifier, or nil if it has
+// none.
+func (n *IdentList) Default(i int) Expr {
+	if i < len(n.Defaults) {
+		return n.Defaults[i]
+	}
+	return nil
+}
+
 // NumFields returns the number of fields.
 func (n *IdentList) NumFields() int {
 	if n == nil {
@@ -59,11 +79,17 @@ func (n *IdentList) NumFields() int {
 func (n *IdentList) String() string {
 	var list []string
 	for i, e := range n.List {
+		param := e.String()
 		if n.VarArgs && i == len(n.List)-1 {
-			list = append(list, "..."+e.String())
-		} else {
-			list = append(list, e.String())
+			param = "..." + param
+		}
+		if t := n.Type(i); t != nil {
+			param += ": " + t.String()
+		}
+		if d := n.Default(i); d != nil {
+			param += " = " + d.String()
 		}
+		list = append(list, param)
 	}
 	return "(" + strings.Join(list, ", ") + ")"
 }
@@ -46,6 +46,29 @@ const (
 	OpIteratorValue               // Iterator value
 	OpBinaryOp                    // Binary operation
 	OpSuspend                     // Suspend VM
+	OpSwitch                      // Jump using a jump table
+	OpSetupTry                    // Push exception handler
+	OpPopTry                      // Pop exception handler
+	OpThrow                       // Throw exception
+	OpJumpNotError                // Jump if not error
+	OpDefer                       // Defer function call
+	OpUnpack                      // Unpack array elements
+	OpUnpackMap                   // Unpack values by keys
+	OpImmutable                   // Immutable object
+	OpNilJump                     // Jump if nil
+	OpCoalesceJump                // Nil coalescing jump
+	OpYield                       // Suspend generator
+	OpClass                       // Class object
+	OpCheckedBinOp                // Binary operation checking overflow
+	OpCheckedMinus                // Minus - checking overflow
+	OpComp                        // Comprehension result object
+	OpAppend                      // Append to array
+	OpAppendMap                   // Set map element
+	OpArgJump                     // Jump if argument is passed
+	OpSpread                      // Spread elements into array or map
+	OpTailCall                    // Call function reusing the frame
+	OpIteratorClose               // Iterator close
+	OpHasKey                      // Test if map has key
 )
 
 // OpcodeNames are string representation of opcodes.
@@ -91,6 +114,29 @@ var OpcodeNames = [...]string{
 	OpIteratorValue: "ITVAL",
 	OpBinaryOp:      "BINARYOP",
 	OpSuspend:       "SUSPEND",
+	OpSwitch:        "SWITCH",
+	OpSetupTry:      "TRY",
+	OpPopTry:        "ENDTRY",
+	OpThrow:         "THROW",
+	OpJumpNotError:  "JMPNERR",
+	OpDefer:         "DEFER",
+	OpUnpack:        "UNPACK",
+	OpUnpackMap:     "UNPACKMAP",
+	OpImmutable:     "IMMUT",
+	OpNilJump:       "NILJMP",
+	OpCoalesceJump:  "COALJMP",
+	OpYield:         "YIELD",
+	OpClass:         "CLASS",
+	OpCheckedBinOp:  "CBINARYOP",
+	OpCheckedMinus:  "CNEG",
+	OpComp:          "COMP",
+	OpAppend:        "APPEND",
+	OpAppendMap:     "APPENDMAP",
+	OpArgJump:       "ARGJMP",
+	OpSpread:        "SPREAD",
+	OpTailCall:      "TAILCALL",
+	OpIteratorClose: "ITCLS",
+	OpHasKey:        "HASKEY",
 }
 
 // OpcodeOperands is the number of operands.
@@ -136,6 +182,29 @@ var OpcodeOperands = [...][]int{
 	OpIteratorValue: {},
 	OpBinaryOp:      {1},
 	OpSuspend:       {},
+	OpSwitch:        {2, 2},
+	OpSetupTry:      {2},
+	OpPopTry:        {},
+	OpThrow:         {1},
+	OpJumpNotError:  {2},
+	OpDefer:         {1, 1},
+	OpUnpack:        {1, 1},
+	OpUnpackMap:     {1},
+	OpImmutable:     {},
+	OpNilJump:       {2},
+	OpCoalesceJump:  {2},
+	OpYield:         {1},
+	OpClass:         {2, 2},
+	OpCheckedBinOp:  {1},
+	OpCheckedMinus:  {},
+	OpComp:          {1},
+	OpAppend:        {},
+	OpAppendMap:     {},
+	OpArgJump:       {2},
+	OpSpread:        {1},
+	OpTailCall:      {1, 1},
+	OpIteratorClose: {},
+	OpHasKey:        {},
 }
 
 // ReadOperands reads operands from the bytecode.
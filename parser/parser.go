@@ -15,6 +15,13 @@ var stmtStart = map[Token]bool{
 	TokenIf:       true,
 	TokenReturn:   true,
 	TokenExport:   true,
+	TokenSwitch:   true,
+	TokenTry:      true,
+	TokenThrow:    true,
+	TokenDefer:    true,
+	TokenConst:    true,
+	TokenYield:    true,
+	TokenClass:    true,
 }
 
 // Parser parses the gslang source files. It's based on Go's parser
@@ -124,6 +131,9 @@ func (p *Parser) parseBinaryExpr(prec1 int) Expr {
 
 func (p *Parser) parseCondExpr(cond Expr) Expr {
 	questionPos := p.expect(TokenQuestion)
+	if p.token == TokenSemicolon && p.tokenLit == "\n" {
+		p.next() // the true branch may start on the next line
+	}
 	trueExpr := p.parseExpr()
 	colonPos := p.expect(TokenColon)
 	falseExpr := p.parseExpr()
@@ -137,6 +147,64 @@ func (p *Parser) parseCondExpr(cond Expr) Expr {
 	}
 }
 
+// ternaryFollows reports whether the '?' at the current position starts a
+// ternary conditional expression, that is, a ':' matching it follows before
+// the end of the expression. Otherwise it is the postfix error propagation
+// operator. It scans ahead on a copy of the scanner.
+func (p *Parser) ternaryFollows() bool {
+	s := *p.scanner
+	s.errorHandler = nil
+
+	depth, pending := 0, 0
+	question := true
+	for {
+		tok, lit, _ := s.Scan()
+		if question {
+			question = false
+			switch tok {
+			case TokenColon, TokenComma, TokenRParen, TokenRBrack,
+				TokenRBrace, TokenEOF:
+				// a postfix '?' has no true branch
+			case TokenSemicolon:
+				if lit != "\n" {
+					break
+				}
+				pending++
+				continue
+			default:
+				pending++
+			}
+			if pending == 0 {
+				return false
+			}
+		}
+
+		switch tok {
+		case TokenEOF:
+			return false
+		case TokenLParen, TokenLBrack, TokenLBrace:
+			depth++
+		case TokenRParen, TokenRBrack, TokenRBrace:
+			if depth == 0 {
+				return false
+			}
+			depth--
+		case TokenComma, TokenSemicolon:
+			if depth == 0 {
+				return false
+			}
+		case TokenQuestion:
+			question = depth == 0
+		case TokenColon:
+			if depth == 0 {
+				if pending--; pending == 0 {
+					return true
+				}
+			}
+		}
+	}
+}
+
 func (p *Parser) parseUnaryExpr() Expr {
 	if p.trace {
 		defer untracep(tracep(p, "UnaryExpression"))
@@ -162,16 +230,20 @@ func (p *Parser) parsePrimaryExpr() Expr {
 	}
 
 	x := p.parseOperand()
+	optional := false
 
 L:
 	for {
 		switch p.token {
-		case TokenPeriod:
+		case TokenPeriod, TokenOptPeriod:
+			opt := p.token == TokenOptPeriod
 			p.next()
 
 			switch p.token {
 			case TokenIdent:
 				x = p.parseSelector(x)
+				x.(*SelectorExpr).Optional = opt
+				optional = optional || opt
 			default:
 				pos := p.pos
 				p.errorExpected(pos, "selector")
@@ -179,13 +251,30 @@ L:
 				return &BadExpr{From: pos, To: p.pos}
 			}
 		case TokenLBrack:
-			x = p.parseIndexOrSlice(x)
+			x = p.parseIndexOrSlice(x, false)
 		case TokenLParen:
 			x = p.parseCall(x)
+		case TokenQuestion:
+			if p.ternaryFollows() {
+				break L
+			}
+			pos := p.pos
+			p.next()
+			if p.token == TokenLBrack && p.pos == pos+1 {
+				// "?[" right after the operand is an optional index
+				x = p.parseIndexOrSlice(x, true)
+				optional = true
+			} else {
+				x = &PropagateExpr{Expr: x, QuestionPos: pos}
+			}
 		default:
 			break L
 		}
 	}
+	if optional {
+		// the whole chain short-circuits if any optional link is nil
+		x = &ChainExpr{Expr: x}
+	}
 	return x
 }
 
@@ -198,12 +287,35 @@ func (p *Parser) parseCall(x Expr) *CallExpr {
 	p.exprLevel++
 
 	var list []Expr
+	var named []*MapElementLit
 	var ellipsis Pos
-	for p.token != TokenRParen && p.token != TokenEOF && !ellipsis.IsValid() {
-		list = append(list, p.parseExpr())
+	for p.token != TokenRParen && p.token != TokenEOF {
+		var arg Expr
 		if p.token == TokenEllipsis {
-			ellipsis = p.pos
+			arg = p.parseSpreadExpr()
+		} else {
+			arg = p.parseExpr()
+		}
+		if p.token == TokenColon {
+			// named argument
+			colon := p.pos
 			p.next()
+			named = append(named, &MapElementLit{
+				Key:      p.paramIdent(arg).Name,
+				KeyPos:   arg.Pos(),
+				ColonPos: colon,
+				Value:    p.parseExpr(),
+			})
+		} else if len(named) > 0 {
+			p.error(arg.Pos(), "positional argument after named argument")
+		} else if ellipsis.IsValid() {
+			p.errorExpected(arg.Pos(), "')'")
+		} else {
+			list = append(list, arg)
+			if _, ok := arg.(*SpreadExpr); !ok && p.token == TokenEllipsis {
+				ellipsis = p.pos
+				p.next()
+			}
 		}
 		if !p.expectComma(TokenRParen, "call argument") {
 			break
@@ -213,11 +325,12 @@ func (p *Parser) parseCall(x Expr) *CallExpr {
 	p.exprLevel--
 	rparen := p.expect(TokenRParen)
 	return &CallExpr{
-		Func:     x,
-		LParen:   lparen,
-		RParen:   rparen,
-		Ellipsis: ellipsis,
-		Args:     list,
+		Func:      x,
+		LParen:    lparen,
+		RParen:    rparen,
+		Ellipsis:  ellipsis,
+		Args:      list,
+		NamedArgs: named,
 	}
 }
 
@@ -238,7 +351,7 @@ func (p *Parser) expectComma(closing Token, want string) bool {
 	return false
 }
 
-func (p *Parser) parseIndexOrSlice(x Expr) Expr {
+func (p *Parser) parseIndexOrSlice(x Expr, optional bool) Expr {
 	if p.trace {
 		defer untracep(tracep(p, "IndexOrSlice"))
 	}
@@ -266,18 +379,20 @@ func (p *Parser) parseIndexOrSlice(x Expr) Expr {
 	if numColons > 0 {
 		// slice expression
 		return &SliceExpr{
-			Expr:   x,
-			LBrack: lbrack,
-			RBrack: rbrack,
-			Low:    index[0],
-			High:   index[1],
+			Expr:     x,
+			LBrack:   lbrack,
+			RBrack:   rbrack,
+			Low:      index[0],
+			High:     index[1],
+			Optional: optional,
 		}
 	}
 	return &IndexExpr{
-		Expr:   x,
-		LBrack: lbrack,
-		RBrack: rbrack,
-		Index:  index[0],
+		Expr:     x,
+		LBrack:   lbrack,
+		RBrack:   rbrack,
+		Index:    index[0],
+		Optional: optional,
 	}
 }
 
@@ -301,7 +416,11 @@ func (p *Parser) parseOperand() Expr {
 
 	switch p.token {
 	case TokenIdent:
-		return p.parseIdent()
+		x := p.parseIdent()
+		if p.token == TokenArrow {
+			return p.parseArrowFunc(&IdentList{List: []*Ident{x}}, nil)
+		}
+		return x
 	case TokenInt:
 		v, _ := strconv.ParseInt(p.tokenLit, 10, 64)
 		x := &IntLit{
@@ -331,6 +450,8 @@ func (p *Parser) parseOperand() Expr {
 		}
 		p.next()
 		return x
+	case TokenInterpString:
+		return p.parseInterpStringLit()
 	case TokenTrue:
 		x := &BoolLit{
 			Value:    true,
@@ -356,10 +477,24 @@ func (p *Parser) parseOperand() Expr {
 	case TokenLParen:
 		lparen := p.pos
 		p.next()
+		if p.token == TokenRParen || p.token == TokenEllipsis {
+			return p.parseArrowFunc(p.parseArrowParams(lparen, nil))
+		}
 		p.exprLevel++
 		x := p.parseExpr()
 		p.exprLevel--
+		if p.token == TokenComma || p.token == TokenAssign ||
+			p.token == TokenColon {
+			return p.parseArrowFunc(p.parseArrowParams(lparen, x))
+		}
 		rparen := p.expect(TokenRParen)
+		if p.token == TokenArrow {
+			return p.parseArrowFunc(&IdentList{
+				LParen: lparen,
+				RParen: rparen,
+				List:   []*Ident{p.paramIdent(x)},
+			}, nil)
+		}
 		return &ParenExpr{
 			LParen: lparen,
 			Expr:   x,
@@ -367,12 +502,16 @@ func (p *Parser) parseOperand() Expr {
 		}
 	case TokenLBrack: // array literal
 		return p.parseArrayLit()
-	case TokenLBrace: // map literal
-		return p.parseMapLit()
+	case TokenLBrace: // map literal or comprehension
+		return p.parseMapExpr()
 	case TokenFunc: // function literal
 		return p.parseFuncLit()
+	case TokenImmutable: // immutable expression
+		return p.parseImmutableExpr()
 	case TokenError: // error expression
 		return p.parseErrorExpr()
+	case TokenMatch: // match expression
+		return p.parseMatchExpr()
 	}
 
 	pos := p.pos
@@ -381,6 +520,67 @@ func (p *Parser) parseOperand() Expr {
 	return &BadExpr{From: pos, To: p.pos}
 }
 
+func (p *Parser) parseMatchExpr() Expr {
+	if p.trace {
+		defer untracep(tracep(p, "MatchExpr"))
+	}
+
+	pos := p.expect(TokenMatch)
+	prevLevel := p.exprLevel
+	p.exprLevel = -1
+	subject := p.parseExpr()
+	p.exprLevel = prevLevel
+
+	lbrace := p.expect(TokenLBrace)
+	p.exprLevel++
+	var arms []*MatchArm
+	for p.token == TokenCase || p.token == TokenDefault {
+		arms = append(arms, p.parseMatchArm())
+		if p.token != TokenRBrace {
+			p.expectSemi()
+		}
+	}
+	p.exprLevel--
+	rbrace := p.expect(TokenRBrace)
+	return &MatchExpr{
+		MatchPos: pos,
+		Subject:  subject,
+		LBrace:   lbrace,
+		Arms:     arms,
+		RBrace:   rbrace,
+	}
+}
+
+func (p *Parser) parseMatchArm() *MatchArm {
+	if p.trace {
+		defer untracep(tracep(p, "MatchArm"))
+	}
+
+	pos := p.pos
+	var patterns []Expr
+	var guard Expr
+	if p.token == TokenCase {
+		p.next()
+		patterns = p.parseExprList()
+		if p.token == TokenIf {
+			p.next()
+			guard = p.parseExpr()
+		}
+	} else {
+		p.expect(TokenDefault)
+	}
+
+	colon := p.expect(TokenColon)
+	value := p.parseExpr()
+	return &MatchArm{
+		CasePos:  pos,
+		Patterns: patterns,
+		Guard:    guard,
+		Colon:    colon,
+		Value:    value,
+	}
+}
+
 func (p *Parser) parseImportExpr() Expr {
 	pos := p.pos
 	p.next()
@@ -442,6 +642,78 @@ func (p *Parser) parseFuncLit() Expr {
 	}
 }
 
+// parseArrowParams parses the parameters of an arrow function whose opening
+// parenthesis has already been consumed, and its result type annotation if
+// any. The first parameter may already have been parsed as an expression.
+func (p *Parser) parseArrowParams(
+	lparen Pos,
+	first Expr,
+) (params *IdentList, result Expr) {
+	if p.trace {
+		defer untracep(tracep(p, "ArrowParams"))
+	}
+
+	params = &IdentList{LParen: lparen}
+	if first != nil {
+		p.parseParam(params, p.paramIdent(first))
+		if p.token == TokenComma {
+			p.next()
+			p.parseParams(params)
+		}
+	} else if p.token != TokenRParen {
+		p.parseParams(params)
+	}
+	params.RParen = p.expect(TokenRParen)
+	if p.token == TokenColon {
+		p.next()
+		result = p.parseType()
+	}
+	if p.token != TokenArrow {
+		p.errorExpected(p.pos, "'=>'")
+	}
+	return params, result
+}
+
+// paramIdent returns the identifier of a parameter that was parsed as an
+// expression.
+func (p *Parser) paramIdent(x Expr) *Ident {
+	ident, ok := x.(*Ident)
+	if !ok {
+		p.errorExpected(x.Pos(), "identifier")
+		return &Ident{Name: "_", NamePos: x.Pos()}
+	}
+	return ident
+}
+
+// parseArrowFunc parses the body of an arrow function. An expression body is
+// the same as a block body returning that expression, so arrow functions are
+// represented as function literals.
+func (p *Parser) parseArrowFunc(params *IdentList, result Expr) Expr {
+	if p.trace {
+		defer untracep(tracep(p, "ArrowFunc"))
+	}
+
+	p.expect(TokenArrow)
+	typ := &FuncType{FuncPos: params.Pos(), Params: params, Result: result}
+	p.exprLevel++
+	var body *BlockStmt
+	if p.token == TokenLBrace {
+		body = p.parseBody()
+	} else {
+		x := p.parseExpr()
+		body = &BlockStmt{
+			Stmts:  []Stmt{&ReturnStmt{ReturnPos: x.Pos(), Result: x}},
+			LBrace: x.Pos(),
+			RBrace: x.End() - 1,
+		}
+	}
+	p.exprLevel--
+	return &FuncLit{
+		Type: typ,
+		Body: body,
+	}
+}
+
 func (p *Parser) parseArrayLit() Expr {
 	if p.trace {
 		defer untracep(tracep(p, "ArrayLit"))
@@ -452,7 +724,23 @@ func (p *Parser) parseArrayLit() Expr {
 
 	var elements []Expr
 	for p.token != TokenRBrack && p.token != TokenEOF {
-		elements = append(elements, p.parseExpr())
+		if p.token == TokenEllipsis {
+			elements = append(elements, p.parseSpreadExpr())
+		} else {
+			elem := p.parseExpr()
+			if p.token == TokenFor && len(elements) == 0 {
+				// [elem for x in iterable]
+				clause := p.parseCompClause()
+				p.exprLevel--
+				return &ArrayComp{
+					LBrack: lbrack,
+					Elem:   elem,
+					Clause: clause,
+					RBrack: p.expect(TokenRBrack),
+				}
+			}
+			elements = append(elements, elem)
+		}
 
 		if !p.expectComma(TokenRBrack, "array element") {
 			break
@@ -468,6 +756,112 @@ func (p *Parser) parseArrayLit() Expr {
 	}
 }
 
+func (p *Parser) parseSpreadExpr() *SpreadExpr {
+	if p.trace {
+		defer untracep(tracep(p, "SpreadExpr"))
+	}
+
+	pos := p.expect(TokenEllipsis)
+	return &SpreadExpr{
+		Ellipsis: pos,
+		Expr:     p.parseExpr(),
+	}
+}
+
+func (p *Parser) parseInterpStringLit() Expr {
+	if p.trace {
+		defer untracep(tracep(p, "InterpStringLit"))
+	}
+
+	pos, lit := p.pos, p.tokenLit
+	base := p.file.Offset(pos)
+
+	var parts []Expr
+	var text []byte // text with escapes, not unquoted yet
+	textStart := 2
+	addText := func(end int) {
+		if end > textStart {
+			v, err := strconv.Unquote(`"` + string(text) + `"`)
+			if err != nil {
+				p.error(pos+Pos(textStart), "invalid string literal")
+			}
+			parts = append(parts, &StringLit{
+				Value:    v,
+				ValuePos: pos + Pos(textStart),
+				Literal:  lit[textStart:end],
+			})
+		}
+		text = text[:0]
+	}
+
+	// $"text ${expr} text"
+	i := 2
+	for i < len(lit)-1 {
+		switch {
+		case lit[i] == '\\' && lit[i+1] == '$':
+			text = append(text, '$')
+			i += 2
+		case lit[i] == '\\':
+			text = append(text, lit[i], lit[i+1])
+			i += 2
+		case lit[i] == '$' && lit[i+1] == '{':
+			addText(i)
+			x, end := p.parseInterpExpr(base + i + 2)
+			if end < 0 {
+				i = len(lit)
+				break
+			}
+			parts = append(parts, x)
+			i = end - base + 1
+			textStart = i
+		default:
+			text = append(text, lit[i])
+			i++
+		}
+	}
+	addText(len(lit) - 1)
+
+	p.next()
+	return &InterpStringLit{
+		Parts:    parts,
+		ValuePos: pos,
+		Literal:  lit,
+	}
+}
+
+// parseInterpExpr parses the expression embedded in an interpolated string
+// literal, starting at the given file offset. It uses another parser on the
+// same source so that the expression has the correct source positions. It
+// returns the expression and the file offset of the closing '}', or -1 if the
+// expression is not closed.
+func (p *Parser) parseInterpExpr(offset int) (x Expr, end int) {
+	sub := &Parser{
+		file:     p.file,
+		errors:   p.errors,
+		trace:    p.trace,
+		traceOut: p.traceOut,
+		indent:   p.indent,
+	}
+	defer func() {
+		p.errors = sub.errors
+	}()
+
+	// errors in the literal are already reported by the main scanner
+	sub.scanner = NewScanner(p.file, p.scanner.src, nil, 0)
+	sub.scanner.seek(offset)
+	sub.next()
+
+	x = sub.parseExpr()
+	if sub.token == TokenSemicolon && sub.tokenLit == "\n" {
+		sub.next()
+	}
+	if sub.token != TokenRBrace {
+		sub.errorExpected(sub.pos, "'}'")
+		return x, -1
+	}
+	return x, p.file.Offset(sub.pos)
+}
+
 func (p *Parser) parseErrorExpr() Expr {
 	pos := p.pos
 
@@ -483,6 +877,21 @@ func (p *Parser) parseErrorExpr() Expr {
 	}
 }
 
+func (p *Parser) parseImmutableExpr() Expr {
+	pos := p.pos
+
+	p.next()
+	lparen := p.expect(TokenLParen)
+	value := p.parseExpr()
+	rparen := p.expect(TokenRParen)
+	return &ImmutableExpr{
+		ImmutablePos: pos,
+		Expr:         value,
+		LParen:       lparen,
+		RParen:       rparen,
+	}
+}
+
 func (p *Parser) parseFuncType() *FuncType {
 	if p.trace {
 		defer untracep(tracep(p, "FuncType"))
@@ -490,12 +899,133 @@ func (p *Parser) parseFuncType() *FuncType {
 
 	pos := p.expect(TokenFunc)
 	params := p.parseIdentList()
+	var result Expr
+	if p.token == TokenColon {
+		p.next()
+		result = p.parseType()
+	}
 	return &FuncType{
 		FuncPos: pos,
 		Params:  params,
+		Result:  result,
+	}
+}
+
+// parseType parses a type annotation. Type annotations are only verified by
+// the checker and have no effect on the compiled code.
+//
+//	int, string, any, nil, ...  named type
+//	[T]                         array of T
+//	{K: V}                      map from K to V
+//	func(T, ...U): R            function
+//	T | U                       union
+//	T?                          nullable, same as T | nil
+func (p *Parser) parseType() Expr {
+	if p.trace {
+		defer untracep(tracep(p, "Type"))
+	}
+
+	x := p.parseNullableType()
+	if p.token != TokenOr {
+		return x
+	}
+	union := &UnionType{Types: []Expr{x}}
+	for p.token == TokenOr {
+		p.next()
+		union.Types = append(union.Types, p.parseNullableType())
+	}
+	return union
+}
+
+func (p *Parser) parseNullableType() Expr {
+	x := p.parseTypeOperand()
+	for p.token == TokenQuestion {
+		x = &NullableType{Type: x, Question: p.pos}
+		p.next()
+	}
+	return x
+}
+
+func (p *Parser) parseTypeOperand() Expr {
+	if p.trace {
+		defer untracep(tracep(p, "TypeOperand"))
+	}
+
+	switch p.token {
+	case TokenIdent:
+		return p.parseIdent()
+	case TokenNil:
+		x := &Ident{Name: "nil", NamePos: p.pos}
+		p.next()
+		return x
+	case TokenLBrack:
+		lbrack := p.pos
+		p.next()
+		elem := p.parseType()
+		return &ArrayType{
+			LBrack: lbrack,
+			Elem:   elem,
+			RBrack: p.expect(TokenRBrack),
+		}
+	case TokenLBrace:
+		lbrace := p.pos
+		p.next()
+		key := p.parseType()
+		p.expect(TokenColon)
+		value := p.parseType()
+		return &MapType{
+			LBrace: lbrace,
+			Key:    key,
+			Value:  value,
+			RBrace: p.expect(TokenRBrace),
+		}
+	case TokenFunc:
+		return p.parseFuncSigType()
+	case TokenLParen:
+		lparen := p.pos
+		p.next()
+		x := p.parseType()
+		return &ParenExpr{
+			LParen: lparen,
+			Expr:   x,
+			RParen: p.expect(TokenRParen),
+		}
+	}
+
+	pos := p.pos
+	p.errorExpected(pos, "type")
+	p.advance(stmtStart)
+	return &BadExpr{
+		From: pos,
+		To:   p.pos,
 	}
 }
 
+func (p *Parser) parseFuncSigType() Expr {
+	if p.trace {
+		defer untracep(tracep(p, "FuncSigType"))
+	}
+
+	typ := &FuncSigType{FuncPos: p.expect(TokenFunc)}
+	typ.LParen = p.expect(TokenLParen)
+	for p.token != TokenRParen && p.token != TokenEOF {
+		if p.token == TokenEllipsis {
+			typ.VarArgs = true
+			p.next()
+		}
+		typ.Params = append(typ.Params, p.parseType())
+		if typ.VarArgs || !p.expectComma(TokenRParen, "parameter type") {
+			break
+		}
+	}
+	typ.RParen = p.expect(TokenRParen)
+	if p.token == TokenColon {
+		p.next()
+		typ.Result = p.parseType()
+	}
+	return typ
+}
+
 func (p *Parser) parseBody() *BlockStmt {
 	if p.trace {
 		defer untracep(tracep(p, "Body"))
@@ -516,7 +1046,8 @@ func (p *Parser) parseStmtList() (list []Stmt) {
 		defer untracep(tracep(p, "StatementList"))
 	}
 
-	for p.token != TokenRBrace && p.token != TokenEOF {
+	for p.token != TokenCase && p.token != TokenDefault &&
+		p.token != TokenRBrace && p.token != TokenEOF {
 		list = append(list, p.parseStmt())
 	}
 	return
@@ -543,33 +1074,52 @@ func (p *Parser) parseIdentList() *IdentList {
 		defer untracep(tracep(p, "IdentList"))
 	}
 
-	var params []*Ident
-	lparen := p.expect(TokenLParen)
-	isVarArgs := false
+	params := &IdentList{LParen: p.expect(TokenLParen)}
 	if p.token != TokenRParen {
+		p.parseParams(params)
+	}
+	params.RParen = p.expect(TokenRParen)
+	return params
+}
+
+// parseParams parses a comma separated list of parameters into params. The
+// last parameter may be variadic.
+func (p *Parser) parseParams(params *IdentList) {
+	for {
 		if p.token == TokenEllipsis {
-			isVarArgs = true
+			params.VarArgs = true
 			p.next()
 		}
-
-		params = append(params, p.parseIdent())
-		for !isVarArgs && p.token == TokenComma {
-			p.next()
-			if p.token == TokenEllipsis {
-				isVarArgs = true
-				p.next()
-			}
-			params = append(params, p.parseIdent())
+		p.parseParam(params, p.parseIdent())
+		if params.VarArgs || p.token != TokenComma {
+			return
 		}
+		p.next()
 	}
+}
 
-	rparen := p.expect(TokenRParen)
-	return &IdentList{
-		LParen:  lparen,
-		RParen:  rparen,
-		VarArgs: isVarArgs,
-		List:    params,
+// parseParam adds the parameter to params, followed by its type annotation
+// and default value if any.
+func (p *Parser) parseParam(params *IdentList, ident *Ident) {
+	params.List = append(params.List, ident)
+	if p.token == TokenColon {
+		p.next()
+		for len(params.Types) < len(params.List)-1 {
+			params.Types = append(params.Types, nil)
+		}
+		params.Types = append(params.Types, p.parseType())
+	}
+	if p.token != TokenAssign {
+		return
+	}
+	if params.VarArgs {
+		p.error(p.pos, "variadic parameter cannot have a default value")
+	}
+	p.next()
+	for len(params.Defaults) < len(params.List)-1 {
+		params.Defaults = append(params.Defaults, nil)
 	}
+	params.Defaults = append(params.Defaults, p.parseExpr())
 }
 
 func (p *Parser) parseStmt() (stmt Stmt) {
@@ -579,23 +1129,50 @@ func (p *Parser) parseStmt() (stmt Stmt) {
 
 	switch p.token {
 	case // simple statements
-		TokenFunc, TokenError, TokenIdent, TokenInt,
+		TokenFunc, TokenError, TokenImmutable, TokenIdent, TokenInt,
 		TokenFloat, TokenChar, TokenString, TokenTrue, TokenFalse,
 		TokenNil, TokenImport, TokenLParen, TokenLBrace,
 		TokenLBrack, TokenAdd, TokenSub, TokenMul, TokenAnd, TokenXor,
-		TokenNot:
+		TokenNot, TokenMatch:
 		s := p.parseSimpleStmt(false)
+		if x, ok := s.(*ExprStmt); ok && p.token == TokenColon {
+			if ident, ok := x.Expr.(*Ident); ok {
+				colon := p.pos
+				p.next()
+				switch p.token {
+				case TokenIdent, TokenNil, TokenLBrack, TokenLBrace,
+					TokenFunc, TokenLParen:
+					// x: T := value
+					return p.parseTypedDefineStmt(ident)
+				}
+				return p.parseLabeledStmt(ident, colon)
+			}
+		}
 		p.expectSemi()
 		return s
 	case TokenReturn:
 		return p.parseReturnStmt()
 	case TokenExport:
 		return p.parseExportStmt()
+	case TokenConst:
+		return p.parseConstStmt()
+	case TokenYield:
+		return p.parseYieldStmt()
+	case TokenClass:
+		return p.parseClassStmt()
 	case TokenIf:
 		return p.parseIfStmt()
 	case TokenFor:
 		return p.parseForStmt()
-	case TokenBreak, TokenContinue:
+	case TokenSwitch:
+		return p.parseSwitchStmt()
+	case TokenTry:
+		return p.parseTryStmt()
+	case TokenThrow:
+		return p.parseThrowStmt()
+	case TokenDefer:
+		return p.parseDeferStmt()
+	case TokenBreak, TokenContinue, TokenFallthrough:
 		return p.parseBranchStmt(p.token)
 	case TokenSemicolon:
 		s := &EmptyStmt{Semicolon: p.pos, Implicit: p.tokenLit == "\n"}
@@ -688,7 +1265,7 @@ func (p *Parser) parseBranchStmt(tok Token) Stmt {
 	pos := p.expect(tok)
 
 	var label *Ident
-	if p.token == TokenIdent {
+	if tok != TokenFallthrough && p.token == TokenIdent {
 		label = p.parseIdent()
 	}
 	p.expectSemi()
@@ -699,6 +1276,111 @@ func (p *Parser) parseBranchStmt(tok Token) Stmt {
 	}
 }
 
+func (p *Parser) parseLabeledStmt(label *Ident, colon Pos) Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "LabeledStmt"))
+	}
+
+	return &LabeledStmt{
+		Label: label,
+		Colon: colon,
+		Stmt:  p.parseStmt(),
+	}
+}
+
+// parseTypedDefineStmt parses the rest of a variable definition with a type
+// annotation, x: T := value, after the colon following the identifier.
+func (p *Parser) parseTypedDefineStmt(ident *Ident) Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "TypedDefineStmt"))
+	}
+
+	typ := p.parseType()
+	pos := p.expect(TokenDefine)
+	value := p.parseExpr()
+	p.expectSemi()
+	return &AssignStmt{
+		LHS:      []Expr{ident},
+		RHS:      []Expr{value},
+		Token:    TokenDefine,
+		TokenPos: pos,
+		Type:     typ,
+	}
+}
+
+func (p *Parser) parseSwitchStmt() Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "SwitchStmt"))
+	}
+
+	pos := p.expect(TokenSwitch)
+
+	// switch {}                  or
+	// switch tag {}              or
+	// switch init; {}            or
+	// switch init; tag {}
+	var s1, s2 Stmt
+	if p.token != TokenLBrace {
+		prevLevel := p.exprLevel
+		p.exprLevel = -1
+		if p.token != TokenSemicolon {
+			s2 = p.parseSimpleStmt(false)
+		}
+		if p.token == TokenSemicolon {
+			p.next()
+			s1 = s2
+			s2 = nil
+			if p.token != TokenLBrace {
+				s2 = p.parseSimpleStmt(false)
+			}
+		}
+		p.exprLevel = prevLevel
+	}
+	tag := p.makeExpr(s2, "switch expression")
+
+	lbrace := p.expect(TokenLBrace)
+	var list []Stmt
+	for p.token == TokenCase || p.token == TokenDefault {
+		list = append(list, p.parseCaseClause())
+	}
+	rbrace := p.expect(TokenRBrace)
+	p.expectSemi()
+	return &SwitchStmt{
+		SwitchPos: pos,
+		Init:      s1,
+		Tag:       tag,
+		Body: &BlockStmt{
+			LBrace: lbrace,
+			RBrace: rbrace,
+			Stmts:  list,
+		},
+	}
+}
+
+func (p *Parser) parseCaseClause() *CaseClause {
+	if p.trace {
+		defer untracep(tracep(p, "CaseClause"))
+	}
+
+	pos := p.pos
+	var list []Expr
+	if p.token == TokenCase {
+		p.next()
+		list = p.parseExprList()
+	} else {
+		p.expect(TokenDefault)
+	}
+
+	colon := p.expect(TokenColon)
+	body := p.parseStmtList()
+	return &CaseClause{
+		CasePos: pos,
+		List:    list,
+		Colon:   colon,
+		Body:    body,
+	}
+}
+
 func (p *Parser) parseIfStmt() Stmt {
 	if p.trace {
 		defer untracep(tracep(p, "IfStmt"))
@@ -813,7 +1495,16 @@ func (p *Parser) parseReturnStmt() Stmt {
 
 	var x Expr
 	if p.token != TokenSemicolon && p.token != TokenRBrace {
-		x = p.parseExpr()
+		// multiple values are returned as an array
+		list := p.parseExprList()
+		x = list[0]
+		if len(list) > 1 {
+			x = &ArrayLit{
+				Elements: list,
+				LBrack:   list[0].Pos(),
+				RBrack:   list[len(list)-1].End() - 1,
+			}
+		}
 	}
 	p.expectSemi()
 	return &ReturnStmt{
@@ -822,6 +1513,126 @@ func (p *Parser) parseReturnStmt() Stmt {
 	}
 }
 
+func (p *Parser) parseYieldStmt() Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "YieldStmt"))
+	}
+
+	stmt := &YieldStmt{YieldPos: p.expect(TokenYield)}
+	if p.token != TokenSemicolon && p.token != TokenRBrace {
+		list := p.parseExprList()
+		switch len(list) {
+		case 1:
+			stmt.Value = list[0]
+		case 2:
+			stmt.Key, stmt.Value = list[0], list[1]
+		default:
+			p.errorExpected(list[0].Pos(), "1 or 2 expressions")
+		}
+	}
+	p.expectSemi()
+	return stmt
+}
+
+func (p *Parser) parseTryStmt() Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "TryStmt"))
+	}
+
+	pos := p.expect(TokenTry)
+	body := p.parseBlockStmt()
+
+	stmt := &TryStmt{
+		TryPos: pos,
+		Body:   body,
+	}
+	if p.token == TokenCatch {
+		stmt.CatchPos = p.pos
+		p.next()
+		if p.token == TokenIdent {
+			stmt.CatchIdent = p.parseIdent()
+		}
+		stmt.Catch = p.parseBlockStmt()
+	}
+	if p.token == TokenFinally {
+		stmt.FinallyPos = p.pos
+		p.next()
+		stmt.Finally = p.parseBlockStmt()
+	}
+	if stmt.Catch == nil && stmt.Finally == nil {
+		p.errorExpected(p.pos, "catch or finally")
+	}
+	p.expectSemi()
+	return stmt
+}
+
+func (p *Parser) parseThrowStmt() Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "ThrowStmt"))
+	}
+
+	pos := p.expect(TokenThrow)
+	x := p.parseExpr()
+	p.expectSemi()
+	return &ThrowStmt{
+		ThrowPos: pos,
+		Expr:     x,
+	}
+}
+
+func (p *Parser) parseDeferStmt() Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "DeferStmt"))
+	}
+
+	pos := p.expect(TokenDefer)
+	x := p.parseExpr()
+	p.expectSemi()
+	call, isCall := x.(*CallExpr)
+	if !isCall {
+		p.error(x.Pos(), "expression in defer must be function call")
+		return &BadStmt{From: pos, To: p.safePos(x.End())}
+	}
+	return &DeferStmt{
+		DeferPos: pos,
+		Call:     call,
+	}
+}
+
+func (p *Parser) parseClassStmt() Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "ClassStmt"))
+	}
+
+	pos := p.expect(TokenClass)
+	name := p.parseIdent()
+	body := p.parseMapLit()
+	p.expectSemi()
+	return &ClassStmt{
+		ClassPos: pos,
+		Name:     name,
+		Body:     body,
+	}
+}
+
+func (p *Parser) parseConstStmt() *ConstStmt {
+	if p.trace {
+		defer untracep(tracep(p, "ConstStmt"))
+	}
+
+	pos := p.expect(TokenConst)
+	name := p.parseIdent()
+	assign := p.expect(TokenAssign)
+	value := p.parseExpr()
+	p.expectSemi()
+	return &ConstStmt{
+		ConstPos:  pos,
+		Name:      name,
+		AssignPos: assign,
+		Value:     value,
+	}
+}
+
 func (p *Parser) parseExportStmt() Stmt {
 	if p.trace {
 		defer untracep(tracep(p, "ExportStmt"))
@@ -829,6 +1640,12 @@ func (p *Parser) parseExportStmt() Stmt {
 
 	pos := p.pos
 	p.expect(TokenExport)
+	if p.token == TokenConst {
+		return &ExportStmt{
+			ExportPos: pos,
+			Decl:      p.parseConstStmt(),
+		}
+	}
 	x := p.parseExpr()
 	p.expectSemi()
 	return &ExportStmt{
@@ -937,17 +1754,38 @@ func (p *Parser) parseMapElementLit() *MapElementLit {
 		defer untracep(tracep(p, "MapElementLit"))
 	}
 
+	if p.token == TokenEllipsis {
+		// {...m} sets the elements of m
+		spread := p.parseSpreadExpr()
+		return &MapElementLit{
+			KeyPos: spread.Pos(),
+			Value:  spread,
+		}
+	}
+
 	pos := p.pos
 	name := "_"
 	if p.token == TokenIdent {
 		name = p.tokenLit
-	} else if p.token == TokenString {
-		v, _ := strconv.Unquote(p.tokenLit)
-		name = v
+		p.next()
+
+		// {name} is a shorthand for {name: name}
+		if p.token == TokenComma || p.token == TokenRBrace {
+			return &MapElementLit{
+				Key:    name,
+				KeyPos: pos,
+				Value:  &Ident{Name: name, NamePos: pos},
+			}
+		}
 	} else {
-		p.errorExpected(pos, "map key")
+		if p.token == TokenString {
+			v, _ := strconv.Unquote(p.tokenLit)
+			name = v
+		} else {
+			p.errorExpected(pos, "map key")
+		}
+		p.next()
 	}
-	p.next()
 	colonPos := p.expect(TokenColon)
 	valueExpr := p.parseExpr()
 	return &MapElementLit{
@@ -965,16 +1803,85 @@ func (p *Parser) parseMapLit() *MapLit {
 
 	lbrace := p.expect(TokenLBrace)
 	p.exprLevel++
+	return p.parseMapElements(lbrace, nil)
+}
 
-	var elements []*MapElementLit
-	for p.token != TokenRBrace && p.token != TokenEOF {
-		elements = append(elements, p.parseMapElementLit())
+// parseMapExpr parses a map literal, or a map comprehension if the first
+// element is followed by a for clause. As the key of a comprehension is an
+// expression, the first key is parsed as an expression.
+func (p *Parser) parseMapExpr() Expr {
+	if p.trace {
+		defer untracep(tracep(p, "MapExpr"))
+	}
 
-		if !p.expectComma(TokenRBrace, "map element") {
-			break
+	lbrace := p.expect(TokenLBrace)
+	p.exprLevel++
+	if p.token == TokenRBrace || p.token == TokenEOF ||
+		p.token == TokenEllipsis {
+		return p.parseMapElements(lbrace, nil)
+	}
+
+	key := p.parseExpr()
+	if ident, ok := key.(*Ident); ok &&
+		(p.token == TokenComma || p.token == TokenRBrace) {
+		// {name} is a shorthand for {name: name}
+		elem := &MapElementLit{
+			Key:    ident.Name,
+			KeyPos: ident.NamePos,
+			Value:  ident,
+		}
+		return p.parseMapElements(lbrace, elem)
+	}
+	colonPos := p.expect(TokenColon)
+	value := p.parseExpr()
+	if p.token == TokenFor {
+		// {key: value for x in iterable}
+		clause := p.parseCompClause()
+		p.exprLevel--
+		return &MapComp{
+			LBrace: lbrace,
+			Key:    key,
+			Colon:  colonPos,
+			Value:  value,
+			Clause: clause,
+			RBrace: p.expect(TokenRBrace),
 		}
 	}
 
+	elem := &MapElementLit{
+		Key:      "_",
+		KeyPos:   key.Pos(),
+		ColonPos: colonPos,
+		Value:    value,
+	}
+	switch key := key.(type) {
+	case *Ident:
+		elem.Key = key.Name
+	case *StringLit:
+		elem.Key = key.Value
+	default:
+		p.errorExpected(key.Pos(), "map key")
+	}
+	return p.parseMapElements(lbrace, elem)
+}
+
+// parseMapElements parses the rest of the elements of a map literal after the
+// first element, if it's not nil.
+func (p *Parser) parseMapElements(
+	lbrace Pos,
+	first *MapElementLit,
+) *MapLit {
+	var elements []*MapElementLit
+	more := true
+	if first != nil {
+		elements = append(elements, first)
+		more = p.expectComma(TokenRBrace, "map element")
+	}
+	for more && p.token != TokenRBrace && p.token != TokenEOF {
+		elements = append(elements, p.parseMapElementLit())
+		more = p.expectComma(TokenRBrace, "map element")
+	}
+
 	p.exprLevel--
 	rbrace := p.expect(TokenRBrace)
 	return &MapLit{
@@ -984,6 +1891,32 @@ func (p *Parser) parseMapLit() *MapLit {
 	}
 }
 
+// parseCompClause parses the clause of a comprehension:
+// for [key,] value in iterable [if cond].
+func (p *Parser) parseCompClause() *CompClause {
+	if p.trace {
+		defer untracep(tracep(p, "CompClause"))
+	}
+
+	pos := p.expect(TokenFor)
+	clause := &CompClause{ForPos: pos}
+	if forIn, ok := p.parseSimpleStmt(true).(*ForInStmt); ok {
+		clause.Key = forIn.Key
+		clause.Value = forIn.Value
+		clause.Iterable = forIn.Iterable
+	} else {
+		p.errorExpected(pos, "for-in clause")
+		clause.Key = &Ident{Name: "_", NamePos: pos}
+		clause.Value = &Ident{Name: "_", NamePos: pos}
+		clause.Iterable = &BadExpr{From: pos, To: p.pos}
+	}
+	if p.token == TokenIf {
+		p.next()
+		clause.Cond = p.parseExpr()
+	}
+	return clause
+}
+
 func (p *Parser) expect(token Token) Pos {
 	pos := p.pos
 
@@ -88,7 +88,8 @@ func (s *Scanner) Scan() (
 		tok = Lookup(literal)
 		switch tok {
 		case TokenIdent, TokenBreak, TokenContinue, TokenReturn,
-			TokenExport, TokenTrue, TokenFalse, TokenNil:
+			TokenExport, TokenTrue, TokenFalse, TokenNil,
+			TokenFallthrough:
 			insertSemi = true
 		}
 	case '0' <= ch && ch <= '9':
@@ -120,6 +121,17 @@ func (s *Scanner) Scan() (
 			insertSemi = true
 			tok = TokenString
 			literal = s.scanRawString()
+		case '$':
+			if s.ch != '"' {
+				s.error(s.file.Offset(pos), "illegal character U+0024 '$'")
+				insertSemi = s.insertSemi // preserve insertSemi info
+				tok = TokenIllegal
+				literal = "$"
+				break
+			}
+			insertSemi = true
+			tok = TokenInterpString
+			literal = s.scanInterpString()
 		case ':':
 			tok = s.switch2(TokenColon, TokenDefine)
 		case '.':
@@ -128,16 +140,35 @@ func (s *Scanner) Scan() (
 				tok, literal = s.scanNumber(true)
 			} else {
 				tok = TokenPeriod
-				if s.ch == '.' && s.peek() == '.' {
+				if s.ch == '.' {
 					s.next()
-					s.next() // consume last '.'
-					tok = TokenEllipsis
+					tok = TokenRange
+					switch s.ch {
+					case '.':
+						s.next() // consume last '.'
+						tok = TokenEllipsis
+					case '<':
+						s.next()
+						tok = TokenRangeExcl
+					}
 				}
 			}
 		case ',':
 			tok = TokenComma
 		case '?':
-			tok = TokenQuestion
+			switch {
+			case s.ch == '?':
+				s.next()
+				tok = TokenCoalesce
+			case s.ch == '.' && !isDigit(rune(s.peek())):
+				s.next()
+				tok = TokenOptPeriod
+			default:
+				// the parser tells the postfix '?' from the ternary
+				// conditional by whether a ':' follows
+				insertSemi = true
+				tok = TokenQuestion
+			}
 		case ';':
 			tok = TokenSemicolon
 			literal = ";"
@@ -201,7 +232,7 @@ func (s *Scanner) Scan() (
 			tok = s.switch4(TokenGreater, TokenGreaterEq, '>',
 				TokenShr, TokenShrAssign)
 		case '=':
-			tok = s.switch2(TokenAssign, TokenEqual)
+			tok = s.switch3(TokenAssign, TokenEqual, '>', TokenArrow)
 		case '!':
 			tok = s.switch2(TokenNot, TokenNotEqual)
 		case '&':
@@ -437,7 +468,8 @@ func (s *Scanner) scanNumber(
 	s.scanMantissa(10)
 
 fraction:
-	if s.ch == '.' {
+	// a '.' followed by another is a range operator, e.g. 1..5
+	if s.ch == '.' && s.peek() != '.' {
 		tok = TokenFloat
 		s.next()
 		s.scanMantissa(10)
@@ -566,6 +598,73 @@ func (s *Scanner) scanString() string {
 	return string(s.src[offs:s.offset])
 }
 
+func (s *Scanner) scanInterpString() string {
+	offs := s.offset - 1 // '$' opening already consumed
+	s.next()             // '"'
+
+	for {
+		ch := s.ch
+		if ch == '\n' || ch < 0 {
+			s.error(offs, "string literal not terminated")
+			break
+		}
+		s.next()
+		if ch == '"' {
+			break
+		}
+		if ch == '\\' {
+			if s.ch == '$' {
+				s.next()
+			} else {
+				s.scanEscape('"')
+			}
+		} else if ch == '$' && s.ch == '{' {
+			s.next()
+			s.skipInterpExpr()
+		}
+	}
+	return string(s.src[offs:s.offset])
+}
+
+// skipInterpExpr skips the expression embedded in an interpolated string
+// literal, up to and including the closing '}'. The expression itself is
+// parsed later by the parser.
+func (s *Scanner) skipInterpExpr() {
+	// '${' already consumed
+	depth := 0
+	for s.ch >= 0 {
+		ch := s.ch
+		s.next()
+		switch ch {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return
+			}
+			depth--
+		case '"':
+			s.scanString()
+		case '`':
+			s.scanRawString()
+		case '\'':
+			s.scanRune()
+		case '$':
+			if s.ch == '"' {
+				s.scanInterpString()
+			}
+		}
+	}
+}
+
+// seek moves the scanner to the given offset of the source.
+func (s *Scanner) seek(offset int) {
+	s.ch = ' '
+	s.readOffset = offset
+	s.insertSemi = false
+	s.next()
+}
+
 func (s *Scanner) scanRawString() string {
 	offs := s.offset - 1 // '`' opening already consumed
 
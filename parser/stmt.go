@@ -16,6 +16,7 @@ type AssignStmt struct {
 	RHS      []Expr
 	Token    Token
 	TokenPos Pos
+	Type     Expr // type annotation of the defined variable, nil if none
 }
 
 func (s *AssignStmt) stmtNode() {}
@@ -38,6 +39,9 @@ func (s *AssignStmt) String() string {
 	for _, e := range s.RHS {
 		rhs = append(rhs, e.String())
 	}
+	if s.Type != nil {
+		lhs[0] += ": " + s.Type.String()
+	}
 	return strings.Join(lhs, ", ") + " " + s.Token.String() +
 		" " + strings.Join(rhs, ", ")
 }
@@ -122,6 +126,115 @@ func (s *BranchStmt) String() string {
 	return s.Token.String() + label
 }
 
+// CaseClause represents a case of a switch statement.
+type CaseClause struct {
+	CasePos Pos
+	List    []Expr // list of expressions; nil means default case
+	Colon   Pos
+	Body    []Stmt
+}
+
+func (s *CaseClause) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *CaseClause) Pos() Pos {
+	return s.CasePos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *CaseClause) End() Pos {
+	if n := len(s.Body); n > 0 {
+		return s.Body[n-1].End()
+	}
+	return s.Colon + 1
+}
+
+func (s *CaseClause) String() string {
+	var list, body []string
+	for _, e := range s.List {
+		list = append(list, e.String())
+	}
+	for _, e := range s.Body {
+		body = append(body, e.String())
+	}
+	if s.List == nil {
+		return "default: " + strings.Join(body, "; ")
+	}
+	return "case " + strings.Join(list, ", ") + ": " +
+		strings.Join(body, "; ")
+}
+
+// ClassStmt represents a class declaration. The elements of the body with a
+// function literal value are the methods, and the others are the fields with
+// their default values.
+type ClassStmt struct {
+	ClassPos Pos
+	Name     *Ident
+	Body     *MapLit
+}
+
+func (s *ClassStmt) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *ClassStmt) Pos() Pos {
+	return s.ClassPos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *ClassStmt) End() Pos {
+	return s.Body.End()
+}
+
+func (s *ClassStmt) String() string {
+	return "class " + s.Name.String() + " " + s.Body.String()
+}
+
+// ConstStmt represents a constant declaration.
+type ConstStmt struct {
+	ConstPos  Pos
+	Name      *Ident
+	AssignPos Pos
+	Value     Expr
+}
+
+func (s *ConstStmt) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *ConstStmt) Pos() Pos {
+	return s.ConstPos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *ConstStmt) End() Pos {
+	return s.Value.End()
+}
+
+func (s *ConstStmt) String() string {
+	return "const " + s.Name.String() + " = " + s.Value.String()
+}
+
+// DeferStmt represents a defer statement.
+type DeferStmt struct {
+	DeferPos Pos
+	Call     *CallExpr
+}
+
+func (s *DeferStmt) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *DeferStmt) Pos() Pos {
+	return s.DeferPos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *DeferStmt) End() Pos {
+	return s.Call.End()
+}
+
+func (s *DeferStmt) String() string {
+	return "defer " + s.Call.String()
+}
+
 // EmptyStmt represents an empty statement.
 type EmptyStmt struct {
 	Semicolon Pos
@@ -151,6 +264,7 @@ func (s *EmptyStmt) String() string {
 type ExportStmt struct {
 	ExportPos Pos
 	Result    Expr
+	Decl      *ConstStmt // exported constant declaration, if Result is nil
 }
 
 func (s *ExportStmt) stmtNode() {}
@@ -162,10 +276,16 @@ func (s *ExportStmt) Pos() Pos {
 
 // End returns the position of first character immediately after the node.
 func (s *ExportStmt) End() Pos {
+	if s.Decl != nil {
+		return s.Decl.End()
+	}
 	return s.Result.End()
 }
 
 func (s *ExportStmt) String() string {
+	if s.Decl != nil {
+		return "export " + s.Decl.String()
+	}
 	return "export " + s.Result.String()
 }
 
@@ -318,6 +438,29 @@ func (s *IncDecStmt) String() string {
 	return s.Expr.String() + s.Token.String()
 }
 
+// LabeledStmt represents a labeled statement.
+type LabeledStmt struct {
+	Label *Ident
+	Colon Pos
+	Stmt  Stmt
+}
+
+func (s *LabeledStmt) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *LabeledStmt) Pos() Pos {
+	return s.Label.Pos()
+}
+
+// End returns the position of first character immediately after the node.
+func (s *LabeledStmt) End() Pos {
+	return s.Stmt.End()
+}
+
+func (s *LabeledStmt) String() string {
+	return s.Label.String() + ": " + s.Stmt.String()
+}
+
 // ReturnStmt represents a return statement.
 type ReturnStmt struct {
 	ReturnPos Pos
@@ -345,3 +488,132 @@ func (s *ReturnStmt) String() string {
 	}
 	return "return"
 }
+
+// SwitchStmt represents a switch statement.
+type SwitchStmt struct {
+	SwitchPos Pos
+	Init      Stmt
+	Tag       Expr       // tag expression; or nil
+	Body      *BlockStmt // CaseClauses only
+}
+
+func (s *SwitchStmt) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *SwitchStmt) Pos() Pos {
+	return s.SwitchPos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *SwitchStmt) End() Pos {
+	return s.Body.End()
+}
+
+func (s *SwitchStmt) String() string {
+	var initStmt, tag string
+	if s.Init != nil {
+		initStmt = s.Init.String() + "; "
+	}
+	if s.Tag != nil {
+		tag = s.Tag.String() + " "
+	}
+	return "switch " + initStmt + tag + s.Body.String()
+}
+
+// ThrowStmt represents a throw statement.
+type ThrowStmt struct {
+	ThrowPos Pos
+	Expr     Expr
+}
+
+func (s *ThrowStmt) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *ThrowStmt) Pos() Pos {
+	return s.ThrowPos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *ThrowStmt) End() Pos {
+	return s.Expr.End()
+}
+
+func (s *ThrowStmt) String() string {
+	return "throw " + s.Expr.String()
+}
+
+// TryStmt represents a try statement.
+type TryStmt struct {
+	TryPos     Pos
+	Body       *BlockStmt
+	CatchPos   Pos
+	CatchIdent *Ident     // catch variable; or nil
+	Catch      *BlockStmt // catch block; or nil
+	FinallyPos Pos
+	Finally    *BlockStmt // finally block; or nil
+}
+
+func (s *TryStmt) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *TryStmt) Pos() Pos {
+	return s.TryPos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *TryStmt) End() Pos {
+	if s.Finally != nil {
+		return s.Finally.End()
+	}
+	if s.Catch != nil {
+		return s.Catch.End()
+	}
+	return s.Body.End()
+}
+
+func (s *TryStmt) String() string {
+	str := "try " + s.Body.String()
+	if s.Catch != nil {
+		str += " catch "
+		if s.CatchIdent != nil {
+			str += s.CatchIdent.String() + " "
+		}
+		str += s.Catch.String()
+	}
+	if s.Finally != nil {
+		str += " finally " + s.Finally.String()
+	}
+	return str
+}
+
+// YieldStmt represents a yield statement.
+type YieldStmt struct {
+	YieldPos Pos
+	Key      Expr // explicit key; or nil
+	Value    Expr // yielded value; or nil
+}
+
+func (s *YieldStmt) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *YieldStmt) Pos() Pos {
+	return s.YieldPos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *YieldStmt) End() Pos {
+	if s.Value != nil {
+		return s.Value.End()
+	}
+	return s.YieldPos + 5
+}
+
+func (s *YieldStmt) String() string {
+	if s.Key != nil {
+		return "yield " + s.Key.String() + ", " + s.Value.String()
+	}
+	if s.Value != nil {
+		return "yield " + s.Value.String()
+	}
+	return "yield"
+}
@@ -18,6 +18,7 @@ const (
 	TokenFloat
 	TokenChar
 	TokenString
+	TokenInterpString
 	Token_literalEnd
 	Token_operatorBeg
 	TokenAdd          // +
@@ -67,6 +68,11 @@ const (
 	TokenSemicolon    // ;
 	TokenColon        // :
 	TokenQuestion     // ?
+	TokenCoalesce     // ??
+	TokenOptPeriod    // ?.
+	TokenRange        // ..
+	TokenRangeExcl    // ..<
+	TokenArrow        // =>
 	Token_operatorEnd
 	Token_keywordBeg
 	TokenBreak
@@ -83,6 +89,20 @@ const (
 	TokenIn
 	TokenNil
 	TokenImport
+	TokenSwitch
+	TokenCase
+	TokenDefault
+	TokenFallthrough
+	TokenTry
+	TokenCatch
+	TokenFinally
+	TokenThrow
+	TokenDefer
+	TokenImmutable
+	TokenConst
+	TokenYield
+	TokenClass
+	TokenMatch
 	Token_keywordEnd
 )
 
@@ -95,6 +115,7 @@ var tokens = [...]string{
 	TokenFloat:        "FLOAT",
 	TokenChar:         "CHAR",
 	TokenString:       "STRING",
+	TokenInterpString: "INTERP_STRING",
 	TokenAdd:          "+",
 	TokenSub:          "-",
 	TokenMul:          "*",
@@ -142,6 +163,11 @@ var tokens = [...]string{
 	TokenSemicolon:    ";",
 	TokenColon:        ":",
 	TokenQuestion:     "?",
+	TokenCoalesce:     "??",
+	TokenOptPeriod:    "?.",
+	TokenRange:        "..",
+	TokenRangeExcl:    "..<",
+	TokenArrow:        "=>",
 	TokenBreak:        "break",
 	TokenContinue:     "continue",
 	TokenElse:         "else",
@@ -156,6 +182,20 @@ var tokens = [...]string{
 	TokenIn:           "in",
 	TokenNil:    	   "nil",
 	TokenImport:       "import",
+	TokenSwitch:       "switch",
+	TokenCase:         "case",
+	TokenDefault:      "default",
+	TokenFallthrough:  "fallthrough",
+	TokenTry:          "try",
+	TokenCatch:        "catch",
+	TokenFinally:      "finally",
+	TokenThrow:        "throw",
+	TokenDefer:        "defer",
+	TokenImmutable:    "immutable",
+	TokenConst:        "const",
+	TokenYield:        "yield",
+	TokenClass:        "class",
+	TokenMatch:        "match",
 }
 
 func (tok Token) String() string {
@@ -178,16 +218,20 @@ const LowestPrec = 0
 // Precedence returns the precedence for the operator token.
 func (tok Token) Precedence() int {
 	switch tok {
-	case TokenLOr:
+	case TokenCoalesce:
 		return 1
-	case TokenLAnd:
+	case TokenLOr:
 		return 2
-	case TokenEqual, TokenNotEqual, TokenLess, TokenLessEq, TokenGreater, TokenGreaterEq:
+	case TokenLAnd:
 		return 3
-	case TokenAdd, TokenSub, TokenOr, TokenXor:
+	case TokenEqual, TokenNotEqual, TokenLess, TokenLessEq, TokenGreater, TokenGreaterEq:
 		return 4
-	case TokenMul, TokenQuo, TokenRem, TokenShl, TokenShr, TokenAnd, TokenAndNot:
+	case TokenRange, TokenRangeExcl:
 		return 5
+	case TokenAdd, TokenSub, TokenOr, TokenXor:
+		return 6
+	case TokenMul, TokenQuo, TokenRem, TokenShl, TokenShr, TokenAnd, TokenAndNot:
+		return 7
 	}
 	return LowestPrec
 }
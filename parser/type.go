@@ -0,0 +1,143 @@
+package parser
+
+import (
+	"strings"
+)
+
+// Type annotations are represented as expressions. A named type such as int
+// or any is an Ident, and a parenthesized type is a ParenExpr.
+
+// ArrayType represents an array type annotation.
+type ArrayType struct {
+	LBrack Pos
+	Elem   Expr
+	RBrack Pos
+}
+
+func (e *ArrayType) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *ArrayType) Pos() Pos {
+	return e.LBrack
+}
+
+// End returns the position of first character immediately after the node.
+func (e *ArrayType) End() Pos {
+	return e.RBrack + 1
+}
+
+func (e *ArrayType) String() string {
+	return "[" + e.Elem.String() + "]"
+}
+
+// FuncSigType represents a function type annotation.
+type FuncSigType struct {
+	FuncPos Pos
+	LParen  Pos
+	Params  []Expr
+	VarArgs bool
+	RParen  Pos
+	Result  Expr // nil if none
+}
+
+func (e *FuncSigType) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *FuncSigType) Pos() Pos {
+	return e.FuncPos
+}
+
+// End returns the position of first character immediately after the node.
+func (e *FuncSigType) End() Pos {
+	if e.Result != nil {
+		return e.Result.End()
+	}
+	return e.RParen + 1
+}
+
+func (e *FuncSigType) String() string {
+	var params []string
+	for i, t := range e.Params {
+		if e.VarArgs && i == len(e.Params)-1 {
+			params = append(params, "..."+t.String())
+		} else {
+			params = append(params, t.String())
+		}
+	}
+	s := "func(" + strings.Join(params, ", ") + ")"
+	if e.Result != nil {
+		s += ": " + e.Result.String()
+	}
+	return s
+}
+
+// MapType represents a map type annotation.
+type MapType struct {
+	LBrace Pos
+	Key    Expr
+	Value  Expr
+	RBrace Pos
+}
+
+func (e *MapType) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *MapType) Pos() Pos {
+	return e.LBrace
+}
+
+// End returns the position of first character immediately after the node.
+func (e *MapType) End() Pos {
+	return e.RBrace + 1
+}
+
+func (e *MapType) String() string {
+	return "{" + e.Key.String() + ": " + e.Value.String() + "}"
+}
+
+// NullableType represents a nullable type annotation.
+type NullableType struct {
+	Type     Expr
+	Question Pos
+}
+
+func (e *NullableType) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *NullableType) Pos() Pos {
+	return e.Type.Pos()
+}
+
+// End returns the position of first character immediately after the node.
+func (e *NullableType) End() Pos {
+	return e.Question + 1
+}
+
+func (e *NullableType) String() string {
+	return e.Type.String() + "?"
+}
+
+// UnionType represents a union type annotation.
+type UnionType struct {
+	Types []Expr
+}
+
+func (e *UnionType) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *UnionType) Pos() Pos {
+	return e.Types[0].Pos()
+}
+
+// End returns the position of first character immediately after the node.
+func (e *UnionType) End() Pos {
+	return e.Types[len(e.Types)-1].End()
+}
+
+func (e *UnionType) String() string {
+	var types []string
+	for _, t := range e.Types {
+		types = append(types, t.String())
+	}
+	return strings.Join(types, " | ")
+}
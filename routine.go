@@ -0,0 +1,309 @@
+package gslang
+
+import (
+	"time"
+)
+
+// VMObj wraps the VM calling a builtin function that needs it. See
+// BuiltinFunction.NeedVMObj.
+type VMObj struct {
+	ObjectImpl
+	Value *VM
+}
+
+// TypeName returns the name of the type.
+func (o *VMObj) TypeName() string {
+	return "vm"
+}
+
+func (o *VMObj) String() string {
+	return "<vm>"
+}
+
+// Routine represents a function running concurrently on its own VM, started
+// by the spawn builtin function.
+type Routine struct {
+	ObjectImpl
+	done chan struct{}
+	ret  Object
+	err  error
+}
+
+// TypeName returns the name of the type.
+func (o *Routine) TypeName() string {
+	return "routine"
+}
+
+func (o *Routine) String() string {
+	return "<routine>"
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *Routine) Equals(x Object) bool {
+	return o == x
+}
+
+// Copy returns the routine itself, as a routine cannot be copied.
+func (o *Routine) Copy() Object {
+	return o
+}
+
+// IndexGet returns the method of the routine with the given name.
+func (o *Routine) IndexGet(index Object) (Object, error) {
+	strIdx, _ := ToString(index)
+	switch strIdx {
+	case "wait":
+		return &BuiltinFunction{
+			Name:      "wait",
+			Value:     o.wait,
+			NeedVMObj: true,
+		}, nil
+	}
+	return nil, nil
+}
+
+// wait blocks until the routine returns, and returns its result. A runtime
+// error of the routine is returned as the error of the call. An optional
+// timeout in milliseconds makes wait return nil if it expires.
+// usage: routine.wait([timeout])
+func (o *Routine) wait(args ...Object) (Object, error) {
+	abort, args, err := vmAbortChan(args)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := timeoutArg(args, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-o.done:
+	case <-timeout:
+		return NilValue, nil
+	case <-abort:
+		return NilValue, nil
+	}
+	if o.err != nil {
+		return nil, o.err
+	}
+	return o.ret, nil
+}
+
+// Channel represents a channel to pass values between routines.
+type Channel struct {
+	ObjectImpl
+	Value chan Object
+}
+
+// TypeName returns the name of the type.
+func (o *Channel) TypeName() string {
+	return "channel"
+}
+
+func (o *Channel) String() string {
+	return "<channel>"
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *Channel) Equals(x Object) bool {
+	return o == x
+}
+
+// Copy returns the channel itself, as channels are shared by reference.
+func (o *Channel) Copy() Object {
+	return o
+}
+
+// CanIterate returns whether the Object can be Iterated.
+func (o *Channel) CanIterate() bool {
+	return true
+}
+
+// Iterate returns an iterator that receives values until the channel is
+// closed.
+func (o *Channel) Iterate() Iterator {
+	return &ChannelIterator{v: o.Value}
+}
+
+// IndexGet returns the method of the channel with the given name.
+func (o *Channel) IndexGet(index Object) (Object, error) {
+	strIdx, _ := ToString(index)
+	switch strIdx {
+	case "send":
+		return &BuiltinFunction{
+			Name:      "send",
+			Value:     o.send,
+			NeedVMObj: true,
+		}, nil
+	case "recv":
+		return &BuiltinFunction{
+			Name:      "recv",
+			Value:     o.recv,
+			NeedVMObj: true,
+		}, nil
+	case "close":
+		return &BuiltinFunction{
+			Name:  "close",
+			Value: o.close,
+		}, nil
+	}
+	return nil, nil
+}
+
+// send sends the value to the channel, blocking until it's received or
+// buffered.
+// usage: channel.send(value)
+func (o *Channel) send(args ...Object) (ret Object, err error) {
+	abort, args, err := vmAbortChan(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 1 {
+		return nil, ErrWrongNumArguments
+	}
+
+	defer func() {
+		// sending on a closed channel panics
+		if recover() != nil {
+			ret, err = nil, ErrChannelClosed
+		}
+	}()
+	select {
+	case o.Value <- args[0]:
+	case <-abort:
+	}
+	return NilValue, nil
+}
+
+// recv receives a value from the channel, blocking until one is available.
+// It returns nil if the channel is closed.
+// usage: channel.recv()
+func (o *Channel) recv(args ...Object) (Object, error) {
+	abort, args, err := vmAbortChan(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 0 {
+		return nil, ErrWrongNumArguments
+	}
+
+	select {
+	case val, ok := <-o.Value:
+		if ok {
+			return val, nil
+		}
+	case <-abort:
+	}
+	return NilValue, nil
+}
+
+// close closes the channel.
+// usage: channel.close()
+func (o *Channel) close(args ...Object) (ret Object, err error) {
+	if len(args) != 0 {
+		return nil, ErrWrongNumArguments
+	}
+
+	defer func() {
+		// closing a closed channel panics
+		if recover() != nil {
+			ret, err = nil, ErrChannelClosed
+		}
+	}()
+	close(o.Value)
+	return NilValue, nil
+}
+
+// ChannelIterator is an iterator for a channel.
+type ChannelIterator struct {
+	ObjectImpl
+	v     chan Object
+	abort <-chan struct{}
+	i     int64
+	value Object
+}
+
+// TypeName returns the name of the type.
+func (i *ChannelIterator) TypeName() string {
+	return "channel-iterator"
+}
+
+func (i *ChannelIterator) String() string {
+	return "<channel-iterator>"
+}
+
+// IsFalsy returns true if the value of the type is falsy.
+func (i *ChannelIterator) IsFalsy() bool {
+	return true
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (i *ChannelIterator) Equals(Object) bool {
+	return false
+}
+
+// Copy returns a copy of the type.
+func (i *ChannelIterator) Copy() Object {
+	return &ChannelIterator{v: i.v, abort: i.abort, i: i.i, value: i.value}
+}
+
+// Next receives the next value. It returns false if the channel is closed.
+func (i *ChannelIterator) Next() bool {
+	select {
+	case val, ok := <-i.v:
+		if !ok {
+			return false
+		}
+		i.value = val
+		i.i++
+		return true
+	case <-i.abort:
+		return false
+	}
+}
+
+// Key returns the index of the received value.
+func (i *ChannelIterator) Key() Object {
+	return &Int{Value: i.i - 1}
+}
+
+// Value returns the received value.
+func (i *ChannelIterator) Value() Object {
+	return i.value
+}
+
+// vmAbortChan returns the abort channel of the VM passed as the first
+// argument, and the rest of the arguments.
+func vmAbortChan(args []Object) (<-chan struct{}, []Object, error) {
+	if len(args) == 0 {
+		return nil, nil, ErrWrongNumArguments
+	}
+	vm, ok := args[0].(*VMObj)
+	if !ok {
+		return nil, nil, ErrWrongNumArguments
+	}
+	return vm.Value.abortChan(), args[1:], nil
+}
+
+// timeoutArg returns a channel that receives after the optional timeout in
+// milliseconds at args[i], or nil if it's not given.
+func timeoutArg(args []Object, i, max int) (<-chan time.Time, error) {
+	if len(args) > max {
+		return nil, ErrWrongNumArguments
+	}
+	if len(args) <= i {
+		return nil, nil
+	}
+	ms, ok := ToInt64(args[i])
+	if !ok {
+		return nil, ErrInvalidArgumentType{
+			Name:     "timeout",
+			Expected: "int",
+			Found:    args[i].TypeName(),
+		}
+	}
+	return time.After(time.Duration(ms) * time.Millisecond), nil
+}
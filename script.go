@@ -17,6 +17,7 @@ type Script struct {
 	maxAllocs        int64
 	maxConstObjects  int
 	enableFileImport bool
+	checked          bool
 	importDir        string
 }
 
@@ -87,6 +88,14 @@ func (s *Script) EnableFileImport(enable bool) {
 	s.enableFileImport = enable
 }
 
+// EnableCheckedArithmetic enables or disables checked arithmetic mode. In the
+// mode, an int overflow of +, -, * and << operations and unary minus is a
+// runtime error with ErrIntOverflow, instead of wrapping around. The checked
+// builtin function enables the mode for a single expression.
+func (s *Script) EnableCheckedArithmetic(enable bool) {
+	s.checked = enable
+}
+
 // Compile compiles the script with all the defined variables, and, returns
 // Compiled object.
 func (s *Script) Compile() (*Compiled, error) {
@@ -105,6 +114,7 @@ func (s *Script) Compile() (*Compiled, error) {
 
 	c := NewCompiler(srcFile, symbol, nil, s.modules, nil)
 	c.EnableFileImport(s.enableFileImport)
+	c.EnableCheckedArithmetic(s.checked)
 	c.SetImportDir(s.importDir)
 	if err := c.Compile(file); err != nil {
 		return nil, err
@@ -164,6 +174,25 @@ func (s *Script) RunContext(
 	return
 }
 
+// Check verifies the type annotations of the script with the defined
+// variables, and returns the errors and the warnings found by the checker.
+// It returns an error if the script cannot be parsed.
+func (s *Script) Check() ([]*CheckError, error) {
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile("(main)", -1, len(s.input))
+	p := parser.NewParser(srcFile, s.input, nil)
+	file, err := p.ParseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewChecker(srcFile)
+	for name, v := range s.variables {
+		c.Define(name, v.value)
+	}
+	return c.Check(file), nil
+}
+
 func (s *Script) prepCompile() (
 	symbol *Symbol,
 	globals []Object,
@@ -0,0 +1,423 @@
+package gslang_test
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/gslang/gslang"
+	"github.com/gslang/gslang/stdlib"
+)
+
+type scriptTest struct {
+	input    string
+	expected string
+}
+
+// expectRun runs the input script and compares the string form of its out
+// variable with expected.
+func expectRun(t *testing.T, input, expected string) {
+	t.Helper()
+	s := gslang.NewScript([]byte(input))
+	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	c, err := s.Run()
+	if err != nil {
+		t.Errorf("unexpected error\n\tinput: %s\n\terror: %s", input, err)
+		return
+	}
+	if !c.IsDefined("out") {
+		t.Errorf("out is not defined\n\tinput: %s", input)
+		return
+	}
+	actual := c.Get("out").Object().String()
+	if actual != expected {
+		t.Errorf("unexpected output\n\tinput: %s\n\texpected: %s\n\tactual: %s",
+			input, expected, actual)
+	}
+}
+
+// expectError compiles and runs the input script and checks that it fails
+// with an error containing expected.
+func expectError(t *testing.T, input, expected string) {
+	t.Helper()
+	s := gslang.NewScript([]byte(input))
+	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	_, err := s.Run()
+	if err == nil {
+		t.Errorf("expected error\n\tinput: %s", input)
+		return
+	}
+	if !strings.Contains(err.Error(), expected) {
+		t.Errorf("unexpected error\n\tinput: %s\n\texpected: %s\n\tactual: %s",
+			input, expected, err)
+	}
+}
+
+func runScriptTests(t *testing.T, tests []scriptTest) {
+	t.Helper()
+	for _, tt := range tests {
+		expectRun(t, tt.input, tt.expected)
+	}
+}
+
+func runErrorTests(t *testing.T, tests []scriptTest) {
+	t.Helper()
+	for _, tt := range tests {
+		expectError(t, tt.input, tt.expected)
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`f := func(x) { switch x { case 1, 2: return "a"; case "s": return "b"; default: return "c" } }
+out := [f(1), f(2), f("s"), f(nil)]`, `["a", "a", "b", "c"]`},
+		{`out := 0; switch { case out == 0: out = 1; fallthrough; case false: out += 10 }`, `11`},
+		{`out := ""; switch y := 3 * 2; { case y > 4: out = "mid"; break; out = "never"; default: out = "small" }`, `"mid"`},
+	})
+}
+
+func TestTryCatch(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`out := []; try { throw "x" } catch e { out = append(out, e.value) } finally { out = append(out, "fin") }`, `["x", "fin"]`},
+		{`out := ""; try { a := [1] + 1 } catch e { out = e.message }`, `"invalid operation: array + int"`},
+		{`log := []; g := func() { try { return "ret" } finally { log = append(log, "fin") } }
+out := [g(), log]`, `["ret", ["fin"]]`},
+	})
+	runErrorTests(t, []scriptTest{
+		{`throw "boom"`, `boom`},
+	})
+}
+
+func TestPropagate(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`f := func(x) { v := x?; return v + 1 }
+out := [f(1), is_error(f(error("e")))]`, `[2, true]`},
+		{`f := func(x) { a := x ? 5 : 0; return a }
+out := [f(true), f(false)]`, `[5, 0]`},
+		{`a := true
+out := a ?
+	1 : 2`, `1`},
+		{`f := func(v) { return v }
+g := func() { return f(3)? + 1 }
+h := func(c) { return c ? f(1)? : 2 }
+k := func(v) { switch v { case f(1)?: return "one" }; return "no" }
+out := [g(), h(true), h(false), true ? true ? 1 : 2 : 3, k(1)]`, `[4, 1, 2, 1, "one"]`},
+		{`f := func(e) {
+	v := e?
+	return v
+}
+out := [f(7), is_error(f(error("e")))]`, `[7, true]`},
+	})
+	runErrorTests(t, []scriptTest{
+		{`x := error(1)?`, `error`},
+	})
+}
+
+func TestDefer(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`out := []; f := func() { defer func() { out = append(out, 1) }(); defer func() { out = append(out, 2) }(); return 0 }
+f()`, `[2, 1]`},
+		{`out := []; g := func() { defer func() { out = append(out, "cleanup") }(); x := [1] + 1 }
+try { g() } catch e { out = append(out, e.message) }`, `["cleanup", "invalid operation: array + int"]`},
+	})
+}
+
+func TestDestructuring(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`f := func() { return 1, "two" }
+a, b := f(); a, b = b, a; out := [a, b]`, `["two", 1]`},
+		{`[x, y, ...rest] := [1, 2, 3, 4]; out := [x, y, rest]`, `[1, 2, [3, 4]]`},
+		{`{name, age: a} := {name: "Ann", age: 30}; out := [name, a]`, `["Ann", 30]`},
+	})
+	runErrorTests(t, []scriptTest{
+		{`[p, q] := [1, 2, 3]`, `wrong number of values to unpack`},
+	})
+}
+
+func TestInterpolation(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`name := "Ann"; out := $"hi ${name}, ${1 + 2}"`, `"hi Ann, 3"`},
+		{`out := $"${ $"in ${1}" } \${no}"`, `"in 1 ${no}"`},
+	})
+}
+
+func TestImmutable(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`x := [1, {k: [2]}]; y := freeze(x); x[1].k[0] = 9; out := y`, `[1, {k: [2]}]`},
+		{`a := immutable([1, [2]]); out := [is_immutable(a), is_immutable(copy(a))]`, `[true, false]`},
+	})
+	runErrorTests(t, []scriptTest{
+		{`a := immutable([1]); a[0] = 2`, `immutable`},
+		{`a := immutable([1, [3]]); a[1][0] = 5`, `immutable`},
+	})
+}
+
+func TestOptionalChaining(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`m := {a: {b: 3}, arr: [1, 2]}; n := nil
+out := [m?.a?.b, m.x?.b.c, n?[0][1], m.arr?[1], n ?? "def", 0 ?? 5]`, `[3, <nil>, <nil>, 2, "def", 0]`},
+		{`c := true; out := [c ?[1] : [2], !c ?[1] : [2]]`, `[[1], [2]]`},
+		{`n := nil; m := {arr: [1, 2, 3]}; out := [n?[1:2], m.arr?[1:], m?["arr"]?[0]]`, `[<nil>, [2, 3], 1]`},
+	})
+}
+
+func TestConst(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`const a = 2; const b = a * 3; out := b`, `6`},
+	})
+	runErrorTests(t, []scriptTest{
+		{`const a = 1; a = 2`, `const`},
+	})
+}
+
+func TestLabeledLoops(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`out := 0
+outer:
+for i := 0; i < 3; i++ { for j := 0; j < 3; j++ { if j == 1 { continue outer }; out++ } }`, `3`},
+		{`out := 0
+outer:
+for i in [1, 2, 3] { for j in [1, 2] { if i == 2 { break outer }; out++ } }`, `2`},
+	})
+	runErrorTests(t, []scriptTest{
+		{`for { break nope }`, `nope`},
+	})
+}
+
+func TestGenerators(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`count := func(n) { for i := 0; i < n; i++ { yield i * 10 } }
+out := []; for k, v in count(3) { out = append(out, [k, v]) }`, `[[0, 0], [1, 10], [2, 20]]`},
+		{`out := []; gen := func() { defer func() { out = append(out, "closed") }(); for i := 0; i < 5; i++ { yield i } }
+for x in gen() { if x == 1 { break } }`, `["closed"]`},
+		{`out := []; gen := func() { defer func() { out = append(out, "closed") }(); yield 1; yield 2 }
+f := func() { for x in gen() { return x } }
+r := f(); out = append(out, r)`, `["closed", 1]`},
+	})
+}
+
+func TestIterationProtocol(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`n := 0; it := {__next__: func() { if n >= 3 { return {done: true} }; n++; return {value: n} }}
+obj := {__iter__: func() { return it }}
+out := []; for v in obj { out = append(out, v) }`, `[1, 2, 3]`},
+		{`out := []; for k, v in {next: 1} { out = append(out, k) }`, `["next"]`},
+	})
+}
+
+func TestSpawn(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`ch := chan(1); spawn(func(c) { c.send(42) }, ch); out := ch.recv()`, `42`},
+		{`fib := func(n) { return n < 2 ? n : fib(n-1) + fib(n-2) }
+out := spawn(func() { return fib(15) }).wait()`, `610`},
+		{`m := {}; hs := []
+for i := 0; i < 8; i++ { hs = append(hs, spawn(func(x) { for j := 0; j < 100; j++ { x[string(j)] = j } }, m)) }
+for h in hs { h.wait() }
+out := len(m)`, `0`},
+		{`out := 0; for i in 0..<4 { out += spawn(func(n) { return n * 2 }, i).wait() }`, `12`},
+		{`gen := func() { for i := 0; i < 3; i++ { yield i } }
+out := 0; for x in gen() { out += spawn(func() { return x }).wait() }`, `3`},
+		{`gen := func() { for i := 0; i < 100; i++ { yield i } }
+g := gen(); out := ""; try { spawn(func(x) { for v in x {} }, g) } catch e { out = e.message }`,
+			`"cannot share generator or iterator with routine"`},
+	})
+	runErrorTests(t, []scriptTest{
+		{`gen := func() { yield 1 }; g := gen(); spawn(func() { for v in g {} })`, `cannot share`},
+		{`gen := func() { yield 1 }; spawn(func(m) { return m }, {it: gen()})`, `cannot share`},
+	})
+}
+
+func TestOperatorOverloading(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`money := func(c) { return {cents: c, __add__: func(a, b) { return money(a.cents + b.cents) },
+__cmp__: func(a, b) { return a.cents - b.cents } } }
+a := money(1); b := money(2)
+out := [(a + b).cents, a < b, a >= b, array_sort([b, a])[0].cents]`, `[3, true, false, 1]`},
+		{`out := array_sort([3, 1, 2])`, `[1, 2, 3]`},
+	})
+	runErrorTests(t, []scriptTest{
+		{`x := {} + {}`, `invalid operation`},
+	})
+}
+
+func TestClasses(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`class Point { x: 0, y: 0, norm2: func() { return self.x * self.x + self.y * self.y } }
+p := Point(3, 4); out := [p.norm2(), type(p), p.x]`, `[25, "Point", 3]`},
+		{`class Stack { items: [], init: func(...args) { for a in args { self.items = append(self.items, a) } } }
+out := [Stack(1, 2).items, Stack().items]`, `[[1, 2], []]`},
+		{`class V { x: 0, __add__: func(a, b) { return V(a.x + b.x) } }
+out := (V(1) + V(2)).x`, `3`},
+	})
+	runErrorTests(t, []scriptTest{
+		{`class P { x: 0 }; p := P(); p.z = 1`, `z`},
+	})
+}
+
+func TestMatch(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`f := func(v) { return match v { case nil: "nil"; case 0, 1: "small"; case int(n) if n > 100: "big"; case int: "int"
+case string(s): "s " + s; case [a, ...rest]: rest; case {k: nil}: "nil k"; default: "other" } }
+out := [f(nil), f(1), f(500), f(7), f("x"), f([1, 2, 3]), f({k: nil}), f(1.5)]`,
+			`["nil", "small", "big", "int", "s x", [2, 3], "nil k", "other"]`},
+		{`f := func(b) { return match b { case true: 1; case false: 0 } }
+out := [f(true), f(false)]`, `[1, 0]`},
+		{`f := func(v) { return match v { case bigint: "bigint"; case decimal: "decimal"; case array: "array"; default: "other" } }
+out := [f(bigint(1)), f(decimal("1.5")), f(1..3), f(1)]`, `["bigint", "decimal", "array", "other"]`},
+		{`gen := func() { yield 1 }
+f := func(v) { return match v { case generator: "gen"; case channel: "chan"; case routine: "rt"; default: "other" } }
+out := [f(gen()), f(chan()), f(spawn(func() {})), f(1)]`, `["gen", "chan", "rt", "other"]`},
+	})
+}
+
+func TestBigNumbers(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`a := bigint("123456789012345678901234567890"); out := [string(a * 2), a > 5, type(a)]`,
+			`["246913578024691357802469135780", true, "bigint"]`},
+		{`out := [string(decimal("0.1") + decimal("0.2")), decimal("0.1") + decimal("0.2") == decimal("0.3")]`, `["0.3", true]`},
+		{`f := func(x) { switch x { case 5: return "five"; default: return "other" } }
+out := [f(bigint(5)), f(decimal("5.00")), f(decimal("5.5"))]`, `["five", "five", "other"]`},
+		{`out := [string(bigint(5) >> 40000000000), string(bigint(-5) >> 40000000000), string(bigint(0) << 40000000000)]`,
+			`["0", "-1", "0"]`},
+		{`json := import("json"); a := json.decode("[9007199254740993, 9007199254740992, 1.5]")
+out := [type(a[0]), type(a[1]), type(a[2])]`, `["float", "float", "float"]`},
+		{`json := import("json"); a := json.decode("[9007199254740993, 9007199254740992, 1.5, 1e2]", true)
+out := [string(a[0]), type(a[1]), string(a[2]), type(a[3])]`, `["9007199254740993", "bigint", "1.5", "decimal"]`},
+		{`json := import("json"); out := string(json.decode("[1e-99999999999]", true))`, `"error: \"exceeding bigint size limit\""`},
+	})
+	runErrorTests(t, []scriptTest{
+		{`decimal("1e-99999999999")`, `exceeding bigint size limit`},
+		{`decimal("1e99999999999")`, `exceeding bigint size limit`},
+		{`bigint(1) << 40000000000`, `exceeding bigint size limit`},
+		{`x := bigint(1) << 16777000; y := x * x`, `exceeding bigint size limit`},
+	})
+}
+
+func TestBigNumberAllocs(t *testing.T) {
+	s := gslang.NewScript([]byte(`x := bigint(1) << 1000000`))
+	s.SetMaxAllocs(100)
+	_, err := s.Run()
+	if err == nil || !strings.Contains(err.Error(), gslang.ErrObjectAllocLimit.Error()) {
+		t.Errorf("expected %v, got %v", gslang.ErrObjectAllocLimit, err)
+	}
+	s = gslang.NewScript([]byte(`x := bigint(1) << 1000`))
+	s.SetMaxAllocs(100)
+	if _, err := s.Run(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestBigIntInterface(t *testing.T) {
+	v := big.NewInt(5)
+	o, err := gslang.FromInterface(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v.SetInt64(6)
+	if s := o.String(); s != "5" {
+		t.Errorf("FromInterface shares the big.Int: %s", s)
+	}
+	res := gslang.ToInterface(o).(*big.Int)
+	res.SetInt64(7)
+	if s := o.String(); s != "5" {
+		t.Errorf("ToInterface shares the big.Int: %s", s)
+	}
+}
+
+func TestCheckedArithmetic(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`out := checked(5 * 3 - 1)`, `14`},
+	})
+	runErrorTests(t, []scriptTest{
+		{`max := 9223372036854775807; checked(max + 1)`, `overflow`},
+	})
+}
+
+func TestRanges(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`r := range(0, 10); out := [len(r), r[3], r[2:5]]`, `[10, 3, range(2, 5)]`},
+		{`out := []; for x in 1..3 { out = append(out, x) }; for x in 3..<5 { out = append(out, x) }`, `[1, 2, 3, 3, 4]`},
+		{`out := string(1..3)`, `"range(1, 4)"`},
+		{`out := [string(range(0, 10, 3)), string(range(5, 0, 2))]`, `["range(0, 10, 3)", "range(5, 0, 2)"]`},
+		{`out := append(range(0, 3), 9)`, `[0, 1, 2, 9]`},
+		{`r := range(0, 3); r[0] = 5; out := [r, len(r), r[1:]]`, `[[5, 1, 2], 3, [1, 2]]`},
+		{`out := []; for _ in 0..<2 { r := 1..3; out = append(out, r[0]); r[0] = 9 }`, `[1, 1]`},
+		{`out := [range(0, 3) == [0, 1, 2], [0, 1] == 0..<2, range(0, 3) == [0, 1], 1..3 == range(1, 4)]`, `[true, true, false, true]`},
+		{`out := [is_array(range(0, 3)), type(1..3)]`, `[true, "array"]`},
+		{`r := range(0, 3); array_reverse(r); x := array_pop(r); out := [r, x]`, `[[2, 1], 0]`},
+		{`out := [range(0, 2) + [5], [5] + range(0, 2), array_push(1..2, 3)]`, `[[0, 1, 5], [5, 0, 1], [1, 2, 3]]`},
+		{`a, b := 1..2; out := [a, b, [...range(0, 2), 7]]`, `[1, 2, [0, 1, 7]]`},
+		{`text := import("text"); json := import("json")
+out := [text.join(range(0, 3), ","), string(json.encode(range(0, 3)))]`, `["0,1,2", "[0,1,2]"]`},
+	})
+}
+
+func TestRangeAllocs(t *testing.T) {
+	s := gslang.NewScript([]byte(`r := range(0, 100000000); out := [len(r), r[99999999]]`))
+	s.SetMaxAllocs(100)
+	c, err := s.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out := c.Get("out").Object().String(); out != "[100000000, 99999999]" {
+		t.Errorf("unexpected output: %s", out)
+	}
+}
+
+func TestComprehensions(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`out := [x * 2 for x in [3, -1, 4] if x > 0]`, `[6, 8]`},
+		{`out := {k: v * 10 for k, v in {a: 1, b: 2} if v != 2}`, `{a: 10}`},
+		{`f := func(n) { return [func() { return i * n } for i in 0..<3] }
+out := [g() for g in f(10)]`, `[0, 10, 20]`},
+	})
+}
+
+func TestArrowFunctions(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`double := (x) => x * 2; inc := x => x + 1; none := () => "n"
+out := [double(4), inc(1), none(), (a => b => a + b)(3)(4)]`, `[8, 2, "n", 7]`},
+		{`blk := x => { y := x * 10; return y + 1 }; out := blk(2)`, `21`},
+	})
+}
+
+func TestDefaultAndNamedArgs(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`f := func(a, b = 2, c = a + b) { return [a, b, c] }
+out := [f(1), f(1, 5), f(1, c: 0), f(c: 1, a: 2)]`, `[[1, 2, 3], [1, 5, 6], [1, 2, 0], [2, 2, 1]]`},
+	})
+	runErrorTests(t, []scriptTest{
+		{`f := func(a) { return a }; f(b: 1)`, `b`},
+	})
+}
+
+func TestSpread(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`a := [1, 2]; out := [...a, ...[3], 4]`, `[1, 2, 3, 4]`},
+		{`m := {...{x: 1, y: 2}, y: 5}; out := [m.x, m.y, len(m)]`, `[1, 5, 2]`},
+		{`f := func(...xs) { return xs }; a := [1, 2]; out := f(...a, 5)`, `[1, 2, 5]`},
+	})
+}
+
+func TestTailCalls(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{`count := func(n, acc) { if n == 0 { return acc }; return count(n - 1, acc + 1) }
+out := count(100000, 0)`, `100000`},
+		{`d := func(n) { defer func() {}(); if n == 0 { return 0 }; return d(n - 1) }
+out := d(100)`, `0`},
+	})
+}
+
+func TestArraySortFromGo(t *testing.T) {
+	for _, fn := range gslang.GetAllBuiltinFunctions() {
+		if fn.Name != "array_sort" {
+			continue
+		}
+		arr := &gslang.Array{Value: []gslang.Object{&gslang.Int{Value: 1}}}
+		if _, err := fn.Value(arr); err == nil {
+			t.Errorf("expected error calling array_sort without the VM")
+		}
+		return
+	}
+	t.Errorf("array_sort is not a builtin function")
+}
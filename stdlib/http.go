@@ -1,6 +1,7 @@
 package stdlib
 
 import (
+	"fmt"
 	"time"
 	"bytes"
 	"net/http"
@@ -11,12 +12,17 @@ import (
 
 var httpModule = map[string]gslang.Object{
 	"request": &gslang.UserFunction{
-		Name:  "request",
-		Value: httpRequest,
+		Name:    "request",
+		KwValue: httpRequest,
 	},
 }
 
-func httpRequest(args ...gslang.Object) (gslang.Object, error) {
+// httpRequest creates a request. The timeout, headers and body of the request
+// can be passed as named arguments.
+func httpRequest(
+	kwargs map[string]gslang.Object,
+	args ...gslang.Object,
+) (gslang.Object, error) {
 	if len(args) != 2 {
 		return nil, gslang.ErrWrongNumArguments
 	}
@@ -46,7 +52,7 @@ func httpRequest(args ...gslang.Object) (gslang.Object, error) {
 	cli := &http.Client{
 		Timeout: time.Duration(30) * time.Second,
 	}
-	return &gslang.Map{
+	request := &gslang.Map{
 		Value: map[string]gslang.Object{
 			"set_timeout": &gslang.UserFunction{
 				Name:  "set_timeout",
@@ -160,5 +166,35 @@ func httpRequest(args ...gslang.Object) (gslang.Object, error) {
 				},
 			},
 		},
-	}, nil
+	}
+	for name, value := range kwargs {
+		var err error
+		switch name {
+		case "timeout":
+			_, err = request.Value["set_timeout"].Call(value)
+		case "body":
+			_, err = request.Value["set_body"].Call(value)
+		case "headers":
+			headers, ok := value.(*gslang.Map)
+			if !ok {
+				return nil, gslang.ErrInvalidArgumentType{
+					Name:     "headers",
+					Expected: "map",
+					Found:    value.TypeName(),
+				}
+			}
+			for k, v := range headers.Value {
+				_, err = request.Value["set_header"].Call(&gslang.String{Value: k}, v)
+				if err != nil {
+					break
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unexpected argument '%s'", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return request, nil
 }
\ No newline at end of file
@@ -28,13 +28,19 @@ var jsonModule = map[string]gslang.Object{
 }
 
 func jsonDecode(args ...gslang.Object) (ret gslang.Object, err error) {
-	if len(args) != 1 {
+	if len(args) != 1 && len(args) != 2 {
 		return nil, gslang.ErrWrongNumArguments
 	}
 
+	// json.decode(data, true) decodes numbers as bigints and decimals
+	decode := json.Decode
+	if len(args) == 2 && !args[1].IsFalsy() {
+		decode = json.DecodeExact
+	}
+
 	switch o := args[0].(type) {
 	case *gslang.Bytes:
-		v, err := json.Decode(o.Value)
+		v, err := decode(o.Value)
 		if err != nil {
 			return &gslang.Error{
 				Value: &gslang.String{Value: err.Error()},
@@ -42,7 +48,7 @@ func jsonDecode(args ...gslang.Object) (ret gslang.Object, err error) {
 		}
 		return v, nil
 	case *gslang.String:
-		v, err := json.Decode([]byte(o.Value))
+		v, err := decode([]byte(o.Value))
 		if err != nil {
 			return &gslang.Error{
 				Value: &gslang.String{Value: err.Error()},
@@ -7,6 +7,8 @@
 package json
 
 import (
+	"bytes"
+	"math/big"
 	"strconv"
 	"unicode"
 	"unicode/utf16"
@@ -15,9 +17,21 @@ import (
 	"github.com/gslang/gslang"
 )
 
-// Decode parses the JSON-encoded data and returns the result object.
+// Decode parses the JSON-encoded data and returns the result object. Numbers
+// are decoded as floats.
 func Decode(data []byte) (gslang.Object, error) {
 	var d decodeState
+	return d.decode(data)
+}
+
+// DecodeExact is like Decode, but it decodes integer numbers as bigints and
+// the other numbers as decimals, so that no number loses precision.
+func DecodeExact(data []byte) (gslang.Object, error) {
+	d := decodeState{exact: true}
+	return d.decode(data)
+}
+
+func (d *decodeState) decode(data []byte) (gslang.Object, error) {
 	err := checkValid(data, &d.scan)
 	if err != nil {
 		return nil, err
@@ -34,6 +48,7 @@ type decodeState struct {
 	off    int // next read offset in data
 	opcode int // last read result
 	scan   scanner
+	exact  bool // decode numbers as bigints and decimals
 }
 
 // readIndex returns the position of the last byte read.
@@ -210,6 +225,17 @@ func (d *decodeState) literal() (gslang.Object, error) {
 		if c != '-' && (c < '0' || c > '9') {
 			panic(phasePanicMsg)
 		}
+		if d.exact {
+			if bytes.IndexAny(item, ".eE") < 0 {
+				v, _ := new(big.Int).SetString(string(item), 10)
+				return gslang.FromInterface(v)
+			}
+			v, err := gslang.ParseDecimal(string(item))
+			if err != nil {
+				return nil, err
+			}
+			return v, nil
+		}
 		n, _ := strconv.ParseFloat(string(item), 10)
 		return &gslang.Float{Value: n}, nil
 	}
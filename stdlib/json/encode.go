@@ -143,6 +143,19 @@ func Encode(o gslang.Object) ([]byte, error) {
 			}
 		}
 		b = append(b, ']')
+	case *gslang.Range:
+		b = append(b, '[')
+		for it := o.Iterate(); it.Next(); {
+			if len(b) > 1 {
+				b = append(b, ',')
+			}
+			eb, err := Encode(it.Value())
+			if err != nil {
+				return nil, err
+			}
+			b = append(b, eb...)
+		}
+		b = append(b, ']')
 	case *gslang.Map:
 		b = append(b, '{')
 		len1 := len(o.Value) - 1
@@ -206,6 +219,10 @@ func Encode(o gslang.Object) ([]byte, error) {
 		b = append(b, y...)
 	case *gslang.Int:
 		b = strconv.AppendInt(b, o.Value, 10)
+	case *gslang.BigInt:
+		b = o.Value.Append(b, 10)
+	case *gslang.Decimal:
+		b = append(b, o.String()...)
 	case *gslang.String:
 		// string encoding bug is fixed with newly introduced function
 		// encodeString(). See: https://github.com/d5/gslang/issues/268
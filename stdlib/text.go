@@ -768,29 +768,28 @@ func textJoin(args ...gslang.Object) (ret gslang.Object, err error) {
 		return nil, gslang.ErrWrongNumArguments
 	}
 
-	var slen int
-	var ss1 []string
-	switch arg0 := args[0].(type) {
-	case *gslang.Array:
-		for idx, a := range arg0.Value {
-			as, ok := gslang.ToString(a)
-			if !ok {
-				return nil, gslang.ErrInvalidArgumentType{
-					Name:     fmt.Sprintf("first[%d]", idx),
-					Expected: "string(compatible)",
-					Found:    a.TypeName(),
-				}
-			}
-			slen += len(as)
-			ss1 = append(ss1, as)
-		}
-	default:
+	arr, ok := gslang.ToArray(args[0])
+	if !ok {
 		return nil, gslang.ErrInvalidArgumentType{
 			Name:     "first",
 			Expected: "array",
 			Found:    args[0].TypeName(),
 		}
 	}
+	var slen int
+	var ss1 []string
+	for idx, a := range arr {
+		as, ok := gslang.ToString(a)
+		if !ok {
+			return nil, gslang.ErrInvalidArgumentType{
+				Name:     fmt.Sprintf("first[%d]", idx),
+				Expected: "string(compatible)",
+				Found:    a.TypeName(),
+			}
+		}
+		slen += len(as)
+		ss1 = append(ss1, as)
+	}
 
 	s2, ok := gslang.ToString(args[1])
 	if !ok {
@@ -16,7 +16,9 @@ type SymbolObject struct {
 	Name          string
 	Scope         SymbolScope
 	Index         int
-	LocalAssigned bool // if the local symbol is assigned at least once
+	LocalAssigned bool   // if the local symbol is assigned at least once
+	Constant      bool   // if the symbol is declared with const
+	Value         Object // compile-time value of the constant, if known
 }
 
 // Symbol represents a symbol table.
@@ -114,6 +116,19 @@ func (t *Symbol) Resolve(
 	return symbol, depth, true
 }
 
+// lookup finds a symbol with a given name like Resolve, but it doesn't
+// define a free symbol for a local symbol of an outer function.
+func (t *Symbol) lookup(name string, recur bool) (*SymbolObject, bool) {
+	symbol, ok := t.store[name]
+	if ok && (symbol.Scope != ScopeLocal || symbol.LocalAssigned || recur) {
+		return symbol, true
+	}
+	if t.parent == nil {
+		return nil, false
+	}
+	return t.parent.lookup(name, true)
+}
+
 // Fork creates a new symbol table for a new scope.
 func (t *Symbol) Fork(block bool) *Symbol {
 	return &Symbol{
@@ -178,9 +193,11 @@ func (t *Symbol) defineFree(original *SymbolObject) *SymbolObject {
 	// TODO: should we check duplicates?
 	t.freeSymbols = append(t.freeSymbols, original)
 	symbol := &SymbolObject{
-		Name:  original.Name,
-		Index: len(t.freeSymbols) - 1,
-		Scope: ScopeFree,
+		Name:     original.Name,
+		Index:    len(t.freeSymbols) - 1,
+		Scope:    ScopeFree,
+		Constant: original.Constant,
+		Value:    original.Value,
 	}
 	t.store[original.Name] = symbol
 	return symbol
@@ -82,6 +82,8 @@ func (v *Variable) Array() []interface{} {
 			arr = append(arr, ToInterface(e))
 		}
 		return arr
+	case *Range:
+		return ToInterface(val).([]interface{})
 	}
 	return nil
 }
@@ -114,12 +116,14 @@ func (v *Variable) Bytes() []byte {
 	return c
 }
 
-// Error returns an error if the underlying value is error object. If not,
-// this returns nil.
+// Error returns an error if the underlying value is error or exception
+// object. If not, this returns nil.
 func (v *Variable) Error() error {
-	err, ok := v.value.(*Error)
-	if ok {
+	switch err := v.value.(type) {
+	case *Error:
 		return errors.New(err.String())
+	case *Exception:
+		return err
 	}
 	return nil
 }
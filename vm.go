@@ -2,6 +2,10 @@ package gslang
 
 import (
 	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"sync"
 	"sync/atomic"
 
 	"github.com/gslang/gslang/parser"
@@ -13,6 +17,33 @@ type frame struct {
 	freeVars    []*ObjectPtr
 	ip          int
 	basePointer int
+	defers      []*deferred
+	gen         *Generator // generator running in the frame; or nil
+}
+
+// deferred represents a function call deferred by a defer statement.
+type deferred struct {
+	fn    Object
+	args  []Object
+	flags int
+	pos   parser.Pos
+}
+
+// unsetArg is the value of the parameters with default values that are not
+// passed, until the function evaluates their default values.
+var unsetArg Object = &Nil{}
+
+// flags of the function calls
+const (
+	callSpread = 1 << iota // the last argument is spread
+	callKwargs             // the named arguments follow as a map
+)
+
+// handler represents an exception handler installed by a try statement.
+type handler struct {
+	framesIndex int
+	sp          int
+	pos         int
 }
 
 // VM is a virtual machine that executes the bytecode compiled by Compiler.
@@ -29,8 +60,13 @@ type VM struct {
 	ip          int
 	aborting    int64
 	maxAllocs   int64
-	allocs      int64
+	allocs      *int64 // shared with the routines
 	err         error
+	handlers    []handler
+	abortMu     sync.Mutex
+	abortCh     chan struct{}    // closed on abort; or nil
+	children    map[*VM]struct{} // VMs of the running routines
+	routines    sync.WaitGroup
 }
 
 // NewVM creates a VM.
@@ -58,9 +94,77 @@ func NewVM(
 	return v
 }
 
-// Abort aborts the execution.
+// Abort aborts the execution, including the routines spawned by it.
 func (v *VM) Abort() {
 	atomic.StoreInt64(&v.aborting, 1)
+	v.abortMu.Lock()
+	if v.abortCh != nil {
+		close(v.abortCh)
+		v.abortCh = nil
+	}
+	v.abortMu.Unlock()
+	v.abortChildren()
+}
+
+// abortChildren aborts the routines spawned by v.
+func (v *VM) abortChildren() {
+	v.abortMu.Lock()
+	children := make([]*VM, 0, len(v.children))
+	for child := range v.children {
+		children = append(children, child)
+	}
+	v.abortMu.Unlock()
+	for _, child := range children {
+		child.Abort()
+	}
+}
+
+// closedChan is a closed channel returned by abortChan for an aborting VM.
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// abortChan returns a channel that is closed when the execution is aborted.
+// It's used to interrupt blocking operations of the builtin functions.
+func (v *VM) abortChan() <-chan struct{} {
+	v.abortMu.Lock()
+	defer v.abortMu.Unlock()
+	if atomic.LoadInt64(&v.aborting) != 0 {
+		return closedChan
+	}
+	if v.abortCh == nil {
+		v.abortCh = make(chan struct{})
+	}
+	return v.abortCh
+}
+
+// newAllocs returns a counter of the allocations left under the limit; a
+// negative limit means no limit.
+func newAllocs(maxAllocs int64) *int64 {
+	n := int64(math.MaxInt64)
+	if maxAllocs >= 0 {
+		n = maxAllocs + 1
+	}
+	return &n
+}
+
+// bigAllocWords is the number of words of a bigint or a decimal value charged
+// as one object allocation.
+const bigAllocWords = 16
+
+// allocCost returns the number of object allocations charged for the value:
+// one, and one more for each bigAllocWords words of a bigint or a decimal, so
+// that the allocation limit also bounds the memory of big numbers.
+func allocCost(o Object) int64 {
+	switch o := o.(type) {
+	case *BigInt:
+		return 1 + int64(len(o.Value.Bits())/bigAllocWords)
+	case *Decimal:
+		return 1 + int64(len(o.Value.Bits())/bigAllocWords)
+	}
+	return 1
 }
 
 // Run starts the execution.
@@ -71,26 +175,39 @@ func (v *VM) Run() (err error) {
 	v.curInsts = v.curFrame.fn.Instructions
 	v.framesIndex = 1
 	v.ip = -1
-	v.allocs = v.maxAllocs + 1
+	v.allocs = newAllocs(v.maxAllocs)
+	v.handlers = v.handlers[:0]
 
 	v.run()
+	for v.err != nil && v.throw() {
+		v.run()
+	}
+	if v.err != nil {
+		err = v.runtimeError()
+		v.unwind(1)
+	}
+	v.stopRoutines()
 	atomic.StoreInt64(&v.aborting, 0)
-	err = v.err
-	if err != nil {
-		filePos := v.fileSet.Position(
-			v.curFrame.fn.SourcePos(v.ip - 1))
-		err = fmt.Errorf("Runtime Error: %w\n\tat %s",
-			err, filePos)
-		for v.framesIndex > 1 {
-			v.framesIndex--
-			v.curFrame = &v.frames[v.framesIndex-1]
-			filePos = v.fileSet.Position(
-				v.curFrame.fn.SourcePos(v.curFrame.ip - 1))
-			err = fmt.Errorf("%w\n\tat %s", err, filePos)
+	return err
+}
+
+// runtimeError returns the current error annotated with the source positions
+// of the frames.
+func (v *VM) runtimeError() error {
+	filePos := v.fileSet.Position(
+		v.curFrame.fn.SourcePos(v.ip - 1))
+	err := fmt.Errorf("Runtime Error: %w\n\tat %s",
+		v.err, filePos)
+	for i := v.framesIndex - 1; i > 0; i-- {
+		f := &v.frames[i-1]
+		filePos = v.fileSet.Position(f.fn.SourcePos(f.ip - 1))
+		if !filePos.IsValid() {
+			// e.g. the frame driving a generator from Go
+			continue
 		}
-		return err
+		err = fmt.Errorf("%w\n\tat %s", err, filePos)
 	}
-	return nil
+	return err
 }
 
 func (v *VM) run() {
@@ -112,20 +229,31 @@ func (v *VM) run() {
 			right := v.stack[v.sp-1]
 			left := v.stack[v.sp-2]
 			tok := parser.Token(v.curInsts[v.ip])
-			res, e := left.BinaryOp(tok, right)
-			if e != nil {
+			res := v.binaryOp(tok, left, right)
+			if v.err != nil {
+				v.sp -= 2
+				return
+			}
+
+			if atomic.AddInt64(v.allocs, -allocCost(res)) <= 0 {
+				v.err = ErrObjectAllocLimit
+				return
+			}
+
+			v.stack[v.sp-2] = res
+			v.sp--
+		case parser.OpCheckedBinOp:
+			v.ip++
+			right := v.stack[v.sp-1]
+			left := v.stack[v.sp-2]
+			tok := parser.Token(v.curInsts[v.ip])
+			res := v.checkedBinaryOp(tok, left, right)
+			if v.err != nil {
 				v.sp -= 2
-				if e == ErrInvalidOperator {
-					v.err = fmt.Errorf("invalid operation: %s %s %s",
-						left.TypeName(), tok.String(), right.TypeName())
-					return
-				}
-				v.err = e
 				return
 			}
 
-			v.allocs--
-			if v.allocs == 0 {
+			if atomic.AddInt64(v.allocs, -allocCost(res)) <= 0 {
 				v.err = ErrObjectAllocLimit
 				return
 			}
@@ -136,7 +264,11 @@ func (v *VM) run() {
 			right := v.stack[v.sp-1]
 			left := v.stack[v.sp-2]
 			v.sp -= 2
-			if left.Equals(right) {
+			eq := v.equals(left, right)
+			if v.err != nil {
+				return
+			}
+			if eq {
 				v.stack[v.sp] = TrueValue
 			} else {
 				v.stack[v.sp] = FalseValue
@@ -146,7 +278,11 @@ func (v *VM) run() {
 			right := v.stack[v.sp-1]
 			left := v.stack[v.sp-2]
 			v.sp -= 2
-			if left.Equals(right) {
+			eq := v.equals(left, right)
+			if v.err != nil {
+				return
+			}
+			if eq {
 				v.stack[v.sp] = FalseValue
 			} else {
 				v.stack[v.sp] = TrueValue
@@ -176,8 +312,7 @@ func (v *VM) run() {
 			switch x := operand.(type) {
 			case *Int:
 				var res Object = &Int{Value: ^x.Value}
-				v.allocs--
-				if v.allocs == 0 {
+				if atomic.AddInt64(v.allocs, -1) <= 0 {
 					v.err = ErrObjectAllocLimit
 					return
 				}
@@ -188,6 +323,13 @@ func (v *VM) run() {
 					operand.TypeName())
 				return
 			}
+		case parser.OpCheckedMinus:
+			x, ok := v.stack[v.sp-1].(*Int)
+			if ok && x.Value == math.MinInt64 {
+				v.err = fmt.Errorf("%w: -(%d)", ErrIntOverflow, x.Value)
+				return
+			}
+			fallthrough
 		case parser.OpMinus:
 			operand := v.stack[v.sp-1]
 			v.sp--
@@ -195,8 +337,7 @@ func (v *VM) run() {
 			switch x := operand.(type) {
 			case *Int:
 				var res Object = &Int{Value: -x.Value}
-				v.allocs--
-				if v.allocs == 0 {
+				if atomic.AddInt64(v.allocs, -1) <= 0 {
 					v.err = ErrObjectAllocLimit
 					return
 				}
@@ -204,8 +345,26 @@ func (v *VM) run() {
 				v.sp++
 			case *Float:
 				var res Object = &Float{Value: -x.Value}
-				v.allocs--
-				if v.allocs == 0 {
+				if atomic.AddInt64(v.allocs, -1) <= 0 {
+					v.err = ErrObjectAllocLimit
+					return
+				}
+				v.stack[v.sp] = res
+				v.sp++
+			case *BigInt:
+				var res Object = &BigInt{Value: new(big.Int).Neg(x.Value)}
+				if atomic.AddInt64(v.allocs, -allocCost(res)) <= 0 {
+					v.err = ErrObjectAllocLimit
+					return
+				}
+				v.stack[v.sp] = res
+				v.sp++
+			case *Decimal:
+				var res Object = &Decimal{
+					Value: new(big.Int).Neg(x.Value),
+					Scale: x.Scale,
+				}
+				if atomic.AddInt64(v.allocs, -allocCost(res)) <= 0 {
 					v.err = ErrObjectAllocLimit
 					return
 				}
@@ -223,6 +382,12 @@ func (v *VM) run() {
 				pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
 				v.ip = pos - 1
 			}
+		case parser.OpJumpNotError:
+			v.ip += 2
+			if _, isError := v.stack[v.sp-1].(*Error); !isError {
+				pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+				v.ip = pos - 1
+			}
 		case parser.OpAndJump:
 			v.ip += 2
 			if v.stack[v.sp-1].IsFalsy() {
@@ -239,9 +404,30 @@ func (v *VM) run() {
 				pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
 				v.ip = pos - 1
 			}
+		case parser.OpNilJump:
+			v.ip += 2
+			if v.stack[v.sp-1] == NilValue {
+				pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+				v.ip = pos - 1
+			}
+		case parser.OpCoalesceJump:
+			v.ip += 2
+			if v.stack[v.sp-1] == NilValue {
+				v.sp--
+			} else {
+				pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+				v.ip = pos - 1
+			}
 		case parser.OpJump:
 			pos := int(v.curInsts[v.ip+2]) | int(v.curInsts[v.ip+1])<<8
 			v.ip = pos - 1
+		case parser.OpArgJump:
+			v.ip += 2
+			v.sp--
+			if v.stack[v.sp] != unsetArg {
+				pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+				v.ip = pos - 1
+			}
 		case parser.OpSetGlobal:
 			v.ip += 2
 			v.sp--
@@ -281,8 +467,7 @@ func (v *VM) run() {
 			v.sp -= numElements
 
 			var arr Object = &Array{Value: elements}
-			v.allocs--
-			if v.allocs == 0 {
+			if atomic.AddInt64(v.allocs, -1) <= 0 {
 				v.err = ErrObjectAllocLimit
 				return
 			}
@@ -301,24 +486,125 @@ func (v *VM) run() {
 			v.sp -= numElements
 
 			var m Object = &Map{Value: kv}
-			v.allocs--
-			if v.allocs == 0 {
+			if atomic.AddInt64(v.allocs, -1) <= 0 {
 				v.err = ErrObjectAllocLimit
 				return
 			}
 			v.stack[v.sp] = m
 			v.sp++
+		case parser.OpComp:
+			v.ip++
+			isMap := v.curInsts[v.ip] == 1
+			iterable := v.stack[v.sp-1]
+
+			// the result is preallocated if the length is known
+			n := iterableLen(iterable)
+			var res Object
+			if isMap {
+				res = &Map{Value: make(map[string]Object, n)}
+			} else {
+				res = &Array{Value: make([]Object, 0, n)}
+			}
+			if atomic.AddInt64(v.allocs, -1) <= 0 {
+				v.err = ErrObjectAllocLimit
+				return
+			}
+
+			// the result is placed below the iterable
+			v.stack[v.sp-1] = res
+			v.stack[v.sp] = iterable
+			v.sp++
+		case parser.OpAppend:
+			arr := v.stack[v.sp-2].(*Array)
+			arr.Value = append(arr.Value, v.stack[v.sp-1])
+			v.sp--
+		case parser.OpSpread:
+			v.ip++
+			value := v.stack[v.sp-1]
+			v.sp--
+			if v.curInsts[v.ip] == 1 {
+				src, ok := value.(*Map)
+				if !ok {
+					v.err = fmt.Errorf("not a map: %s", value.TypeName())
+					return
+				}
+				m := v.stack[v.sp-1].(*Map)
+				for key, elem := range src.Value {
+					m.Value[key] = elem
+				}
+				break
+			}
+			arr := v.stack[v.sp-1].(*Array)
+			switch value := value.(type) {
+			case *Array:
+				arr.Value = append(arr.Value, value.Value...)
+			case *Range:
+				arr.Value = value.appendTo(arr.Value)
+			default:
+				v.err = fmt.Errorf("not an array: %s", value.TypeName())
+				return
+			}
+		case parser.OpAppendMap:
+			m := v.stack[v.sp-3].(*Map)
+			if err := m.IndexSet(v.stack[v.sp-2], v.stack[v.sp-1]); err != nil {
+				v.err = err
+				return
+			}
+			v.sp -= 2
+		case parser.OpClass:
+			v.ip += 4
+			numFields := int(v.curInsts[v.ip-2]) | int(v.curInsts[v.ip-3])<<8
+			numMethods := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+			base := v.sp - (numFields+numMethods)*2 - 1
+			class := &Class{
+				Name:     v.stack[base].(*String).Value,
+				Fields:   make([]string, 0, numFields),
+				Defaults: make(map[string]Object, numFields),
+				Methods:  make(map[string]*CompiledFunction, numMethods),
+				vm:       v,
+			}
+			i := base + 1
+			for ; i < base+1+numFields*2; i += 2 {
+				name := v.stack[i].(*String).Value
+				class.Fields = append(class.Fields, name)
+				class.Defaults[name] = v.stack[i+1]
+			}
+			for ; i < v.sp; i += 2 {
+				name := v.stack[i].(*String).Value
+				class.Methods[name] = v.stack[i+1].(*CompiledFunction)
+			}
+			v.sp = base
+
+			if atomic.AddInt64(v.allocs, -1) <= 0 {
+				v.err = ErrObjectAllocLimit
+				return
+			}
+			v.stack[v.sp] = class
+			v.sp++
 		case parser.OpError:
 			value := v.stack[v.sp-1]
 			var e Object = &Error{
 				Value: value,
 			}
-			v.allocs--
-			if v.allocs == 0 {
+			if atomic.AddInt64(v.allocs, -1) <= 0 {
 				v.err = ErrObjectAllocLimit
 				return
 			}
 			v.stack[v.sp-1] = e
+		case parser.OpImmutable:
+			// deep immutable copy of arrays and maps
+			var res Object
+			switch value := v.stack[v.sp-1].(type) {
+			case *Array, *Map, *Range:
+				res = Freeze(value)
+			}
+			if res != nil {
+				if atomic.AddInt64(v.allocs, -1) <= 0 {
+					v.err = ErrObjectAllocLimit
+					return
+				}
+				v.stack[v.sp-1] = res
+			}
 		case parser.OpIndex:
 			index := v.stack[v.sp-1]
 			left := v.stack[v.sp-2]
@@ -360,6 +646,9 @@ func (v *VM) run() {
 				}
 			}
 
+			if r, ok := left.(*Range); ok && r.arr != nil {
+				left = r.arr
+			}
 			switch left := left.(type) {
 			case *Array:
 				numElements := int64(len(left.Value))
@@ -391,8 +680,7 @@ func (v *VM) run() {
 				var val Object = &Array{
 					Value: left.Value[lowIdx:highIdx],
 				}
-				v.allocs--
-				if v.allocs == 0 {
+				if atomic.AddInt64(v.allocs, -1) <= 0 {
 					v.err = ErrObjectAllocLimit
 					return
 				}
@@ -428,8 +716,7 @@ func (v *VM) run() {
 				var val Object = &String{
 					Value: left.Value[lowIdx:highIdx],
 				}
-				v.allocs--
-				if v.allocs == 0 {
+				if atomic.AddInt64(v.allocs, -1) <= 0 {
 					v.err = ErrObjectAllocLimit
 					return
 				}
@@ -465,17 +752,60 @@ func (v *VM) run() {
 				var val Object = &Bytes{
 					Value: left.Value[lowIdx:highIdx],
 				}
-				v.allocs--
-				if v.allocs == 0 {
+				if atomic.AddInt64(v.allocs, -1) <= 0 {
+					v.err = ErrObjectAllocLimit
+					return
+				}
+				v.stack[v.sp] = val
+				v.sp++
+			case *Range:
+				numElements := left.Len
+				var highIdx int64
+				if high == NilValue {
+					highIdx = numElements
+				} else if high, ok := high.(*Int); ok {
+					highIdx = high.Value
+				} else {
+					v.err = fmt.Errorf("invalid slice index type: %s",
+						high.TypeName())
+					return
+				}
+				if lowIdx > highIdx {
+					v.err = fmt.Errorf("invalid slice index: %d > %d",
+						lowIdx, highIdx)
+					return
+				}
+				if lowIdx < 0 {
+					lowIdx = 0
+				} else if lowIdx > numElements {
+					lowIdx = numElements
+				}
+				if highIdx < 0 {
+					highIdx = 0
+				} else if highIdx > numElements {
+					highIdx = numElements
+				}
+				r := &Range{
+					Start: left.Start + lowIdx*left.Step,
+					Stop:  left.Stop,
+					Step:  left.Step,
+					Len:   highIdx - lowIdx,
+				}
+				if highIdx < numElements {
+					r.Stop = r.Start + r.Len*r.Step
+				}
+				var val Object = r
+				if atomic.AddInt64(v.allocs, -1) <= 0 {
 					v.err = ErrObjectAllocLimit
 					return
 				}
 				v.stack[v.sp] = val
 				v.sp++
 			}
-		case parser.OpCall:
+		case parser.OpCall, parser.OpTailCall:
+			tailCall := v.curInsts[v.ip] == parser.OpTailCall
 			numArgs := int(v.curInsts[v.ip+1])
-			spread := int(v.curInsts[v.ip+2])
+			flags := int(v.curInsts[v.ip+2])
 			v.ip += 2
 
 			value := v.stack[v.sp-1-numArgs]
@@ -484,23 +814,70 @@ func (v *VM) run() {
 				return
 			}
 
-			if spread == 1 {
+			var kwargs map[string]Object
+			if flags&callKwargs != 0 {
+				v.sp--
+				numArgs--
+				kwargs = v.stack[v.sp].(*Map).Value
+			}
+			if flags&callSpread != 0 {
 				v.sp--
+				var elems []Object
 				switch arr := v.stack[v.sp].(type) {
 				case *Array:
-					for _, item := range arr.Value {
-						v.stack[v.sp] = item
-						v.sp++
-					}
-					numArgs += len(arr.Value) - 1
+					elems = arr.Value
+				case *Range:
+					elems = arr.appendTo(nil)
 				default:
 					v.err = fmt.Errorf("not an array: %s", arr.TypeName())
 					return
 				}
+				if v.sp+len(elems) >= StackSize {
+					v.err = ErrStackOverflow
+					return
+				}
+				v.sp += copy(v.stack[v.sp:], elems)
+				numArgs += len(elems) - 1
+			}
+
+			numSelf := 0 // self is not counted in the error messages
+			if method, ok := value.(*BoundMethod); ok {
+				// the instance is passed as the first argument
+				if v.sp >= StackSize {
+					v.err = ErrStackOverflow
+					return
+				}
+				args := v.stack[v.sp-numArgs : v.sp+1]
+				copy(args[1:], args)
+				args[0] = method.Self
+				v.stack[v.sp-numArgs-1] = method.Method
+				value = method.Method
+				numArgs++
+				numSelf++
+				v.sp++
+			}
+			if class, ok := value.(*Class); ok {
+				inst := v.construct(class, v.stack[v.sp-numArgs:v.sp], kwargs)
+				if v.err != nil {
+					return
+				}
+				v.sp -= numArgs + 1
+				if atomic.AddInt64(v.allocs, -1) <= 0 {
+					v.err = ErrObjectAllocLimit
+					return
+				}
+				v.stack[v.sp] = inst
+				v.sp++
+				continue
 			}
 
 			if callee, ok := value.(*CompiledFunction); ok {
-				if callee.VarArgs {
+				if kwargs != nil || callee.NumDefaults > 0 {
+					if numArgs = v.bindArgs(callee, numArgs, numSelf,
+						kwargs); v.err != nil {
+						return
+					}
+				} else if callee.VarArgs {
 					// if the closure is variadic,
 					// roll up all variadic parameters into an array
 					realArgs := callee.NumParameters - 1
@@ -520,31 +897,48 @@ func (v *VM) run() {
 					if callee.VarArgs {
 						v.err = fmt.Errorf(
 							"wrong number of arguments: want>=%d, got=%d",
-							callee.NumParameters-1, numArgs)
+							callee.NumParameters-1-numSelf, numArgs-numSelf)
 					} else {
 						v.err = fmt.Errorf(
 							"wrong number of arguments: want=%d, got=%d",
-							callee.NumParameters, numArgs)
+							callee.NumParameters-numSelf, numArgs-numSelf)
 					}
 					return
 				}
 
-				// test if it's tail-call
-				if callee == v.curFrame.fn { // recursion
-					nextOp := v.curInsts[v.ip+1]
-					if nextOp == parser.OpReturn ||
-						(nextOp == parser.OpPop &&
-							parser.OpReturn == v.curInsts[v.ip+2]) {
-						for p := 0; p < numArgs; p++ {
-							v.stack[v.curFrame.basePointer+p] =
-								v.stack[v.sp-numArgs+p]
-						}
-						v.sp -= numArgs + 1
-						v.ip = -1 // reset IP to beginning of the frame
-						continue
+				if callee.Generator {
+					// calling a generator function only creates the
+					// generator; the body runs when it's iterated
+					gen := newGenerator(v, callee, v.stack[v.sp-numArgs:v.sp])
+					v.sp -= numArgs + 1
+					if atomic.AddInt64(v.allocs, -1) <= 0 {
+						v.err = ErrObjectAllocLimit
+						return
 					}
+					v.stack[v.sp] = gen
+					v.sp++
+					continue
 				}
-				if v.framesIndex >= MaxFrames {
+
+				// a tail call replaces the current frame, unless the
+				// frame has deferred calls or runs a generator
+				if tailCall && len(v.curFrame.defers) == 0 &&
+					v.curFrame.gen == nil {
+					bp := v.curFrame.basePointer
+					if bp+callee.NumLocals >= StackSize {
+						v.err = ErrStackOverflow
+						return
+					}
+					copy(v.stack[bp:], v.stack[v.sp-numArgs:v.sp])
+					v.curFrame.fn = callee
+					v.curFrame.freeVars = callee.Free
+					v.curInsts = callee.Instructions
+					v.ip = -1 // reset IP to beginning of the frame
+					v.sp = bp + callee.NumLocals
+					continue
+				}
+				if v.framesIndex >= MaxFrames ||
+					v.sp-numArgs+callee.NumLocals >= StackSize {
 					v.err = ErrStackOverflow
 					return
 				}
@@ -555,14 +949,45 @@ func (v *VM) run() {
 				v.curFrame.fn = callee
 				v.curFrame.freeVars = callee.Free
 				v.curFrame.basePointer = v.sp - numArgs
+				v.curFrame.defers = nil
+				v.curFrame.gen = nil
 				v.curInsts = callee.Instructions
 				v.ip = -1
 				v.framesIndex++
 				v.sp = v.sp - numArgs + callee.NumLocals
 			} else {
+				kwValue := kwCallable(value)
+				if kwargs != nil && kwValue == nil {
+					v.err = fmt.Errorf(
+						"named arguments not supported in call to '%s'",
+						value.TypeName())
+					return
+				}
 				var args []Object
+				if fn, ok := value.(*BuiltinFunction); ok && fn.NeedVMObj {
+					// the VM is passed as the first argument
+					args = append(args, &VMObj{Value: v})
+				}
 				args = append(args, v.stack[v.sp-numArgs:v.sp]...)
-				ret, e := value.Call(args...)
+				if fn, ok := value.(*BuiltinFunction); ok && fn.Iterables {
+					// script-defined iterables are passed as arrays
+					for i, arg := range args {
+						if protocolFunc(arg, "__iter__") == nil &&
+							protocolFunc(arg, "__next__") == nil {
+							continue
+						}
+						if args[i] = v.collect(arg); v.err != nil {
+							return
+						}
+					}
+				}
+				var ret Object
+				var e error
+				if kwValue != nil {
+					ret, e = kwValue(kwargs, args...)
+				} else {
+					ret, e = value.Call(args...)
+				}
 				v.sp -= numArgs + 1
 
 				// runtime error
@@ -588,8 +1013,7 @@ func (v *VM) run() {
 				if ret == nil {
 					ret = NilValue
 				}
-				v.allocs--
-				if v.allocs == 0 {
+				if atomic.AddInt64(v.allocs, -allocCost(ret)) <= 0 {
 					v.err = ErrObjectAllocLimit
 					return
 				}
@@ -598,12 +1022,22 @@ func (v *VM) run() {
 			}
 		case parser.OpReturn:
 			v.ip++
+			if len(v.curFrame.defers) > 0 {
+				if v.runDefers(); v.err != nil {
+					return
+				}
+			}
 			var retVal Object
 			if int(v.curInsts[v.ip]) == 1 {
 				retVal = v.stack[v.sp-1]
 			} else {
 				retVal = NilValue
 			}
+			if gen := v.curFrame.gen; gen != nil {
+				// a finished generator reports no more elements
+				gen.finish()
+				retVal = FalseValue
+			}
 			//v.sp--
 			v.framesIndex--
 			v.curFrame = &v.frames[v.framesIndex-1]
@@ -698,11 +1132,13 @@ func (v *VM) run() {
 				Instructions:  fn.Instructions,
 				NumLocals:     fn.NumLocals,
 				NumParameters: fn.NumParameters,
+				NumDefaults:   fn.NumDefaults,
+				ParamNames:    fn.ParamNames,
 				VarArgs:       fn.VarArgs,
+				Generator:     fn.Generator,
 				Free:          free,
 			}
-			v.allocs--
-			if v.allocs == 0 {
+			if atomic.AddInt64(v.allocs, -1) <= 0 {
 				v.err = ErrObjectAllocLimit
 				return
 			}
@@ -761,28 +1197,60 @@ func (v *VM) run() {
 			var iterator Object
 			dst := v.stack[v.sp-1]
 			v.sp--
-			if !dst.CanIterate() {
-				v.err = fmt.Errorf("not iterable: %s", dst.TypeName())
+			if iterator = v.iterate(dst); v.err != nil {
 				return
 			}
-			iterator = dst.Iterate()
-			v.allocs--
-			if v.allocs == 0 {
+			if atomic.AddInt64(v.allocs, -1) <= 0 {
 				v.err = ErrObjectAllocLimit
 				return
 			}
 			v.stack[v.sp] = iterator
 			v.sp++
 		case parser.OpIteratorNext:
+			if gen, ok := v.stack[v.sp-1].(*Generator); ok {
+				if v.resume(gen); v.err != nil {
+					return
+				}
+				break
+			}
 			iterator := v.stack[v.sp-1]
 			v.sp--
 			hasMore := iterator.(Iterator).Next()
+			if v.err != nil {
+				// error from a script-defined iterator
+				return
+			}
 			if hasMore {
 				v.stack[v.sp] = TrueValue
 			} else {
 				v.stack[v.sp] = FalseValue
 			}
 			v.sp++
+		case parser.OpHasKey:
+			key := v.stack[v.sp-1]
+			m := v.stack[v.sp-2]
+			v.sp -= 2
+			var ok bool
+			if m, isMap := m.(*Map); isMap {
+				_, ok = m.Value[key.(*String).Value]
+			} else {
+				val, err := m.IndexGet(key)
+				ok = err == nil && val != NilValue
+			}
+			if ok {
+				v.stack[v.sp] = TrueValue
+			} else {
+				v.stack[v.sp] = FalseValue
+			}
+			v.sp++
+		case parser.OpIteratorClose:
+			iterator := v.stack[v.sp-1]
+			v.sp--
+			if gen, ok := iterator.(*Generator); ok {
+				if v.close(gen); v.err != nil {
+					return
+				}
+			}
 		case parser.OpIteratorKey:
 			iterator := v.stack[v.sp-1]
 			v.sp--
@@ -795,6 +1263,138 @@ func (v *VM) run() {
 			val := iterator.(Iterator).Value()
 			v.stack[v.sp] = val
 			v.sp++
+		case parser.OpSwitch:
+			v.ip += 4
+			cidx := int(v.curInsts[v.ip-2]) | int(v.curInsts[v.ip-3])<<8
+			pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+			value := v.stack[v.sp-1]
+			v.sp--
+			if key, ok := switchKey(value); ok {
+				table := v.constants[cidx].(*Map)
+				if dst, ok := table.Value[key]; ok {
+					pos = int(dst.(*Int).Value)
+				}
+			}
+			v.ip = pos - 1
+		case parser.OpSetupTry:
+			v.ip += 2
+			pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+			v.handlers = append(v.handlers, handler{
+				framesIndex: v.framesIndex,
+				sp:          v.sp,
+				pos:         pos,
+			})
+		case parser.OpPopTry:
+			v.handlers = v.handlers[:len(v.handlers)-1]
+		case parser.OpThrow:
+			v.ip++
+			value := v.stack[v.sp-1]
+			v.sp--
+			if exc, ok := value.(*Exception); ok {
+				// re-throw
+				v.err = exc
+				return
+			}
+			if v.curInsts[v.ip] == 0 {
+				v.err = &Exception{Value: value}
+				return
+			}
+		case parser.OpDefer:
+			numArgs := int(v.curInsts[v.ip+1])
+			flags := int(v.curInsts[v.ip+2])
+			v.ip += 2
+
+			value := v.stack[v.sp-1-numArgs]
+			if !value.CanCall() {
+				v.err = fmt.Errorf("not callable: %s", value.TypeName())
+				return
+			}
+			args := make([]Object, numArgs)
+			copy(args, v.stack[v.sp-numArgs:v.sp])
+			v.sp -= numArgs + 1
+			v.curFrame.defers = append(v.curFrame.defers, &deferred{
+				fn:    value,
+				args:  args,
+				flags: flags,
+				pos:   v.curFrame.fn.SourcePos(v.ip),
+			})
+		case parser.OpUnpack:
+			numElems := int(v.curInsts[v.ip+1])
+			rest := int(v.curInsts[v.ip+2])
+			v.ip += 2
+
+			value := v.stack[v.sp-1]
+			v.sp--
+			arr, ok := toArray(value)
+			if !ok {
+				v.err = fmt.Errorf("cannot unpack %s", value.TypeName())
+				return
+			}
+			if rest == 1 {
+				if len(arr.Value) < numElems {
+					v.err = fmt.Errorf(
+						"wrong number of values to unpack: want>=%d, got=%d",
+						numElems, len(arr.Value))
+					return
+				}
+				if atomic.AddInt64(v.allocs, -1) <= 0 {
+					v.err = ErrObjectAllocLimit
+					return
+				}
+				restElems := make([]Object, len(arr.Value)-numElems)
+				copy(restElems, arr.Value[numElems:])
+				v.stack[v.sp] = &Array{Value: restElems}
+				v.sp++
+			} else if len(arr.Value) != numElems {
+				v.err = fmt.Errorf(
+					"wrong number of values to unpack: want=%d, got=%d",
+					numElems, len(arr.Value))
+				return
+			}
+			for i := numElems - 1; i >= 0; i-- {
+				v.stack[v.sp] = arr.Value[i]
+				v.sp++
+			}
+		case parser.OpUnpackMap:
+			v.ip++
+			numKeys := int(v.curInsts[v.ip])
+			value := v.stack[v.sp-1-numKeys]
+			values := make([]Object, numKeys)
+			for i, key := range v.stack[v.sp-numKeys : v.sp] {
+				val, err := value.IndexGet(key)
+				if err != nil {
+					if err == ErrNotIndexable {
+						v.err = fmt.Errorf("cannot unpack %s",
+							value.TypeName())
+						return
+					}
+					v.err = err
+					return
+				}
+				if val == nil {
+					val = NilValue
+				}
+				values[i] = val
+			}
+			v.sp -= numKeys + 1
+			for i := numKeys - 1; i >= 0; i-- {
+				v.stack[v.sp] = values[i]
+				v.sp++
+			}
+		case parser.OpYield:
+			v.ip++
+			gen := v.curFrame.gen
+			gen.index++
+			gen.key = nil
+			if v.curInsts[v.ip] == 1 {
+				gen.key = v.stack[v.sp-2]
+				gen.value = v.stack[v.sp-1]
+				v.sp -= 2
+			} else {
+				gen.value = v.stack[v.sp-1]
+				v.sp--
+			}
+			v.suspend(gen)
 		case parser.OpSuspend:
 			return
 		default:
@@ -804,6 +1404,876 @@ func (v *VM) run() {
 	}
 }
 
+// throw passes the current error to the innermost exception handler as an
+// Exception, unwinding the frames above the handler. It returns false if
+// there's no handler or the error cannot be caught.
+func (v *VM) throw() bool {
+	if len(v.handlers) == 0 || v.err == ErrObjectAllocLimit {
+		return false
+	}
+
+	exc, ok := v.err.(*Exception)
+	if !ok {
+		exc = &Exception{
+			Value: &Error{Value: &String{Value: v.err.Error()}},
+			Err:   v.err,
+		}
+	}
+	if !exc.Pos.IsValid() {
+		exc.Pos = v.fileSet.Position(v.curFrame.fn.SourcePos(v.ip - 1))
+	}
+
+	h := v.handlers[len(v.handlers)-1]
+	v.handlers = v.handlers[:len(v.handlers)-1]
+	v.unwind(h.framesIndex)
+	v.curFrame = &v.frames[v.framesIndex-1]
+	v.curInsts = v.curFrame.fn.Instructions
+	v.sp = h.sp
+	v.stack[v.sp] = exc
+	v.sp++
+	v.ip = h.pos - 1
+	v.err = nil
+	return true
+}
+
+// unwind pops the frames above the given frame index, running their deferred
+// calls. Errors from the deferred calls are ignored in favor of the error
+// being unwound.
+func (v *VM) unwind(framesIndex int) {
+	err := v.err
+	for v.framesIndex > framesIndex {
+		v.curFrame = &v.frames[v.framesIndex-1]
+		v.curInsts = v.curFrame.fn.Instructions
+		if len(v.curFrame.defers) > 0 {
+			v.err = nil
+			v.runDefers()
+		}
+		if v.curFrame.gen != nil {
+			v.curFrame.gen.finish()
+		}
+		v.framesIndex--
+		v.sp = v.curFrame.basePointer
+		v.ip = v.frames[v.framesIndex-1].ip
+	}
+	v.err = err
+}
+
+// runDefers runs the deferred calls of the current frame in LIFO order. It
+// stops at the first error.
+func (v *VM) runDefers() {
+	f := v.curFrame
+	for len(f.defers) > 0 && atomic.LoadInt64(&v.aborting) == 0 {
+		d := f.defers[len(f.defers)-1]
+		f.defers = f.defers[:len(f.defers)-1]
+		if v.call(d.fn, d.args, d.flags, d.pos); v.err != nil {
+			return
+		}
+	}
+}
+
+// call calls the function from a trampoline frame pushed above the current
+// frame, and runs the VM until the call returns. It returns the result of the
+// call, or nil if v.err is set.
+func (v *VM) call(
+	callee Object,
+	args []Object,
+	flags int,
+	pos parser.Pos,
+) Object {
+	if v.framesIndex >= MaxFrames {
+		v.err = ErrStackOverflow
+		return nil
+	}
+
+	// CALL <args> <flags>; SUSPEND
+	fn := &CompiledFunction{
+		Instructions: append(
+			MakeInstruction(parser.OpCall, len(args), flags),
+			MakeInstruction(parser.OpSuspend)...),
+		SourceMap: map[int]parser.Pos{0: pos},
+	}
+	sp, framesIndex, numHandlers := v.sp, v.framesIndex, len(v.handlers)
+	v.curFrame.ip = v.ip
+	v.curFrame = &v.frames[v.framesIndex]
+	v.curFrame.fn = fn
+	v.curFrame.freeVars = nil
+	v.curFrame.basePointer = v.sp
+	v.curFrame.defers = nil
+	v.curFrame.gen = nil
+	v.curInsts = fn.Instructions
+	v.ip = -1
+	v.framesIndex++
+	v.stack[v.sp] = callee
+	v.sp++
+	for _, arg := range args {
+		v.stack[v.sp] = arg
+		v.sp++
+	}
+
+	v.run()
+	for v.err != nil && len(v.handlers) > numHandlers && v.throw() {
+		v.run()
+	}
+	var ret Object
+	if v.err != nil {
+		v.unwind(framesIndex + 1)
+	} else {
+		ret = v.stack[sp]
+	}
+
+	// back to the frame
+	v.handlers = v.handlers[:numHandlers]
+	v.framesIndex = framesIndex
+	v.curFrame = &v.frames[framesIndex-1]
+	v.curInsts = v.curFrame.fn.Instructions
+	v.ip = v.curFrame.ip
+	v.sp = sp
+	return ret
+}
+
+// callObject calls the function with the arguments at the current
+// instruction. It returns nil if v.err is set.
+func (v *VM) callObject(fn Object, args ...Object) Object {
+	return v.call(fn, args, 0, v.curFrame.fn.SourcePos(v.ip))
+}
+
+// iterate returns an iterator for the object. A map with a callable __iter__
+// is iterated over the result of calling it, and a map with a callable
+// __next__ is itself the iterator.
+func (v *VM) iterate(o Object) Iterator {
+	if fn := protocolFunc(o, "__iter__"); fn != nil {
+		if o = v.callObject(fn); v.err != nil {
+			return nil
+		}
+		if next := protocolFunc(o, "__next__"); next != nil {
+			return &ScriptIterator{vm: v, next: next}
+		}
+		if !o.CanIterate() {
+			v.err = fmt.Errorf("__iter__ returned non-iterable: %s",
+				o.TypeName())
+			return nil
+		}
+		return o.Iterate()
+	}
+	if next := protocolFunc(o, "__next__"); next != nil {
+		return &ScriptIterator{vm: v, next: next}
+	}
+	if ch, ok := o.(*Channel); ok {
+		return &ChannelIterator{v: ch.Value, abort: v.abortChan()}
+	}
+	if !o.CanIterate() {
+		v.err = fmt.Errorf("not iterable: %s", o.TypeName())
+		return nil
+	}
+	return o.Iterate()
+}
+
+// collect returns the elements of a script-defined iterable as an array. It
+// returns nil if v.err is set.
+func (v *VM) collect(o Object) *Array {
+	it := v.iterate(o)
+	if v.err != nil {
+		return nil
+	}
+	var elems []Object
+	for it.Next() {
+		elems = append(elems, it.Value())
+	}
+	if v.err != nil {
+		return nil
+	}
+	return &Array{Value: elems}
+}
+
+// protocolFunc returns the callable element of a map, or the method of an
+// instance bound to it, used by the iteration protocol and the operator
+// overloading. It returns nil if there's none.
+func protocolFunc(o Object, name string) Object {
+	switch o := o.(type) {
+	case *Map:
+		if fn, ok := o.Value[name]; ok && fn.CanCall() {
+			return fn
+		}
+	case *Instance:
+		if fn, ok := o.Class.Methods[name]; ok {
+			return &BoundMethod{Self: o, Method: fn}
+		}
+	}
+	return nil
+}
+
+// operatorFuncs maps the binary operators to the names of the map elements
+// overloading them. The comparison operators are overloaded by __cmp__.
+var operatorFuncs = map[parser.Token]string{
+	parser.TokenAdd:    "__add__",
+	parser.TokenSub:    "__sub__",
+	parser.TokenMul:    "__mul__",
+	parser.TokenQuo:    "__quo__",
+	parser.TokenRem:    "__rem__",
+	parser.TokenAnd:    "__and__",
+	parser.TokenOr:     "__or__",
+	parser.TokenXor:    "__xor__",
+	parser.TokenAndNot: "__andnot__",
+	parser.TokenShl:    "__shl__",
+	parser.TokenShr:    "__shr__",
+}
+
+// overloads returns true if either operand can overload the operators.
+func overloads(left, right Object) bool {
+	switch left.(type) {
+	case *Map, *Instance:
+		return true
+	}
+	switch right.(type) {
+	case *Map, *Instance:
+		return true
+	}
+	return false
+}
+
+// binaryOp returns the result of the binary operation. A map overloads the
+// operator with a callable element called with both operands, e.g.
+// __add__(left, right), and an instance with a method called the same way;
+// the left operand's function takes precedence.
+// Otherwise, the operation is left to Object.BinaryOp. It returns nil if
+// v.err is set.
+func (v *VM) binaryOp(tok parser.Token, left, right Object) Object {
+	if overloads(left, right) {
+		switch tok {
+		case parser.TokenGreater, parser.TokenGreaterEq:
+			if cmp, ok := v.compare(left, right); v.err != nil {
+				return nil
+			} else if ok {
+				if cmp > 0 || (cmp == 0 && tok == parser.TokenGreaterEq) {
+					return TrueValue
+				}
+				return FalseValue
+			}
+		default:
+			if name, ok := operatorFuncs[tok]; ok {
+				fn := protocolFunc(left, name)
+				if fn == nil {
+					fn = protocolFunc(right, name)
+				}
+				if fn != nil {
+					return v.callObject(fn, left, right)
+				}
+			}
+		}
+	}
+
+	res, err := left.BinaryOp(tok, right)
+	if err != nil {
+		if err == ErrInvalidOperator {
+			err = fmt.Errorf("invalid operation: %s %s %s",
+				left.TypeName(), tok.String(), right.TypeName())
+		}
+		v.err = err
+		return nil
+	}
+	return res
+}
+
+// checkedBinaryOp is like binaryOp, but it sets v.err to ErrIntOverflow if
+// the operation on ints overflows.
+func (v *VM) checkedBinaryOp(tok parser.Token, left, right Object) Object {
+	x, ok := left.(*Int)
+	if !ok {
+		return v.binaryOp(tok, left, right)
+	}
+	y, ok := right.(*Int)
+	if !ok {
+		return v.binaryOp(tok, left, right)
+	}
+	res, err := x.BinaryOp(tok, y)
+	if err != nil {
+		v.err = err
+		return nil
+	}
+	if r, ok := res.(*Int); ok && intOverflows(tok, x.Value, y.Value, r.Value) {
+		v.err = fmt.Errorf("%w: %d %s %d",
+			ErrIntOverflow, x.Value, tok.String(), y.Value)
+		return nil
+	}
+	return res
+}
+
+// intOverflows returns whether the result r of the operation x tok y has
+// overflowed, for the operators checked in checked arithmetic mode: +, -, *
+// and <<.
+func intOverflows(tok parser.Token, x, y, r int64) bool {
+	switch tok {
+	case parser.TokenAdd:
+		return (x^r)&(y^r) < 0
+	case parser.TokenSub:
+		return (x^y)&(x^r) < 0
+	case parser.TokenMul:
+		return x != 0 && (r/x != y || (x == -1 && y == math.MinInt64))
+	case parser.TokenShl:
+		if y < 0 || y >= 64 {
+			return x != 0
+		}
+		return r>>uint64(y) != x
+	}
+	return false
+}
+
+// compare returns the result of calling __cmp__(left, right) of either
+// operand, which must be a negative int if left is less than right, zero if
+// they're equal, or a positive int otherwise. It returns false if neither
+// operand overloads the comparison or v.err is set.
+func (v *VM) compare(left, right Object) (int64, bool) {
+	fn := protocolFunc(left, "__cmp__")
+	if fn == nil {
+		if fn = protocolFunc(right, "__cmp__"); fn == nil {
+			return 0, false
+		}
+	}
+	res := v.callObject(fn, left, right)
+	if v.err != nil {
+		return 0, false
+	}
+	cmp, ok := res.(*Int)
+	if !ok {
+		v.err = fmt.Errorf("invalid comparison result: expected int, found %s",
+			res.TypeName())
+		return 0, false
+	}
+	return cmp.Value, true
+}
+
+// equals returns whether the objects are equal, calling __eq__(left, right)
+// of either operand if it overloads the equality. It returns false if v.err
+// is set.
+func (v *VM) equals(left, right Object) bool {
+	if !overloads(left, right) {
+		return left.Equals(right)
+	}
+	fn := protocolFunc(left, "__eq__")
+	if fn == nil {
+		if fn = protocolFunc(right, "__eq__"); fn == nil {
+			return left.Equals(right)
+		}
+	}
+	res := v.callObject(fn, left, right)
+	if v.err != nil {
+		return false
+	}
+	return !res.IsFalsy()
+}
+
+// construct creates an instance of the class, calling its init method with
+// the arguments if there's one. It returns nil if v.err is set.
+func (v *VM) construct(
+	class *Class,
+	args []Object,
+	kwargs map[string]Object,
+) *Instance {
+	fn, ok := class.Methods["init"]
+	if !ok {
+		inst, err := class.New(args...)
+		if err != nil {
+			v.err = fmt.Errorf(
+				"wrong number of arguments in call to '%s': want<=%d, got=%d",
+				class.Name, len(class.Fields), len(args))
+			return nil
+		}
+		// the named arguments set the fields by name
+		bound := 0
+		for i, name := range class.Fields {
+			if value, ok := kwargs[name]; ok {
+				if i < len(args) {
+					v.err = fmt.Errorf(
+						"multiple values for field '%s' in call to '%s'",
+						name, class.Name)
+					return nil
+				}
+				inst.Fields[name] = value
+				bound++
+			}
+		}
+		if bound < len(kwargs) {
+			v.err = fmt.Errorf("unknown field '%s' in call to '%s'",
+				unknownArg(kwargs, class.Fields), class.Name)
+			return nil
+		}
+		return inst
+	}
+	inst, _ := class.New()
+	args = append([]Object{inst}, args...)
+	if kwargs == nil {
+		v.callObject(fn, args...)
+	} else {
+		v.call(fn, append(args, &Map{Value: kwargs}), callKwargs,
+			v.curFrame.fn.SourcePos(v.ip))
+	}
+	if v.err != nil {
+		return nil
+	}
+	return inst
+}
+
+// bindArgs binds the numArgs arguments on top of the stack and the named
+// arguments to the parameters of fn, and returns the number of parameters on
+// the stack. The parameters with default values that are not passed are left
+// unset until the function evaluates their default values.
+func (v *VM) bindArgs(
+	fn *CompiledFunction,
+	numArgs, numSelf int,
+	kwargs map[string]Object,
+) int {
+	numParams := fn.NumParameters
+	if fn.VarArgs {
+		numParams--
+	}
+	base := v.sp - numArgs
+	params := make([]Object, fn.NumParameters)
+	if numArgs > numParams {
+		if !fn.VarArgs {
+			v.err = fmt.Errorf("wrong number of arguments: want<=%d, got=%d",
+				numParams-numSelf, numArgs-numSelf)
+			return 0
+		}
+		// roll up all variadic arguments into an array
+		varArgs := make([]Object, numArgs-numParams)
+		copy(varArgs, v.stack[base+numParams:v.sp])
+		params[numParams] = &Array{Value: varArgs}
+		numArgs = numParams
+	} else if fn.VarArgs {
+		params[numParams] = &Array{}
+	}
+	copy(params, v.stack[base:base+numArgs])
+
+	names := fn.ParamNames
+	if len(names) > numParams {
+		names = names[:numParams]
+	}
+	bound := 0
+	for i, name := range names {
+		if arg, ok := kwargs[name]; ok {
+			if params[i] != nil {
+				v.err = fmt.Errorf("multiple values for argument '%s'", name)
+				return 0
+			}
+			params[i] = arg
+			bound++
+		}
+	}
+	if bound < len(kwargs) {
+		v.err = fmt.Errorf("unexpected argument '%s'",
+			unknownArg(kwargs, names))
+		return 0
+	}
+	for i := 0; i < numParams; i++ {
+		if params[i] != nil {
+			continue
+		}
+		if i < numParams-fn.NumDefaults {
+			v.err = fmt.Errorf("missing argument '%s'", names[i])
+			return 0
+		}
+		params[i] = unsetArg
+	}
+
+	if base+len(params) >= StackSize {
+		v.err = ErrStackOverflow
+		return 0
+	}
+	v.sp = base + copy(v.stack[base:], params)
+	return len(params)
+}
+
+// unknownArg returns the first name of the named arguments, in sorted order,
+// that is not in names.
+func unknownArg(kwargs map[string]Object, names []string) (unknown string) {
+	known := make(map[string]bool, len(names))
+	for _, name := range names {
+		known[name] = true
+	}
+	for name := range kwargs {
+		if !known[name] && (unknown == "" || name < unknown) {
+			unknown = name
+		}
+	}
+	return
+}
+
+// kwCallable returns the function of the callable object o that accepts
+// named arguments, or nil if it has none.
+func kwCallable(o Object) CallableKwFunc {
+	switch o := o.(type) {
+	case *BuiltinFunction:
+		return o.KwValue
+	case *UserFunction:
+		return o.KwValue
+	}
+	return nil
+}
+
+// resume runs the generator on top of the stack in a new frame, restoring the
+// state saved by suspend. The generator is replaced by true when it yields an
+// element, or by false when it returns.
+func (v *VM) resume(gen *Generator) {
+	if gen.done {
+		v.stack[v.sp-1] = FalseValue
+		return
+	}
+	if gen.running {
+		v.err = ErrGeneratorRunning
+		return
+	}
+	if v.framesIndex >= MaxFrames || v.sp+len(gen.stack) >= StackSize {
+		v.err = ErrStackOverflow
+		return
+	}
+
+	gen.running = true
+	v.curFrame.ip = v.ip
+	v.curFrame = &v.frames[v.framesIndex]
+	v.curFrame.fn = gen.fn
+	v.curFrame.freeVars = gen.fn.Free
+	v.curFrame.basePointer = v.sp
+	v.curFrame.defers = gen.defers
+	v.curFrame.gen = gen
+	v.framesIndex++
+	for _, h := range gen.handlers {
+		v.handlers = append(v.handlers, handler{
+			framesIndex: v.framesIndex,
+			sp:          v.sp + h.sp,
+			pos:         h.pos,
+		})
+	}
+	v.sp += copy(v.stack[v.sp:], gen.stack)
+	v.curInsts = gen.fn.Instructions
+	v.ip = gen.ip
+}
+
+// close finishes the generator left suspended by a loop exited early, running
+// its pending deferred calls in LIFO order. It stops at the first error.
+func (v *VM) close(gen *Generator) {
+	if gen.done || gen.running {
+		return
+	}
+	defers := gen.defers
+	gen.finish()
+	for i := len(defers) - 1; i >= 0; i-- {
+		if atomic.LoadInt64(&v.aborting) != 0 {
+			return
+		}
+		d := defers[i]
+		if v.call(d.fn, d.args, d.flags, d.pos); v.err != nil {
+			return
+		}
+	}
+}
+
+// suspend saves the state of the generator running in the current frame, and
+// returns to the caller with true on the stack.
+func (v *VM) suspend(gen *Generator) {
+	base := v.curFrame.basePointer
+	gen.ip = v.ip
+	gen.stack = append(gen.stack[:0], v.stack[base:v.sp]...)
+	gen.defers = v.curFrame.defers
+	gen.handlers = gen.handlers[:0]
+	n := len(v.handlers)
+	for n > 0 && v.handlers[n-1].framesIndex == v.framesIndex {
+		n--
+	}
+	for _, h := range v.handlers[n:] {
+		gen.handlers = append(gen.handlers, handler{
+			sp:  h.sp - base,
+			pos: h.pos,
+		})
+	}
+	v.handlers = v.handlers[:n]
+	gen.running = false
+
+	v.framesIndex--
+	v.curFrame = &v.frames[v.framesIndex-1]
+	v.curInsts = v.curFrame.fn.Instructions
+	v.ip = v.curFrame.ip
+	v.sp = base
+	v.stack[v.sp-1] = TrueValue
+}
+
+// spawn calls the function with the arguments on a new VM in its own
+// goroutine. The new VM shares the constants and the allocation budget with v.
+// The function, the arguments and the globals the routine uses are isolated,
+// so that the routine never shares an array, a map or a variable with v. It
+// returns ErrNotShareable if they hold a generator or an iterator, whose state
+// cannot be copied. The routine is aborted with v.
+func (v *VM) spawn(fn Object, args []Object) (*Routine, error) {
+	used, err := v.usedGlobals(append([]Object{fn}, args...))
+	if err != nil {
+		return nil, err
+	}
+	copies := make(map[Object]Object)
+	child := &VM{
+		constants: v.constants,
+		globals:   make([]Object, len(v.globals)),
+		fileSet:   v.fileSet,
+		maxAllocs: v.maxAllocs,
+		allocs:    v.allocs,
+	}
+	for i := range used {
+		if g := v.globals[i]; g != nil {
+			child.globals[i] = isolate(g, copies)
+		}
+	}
+	fn = isolate(fn, copies)
+	args = append([]Object(nil), args...)
+	for i, arg := range args {
+		args[i] = isolate(arg, copies)
+	}
+	r := &Routine{done: make(chan struct{})}
+	v.addChild(child)
+	v.routines.Add(1)
+	go func() {
+		defer v.routines.Done()
+		r.ret, r.err = child.runFunc(fn, args)
+		v.removeChild(child)
+		close(r.done)
+	}()
+	return r, nil
+}
+
+// usedGlobals returns the indexes of the globals read or written by the
+// functions reachable from the values, including the functions stored in
+// those globals. The other globals are never accessed by a routine running
+// the values. It returns ErrNotShareable if a reachable value is a generator
+// or an iterator.
+func (v *VM) usedGlobals(values []Object) (map[int]bool, error) {
+	used := make(map[int]bool)
+	seen := make(map[Object]bool)
+	var walk func(o Object) error
+	walk = func(o Object) error {
+		if o == nil || seen[o] {
+			return nil
+		}
+		seen[o] = true
+		switch o := o.(type) {
+		case Iterator:
+			return ErrNotShareable
+		case *Array:
+			for _, e := range o.Value {
+				if err := walk(e); err != nil {
+					return err
+				}
+			}
+		case *Map:
+			for _, e := range o.Value {
+				if err := walk(e); err != nil {
+					return err
+				}
+			}
+		case *Instance:
+			for _, e := range o.Fields {
+				if err := walk(e); err != nil {
+					return err
+				}
+			}
+			return walk(o.Class)
+		case *Class:
+			for _, e := range o.Defaults {
+				if err := walk(e); err != nil {
+					return err
+				}
+			}
+			for _, e := range o.Methods {
+				if err := walk(e); err != nil {
+					return err
+				}
+			}
+		case *BoundMethod:
+			if err := walk(o.Self); err != nil {
+				return err
+			}
+			return walk(o.Method)
+		case *CompiledFunction:
+			for _, p := range o.Free {
+				if err := walk(*p.Value); err != nil {
+					return err
+				}
+			}
+			insts := o.Instructions
+			for ip := 0; ip < len(insts); {
+				op := insts[ip]
+				operands, read := parser.ReadOperands(
+					parser.OpcodeOperands[op], insts[ip+1:])
+				switch op {
+				case parser.OpGetGlobal, parser.OpSetGlobal,
+					parser.OpSetSelGlobal:
+					if !used[operands[0]] {
+						used[operands[0]] = true
+						if err := walk(v.globals[operands[0]]); err != nil {
+							return err
+						}
+					}
+				case parser.OpConstant, parser.OpClosure:
+					if err := walk(v.constants[operands[0]]); err != nil {
+						return err
+					}
+				}
+				ip += 1 + read
+			}
+		}
+		return nil
+	}
+	for _, o := range values {
+		if err := walk(o); err != nil {
+			return nil, err
+		}
+	}
+	return used, nil
+}
+
+// addChild registers the VM to be aborted with v.
+func (v *VM) addChild(child *VM) {
+	v.abortMu.Lock()
+	if v.children == nil {
+		v.children = make(map[*VM]struct{})
+	}
+	v.children[child] = struct{}{}
+	v.abortMu.Unlock()
+	if atomic.LoadInt64(&v.aborting) != 0 {
+		child.Abort()
+	}
+}
+
+// removeChild unregisters the VM registered by addChild.
+func (v *VM) removeChild(child *VM) {
+	v.abortMu.Lock()
+	delete(v.children, child)
+	v.abortMu.Unlock()
+}
+
+// isolate returns a copy of the value that shares no mutable state with it:
+// the arrays, maps and instances in it are copied, and so are the free
+// variables of the functions. The other values are shared.
+func isolate(o Object, copies map[Object]Object) Object {
+	if c, ok := copies[o]; ok {
+		return c
+	}
+	switch o := o.(type) {
+	case *Array:
+		res := &Array{
+			Value:     make([]Object, len(o.Value)),
+			Immutable: o.Immutable,
+		}
+		copies[o] = res
+		for i, v := range o.Value {
+			res.Value[i] = isolate(v, copies)
+		}
+		return res
+	case *Range:
+		// a range is materialized when an element is set
+		res := o.Copy()
+		copies[o] = res
+		return res
+	case *Map:
+		res := &Map{
+			Value:     make(map[string]Object, len(o.Value)),
+			Immutable: o.Immutable,
+		}
+		copies[o] = res
+		for k, v := range o.Value {
+			res.Value[k] = isolate(v, copies)
+		}
+		return res
+	case *Instance:
+		res := &Instance{
+			Class:  o.Class,
+			Fields: make(map[string]Object, len(o.Fields)),
+		}
+		copies[o] = res
+		for k, v := range o.Fields {
+			res.Fields[k] = isolate(v, copies)
+		}
+		return res
+	case *BoundMethod:
+		res := &BoundMethod{}
+		copies[o] = res
+		res.Self = isolate(o.Self, copies).(*Instance)
+		res.Method = isolate(o.Method, copies).(*CompiledFunction)
+		return res
+	case *CompiledFunction:
+		if len(o.Free) == 0 {
+			return o
+		}
+		res := *o
+		res.Free = make([]*ObjectPtr, len(o.Free))
+		copies[o] = &res
+		for i, p := range o.Free {
+			c, ok := copies[p]
+			if !ok {
+				val := isolate(*p.Value, copies)
+				c = &ObjectPtr{Value: &val}
+				copies[p] = c
+			}
+			res.Free[i] = c.(*ObjectPtr)
+		}
+		return &res
+	}
+	return o
+}
+
+// callFunc calls the function with the arguments on a new VM sharing the
+// constants, the globals and the allocation budget with v, and returns the
+// result of the call. It's used to call script functions from Go, and the new
+// VM is aborted with v.
+func (v *VM) callFunc(fn Object, args []Object) (Object, error) {
+	child := &VM{
+		constants: v.constants,
+		globals:   v.globals,
+		fileSet:   v.fileSet,
+		maxAllocs: v.maxAllocs,
+		allocs:    v.allocs,
+	}
+	v.addChild(child)
+	defer v.removeChild(child)
+	return child.runFunc(fn, args)
+}
+
+// runFunc runs the function with the arguments from a trampoline frame, and
+// returns the result of the call. The error is returned as is, so that an
+// exception thrown by the function can be rethrown by the caller.
+func (v *VM) runFunc(fn Object, args []Object) (ret Object, err error) {
+	// CALL <args> 0; SUSPEND
+	v.frames[0].fn = &CompiledFunction{
+		Instructions: append(
+			MakeInstruction(parser.OpCall, len(args), 0),
+			MakeInstruction(parser.OpSuspend)...),
+	}
+	v.curFrame = &v.frames[0]
+	v.curInsts = v.curFrame.fn.Instructions
+	v.framesIndex = 1
+	v.ip = -1
+	v.stack[0] = fn
+	v.sp = 1 + copy(v.stack[1:], args)
+
+	v.run()
+	for v.err != nil && v.throw() {
+		v.run()
+	}
+	switch {
+	case v.err != nil:
+		err = v.err
+		v.unwind(1)
+	case atomic.LoadInt64(&v.aborting) != 0:
+		ret = NilValue
+	default:
+		ret = v.stack[0]
+	}
+	v.stopRoutines()
+	return ret, err
+}
+
+// stopRoutines aborts the routines spawned by v, and waits for them to return.
+func (v *VM) stopRoutines() {
+	v.abortChildren()
+	v.routines.Wait()
+}
+
 // IsStackEmpty tests if the stack is empty or not.
 func (v *VM) IsStackEmpty() bool {
 	return v.sp == 0
@@ -837,3 +2307,53 @@ func indexAssign(dst, src Object, selectors []Object) error {
 	}
 	return nil
 }
+
+// switchKey returns the jump table key of a switch case value. Only the
+// values that can appear as a literal case constant have a key; a bigint or a
+// decimal equal to an int has the key of the int.
+func switchKey(o Object) (string, bool) {
+	switch o := o.(type) {
+	case *Int:
+		return "i" + strconv.FormatInt(o.Value, 10), true
+	case *BigInt:
+		if o.Value.IsInt64() {
+			return "i" + strconv.FormatInt(o.Value.Int64(), 10), true
+		}
+	case *Decimal:
+		if r := o.Rat(); r.IsInt() && r.Num().IsInt64() {
+			return "i" + strconv.FormatInt(r.Num().Int64(), 10), true
+		}
+	case *String:
+		return "s" + o.Value, true
+	case *Char:
+		return "c" + string(o.Value), true
+	case *Bool:
+		return "b" + o.String(), true
+	}
+	return "", false
+}
+
+// maxCompPrealloc is the maximum number of elements preallocated for the
+// result of a comprehension over a range, which may be filtered.
+const maxCompPrealloc = 1 << 16
+
+// iterableLen returns the number of elements of the iterable if it's known
+// without iterating, or 0.
+func iterableLen(o Object) int {
+	switch o := o.(type) {
+	case *Array:
+		return len(o.Value)
+	case *Map:
+		return len(o.Value)
+	case *Bytes:
+		return len(o.Value)
+	case *String:
+		return len(o.Value)
+	case *Range:
+		if o.len() > maxCompPrealloc {
+			return maxCompPrealloc
+		}
+		return int(o.len())
+	}
+	return 0
+}